@@ -0,0 +1,514 @@
+package midi
+
+// This file contains functions for analyzing the musical content of SMF files.
+
+import (
+	"fmt"
+	"math"
+)
+
+// Returns the total number of ticks that each pitch class (C = 0, C# = 1,
+// etc.) sounds for in the file. Notes on percussion channels, as reported by
+// PercussionChannels, are ignored. Notes that never end are considered to last
+// until the final event in their track, and every note contributes at least
+// one tick.
+func (f *SMFFile) pitchClassHistogram() [12]float64 {
+	var toReturn [12]float64
+	percussion := f.PercussionChannels()
+	for _, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		if len(ticks) == 0 {
+			continue
+		}
+		trackEnd := ticks[len(ticks)-1]
+		for _, p := range t.pairNotes() {
+			noteOn := t.Messages[p.onIndex].(*NoteOnEvent)
+			if percussion[noteOn.Channel&0xf] {
+				continue
+			}
+			end := trackEnd
+			if p.offIndex >= 0 {
+				end = ticks[p.offIndex]
+			}
+			duration := float64(end - ticks[p.onIndex])
+			if duration < 1 {
+				duration = 1
+			}
+			toReturn[noteOn.Note%12] += duration
+		}
+	}
+	return toReturn
+}
+
+// The Krumhansl-Kessler key profiles, starting from the tonic.
+var majorKeyProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52,
+	5.19, 2.39, 3.66, 2.29, 2.88}
+var minorKeyProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54,
+	4.75, 3.98, 2.69, 3.34, 3.17}
+
+// Maps the tonic pitch class of a major key to the number of sharps (if
+// positive) or flats (if negative) in its key signature.
+var majorKeySharpsOrFlats = [12]int8{0, -5, 2, -3, 4, -1, 6, 1, -4, 3, -2, 5}
+
+// Returns the Pearson correlation coefficient between the histogram and the
+// given key profile, rotated so that the profile's tonic is the given pitch
+// class.
+func keyCorrelation(histogram, profile [12]float64, tonic int) float64 {
+	var histogramMean, profileMean float64
+	for i := 0; i < 12; i++ {
+		histogramMean += histogram[i]
+		profileMean += profile[i]
+	}
+	histogramMean /= 12
+	profileMean /= 12
+	var numerator, histogramSquares, profileSquares float64
+	for i := 0; i < 12; i++ {
+		a := histogram[(i+tonic)%12] - histogramMean
+		b := profile[i] - profileMean
+		numerator += a * b
+		histogramSquares += a * a
+		profileSquares += b * b
+	}
+	denominator := math.Sqrt(histogramSquares * profileSquares)
+	if denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
+
+// Estimates the key of the file using the Krumhansl-Schmuckler algorithm:
+// builds a histogram of pitch classes, weighted by note duration, and
+// correlates it against the profile for each major and minor key. Returns
+// the best-matching key, along with its correlation coefficient (between -1
+// and 1) as a measure of confidence. Returns nil and 0 if the file doesn't
+// contain any non-percussion notes.
+func (f *SMFFile) EstimateKey() (*KeySignatureMetaEvent, float64) {
+	histogram := f.pitchClassHistogram()
+	total := 0.0
+	for _, v := range histogram {
+		total += v
+	}
+	if total == 0 {
+		return nil, 0
+	}
+	bestTonic := 0
+	bestIsMinor := false
+	bestScore := math.Inf(-1)
+	for tonic := 0; tonic < 12; tonic++ {
+		score := keyCorrelation(histogram, majorKeyProfile, tonic)
+		if score > bestScore {
+			bestScore = score
+			bestTonic = tonic
+			bestIsMinor = false
+		}
+		score = keyCorrelation(histogram, minorKeyProfile, tonic)
+		if score > bestScore {
+			bestScore = score
+			bestTonic = tonic
+			bestIsMinor = true
+		}
+	}
+	// A minor key has the same key signature as the major key three
+	// semitones above it.
+	relativeMajor := bestTonic
+	if bestIsMinor {
+		relativeMajor = (bestTonic + 3) % 12
+	}
+	return &KeySignatureMetaEvent{
+		SharpOrFlatCount: majorKeySharpsOrFlats[relativeMajor],
+		IsMinor:          bestIsMinor,
+	}, bestScore
+}
+
+// Returns the pitch class of the tonic of the major key with the given number
+// of sharps (if positive) or flats (if negative). Each sharp moves the tonic
+// up by a fifth.
+func majorKeyTonic(sharpsOrFlats int8) int {
+	return ((int(sharpsOrFlats)*7)%12 + 12) % 12
+}
+
+// Returns pointers to the note numbers in every note-on, note-off, and
+// polyphonic aftertouch event in the file, other than those on the channels
+// for which skip is true.
+func (f *SMFFile) noteNumbers(skip [16]bool) []*MIDINote {
+	var toReturn []*MIDINote
+	for _, t := range f.Tracks {
+		for _, m := range t.Messages {
+			var channel uint8
+			var note *MIDINote
+			switch v := m.(type) {
+			case *NoteOnEvent:
+				channel, note = v.Channel, &(v.Note)
+			case *NoteOffEvent:
+				channel, note = v.Channel, &(v.Note)
+			case *AftertouchEvent:
+				channel, note = v.Channel, &(v.Note)
+			default:
+				continue
+			}
+			if skip[channel&0xf] {
+				continue
+			}
+			toReturn = append(toReturn, note)
+		}
+	}
+	return toReturn
+}
+
+// Moves every note in the file by the given number of semitones, which may be
+// negative. This applies to note-on, note-off, and aftertouch events. Notes on
+// the channels reported by PercussionChannels aren't moved, unless
+// includePercussion is true. Returns the number of events that were changed.
+// Returns an error, without modifying the file, if any note would be moved
+// outside of the valid range.
+func (f *SMFFile) Transpose(semitones int, includePercussion bool) (int,
+	error) {
+	var skip [16]bool
+	if !includePercussion {
+		skip = f.PercussionChannels()
+	}
+	notes := f.noteNumbers(skip)
+	for _, note := range notes {
+		n := int(*note) + semitones
+		if (n < 0) || (n > 127) {
+			return 0, fmt.Errorf("Transposing note %d by %d semitones is "+
+				"out of range", *note, semitones)
+		}
+	}
+	for _, note := range notes {
+		*note = MIDINote(int(*note) + semitones)
+	}
+	return len(notes), nil
+}
+
+// Transposes every note in the file, other than those on percussion channels,
+// so that the key estimated by EstimateKey becomes the target key, and returns
+// the number of semitones the notes were moved. The notes are moved by at most
+// 6 semitones in either direction, unless that would move a note outside of
+// the valid range, in which case they're moved by the corresponding interval
+// in the opposite direction. The transposition preserves the mode of the
+// music: if the estimated key is minor and the target is major (or vice
+// versa), the notes are moved into the target key signature's relative key
+// instead, e.g. music in A minor transposed to D major is moved to B minor.
+// Key signature events at tick 0 are replaced with the new key, and a new one
+// is inserted at the start of the first track if there isn't one; later key
+// signature events are transposed by the same interval. Returns an error,
+// without modifying the file, if the target is invalid, the file contains no
+// non-percussion notes, or the notes can't be moved into the target key
+// without going out of range.
+func (f *SMFFile) TransposeToKey(target *KeySignatureMetaEvent) (int, error) {
+	if (target.SharpOrFlatCount < -7) || (target.SharpOrFlatCount > 7) {
+		return 0, fmt.Errorf("Invalid target key: %s", target)
+	}
+	current, _ := f.EstimateKey()
+	if current == nil {
+		return 0, fmt.Errorf("The file doesn't contain any non-percussion " +
+			"notes")
+	}
+	// Comparing the relative major keys keeps the music's mode.
+	shift := (majorKeyTonic(target.SharpOrFlatCount) -
+		majorKeyTonic(current.SharpOrFlatCount) + 12) % 12
+	if shift > 6 {
+		shift -= 12
+	}
+	notes := f.noteNumbers(f.PercussionChannels())
+	lowest, highest := 127, 0
+	for _, note := range notes {
+		if int(*note) < lowest {
+			lowest = int(*note)
+		}
+		if int(*note) > highest {
+			highest = int(*note)
+		}
+	}
+	if ((lowest + shift) < 0) || ((highest + shift) > 127) {
+		if shift > 0 {
+			shift -= 12
+		} else {
+			shift += 12
+		}
+		if ((lowest + shift) < 0) || ((highest + shift) > 127) {
+			return 0, fmt.Errorf("Can't transpose notes from %d to %d into "+
+				"%s without going out of range", lowest, highest, target)
+		}
+	}
+	for _, note := range notes {
+		*note = MIDINote(int(*note) + shift)
+	}
+	newKey := KeySignatureMetaEvent{
+		SharpOrFlatCount: target.SharpOrFlatCount,
+		IsMinor:          current.IsMinor,
+	}
+	replacedKey := false
+	for _, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		for i, m := range t.Messages {
+			v, ok := m.(*KeySignatureMetaEvent)
+			if !ok {
+				continue
+			}
+			if ticks[i] == 0 {
+				*v = newKey
+				replacedKey = true
+				continue
+			}
+			if (v.SharpOrFlatCount < -7) || (v.SharpOrFlatCount > 7) {
+				continue
+			}
+			tonic := (majorKeyTonic(v.SharpOrFlatCount) + shift + 12) % 12
+			v.SharpOrFlatCount = majorKeySharpsOrFlats[tonic]
+		}
+	}
+	if !replacedKey && (len(f.Tracks) != 0) {
+		f.Tracks[0].insertAndRemove([]insertedEvent{
+			{
+				index: 0,
+				event: timedMessage{
+					tick:    0,
+					message: &newKey,
+				},
+			},
+		}, nil)
+	}
+	return shift, nil
+}
+
+// Sweeps through all events in the file in time order, and returns the
+// maximum number of simultaneously sounding notes, both in total and for each
+// channel. Note-offs are processed before note-ons occurring at the same time,
+// so a note ending at the same time another starts doesn't count as an
+// overlap.
+func (f *SMFFile) polyphony() (int, [16]int) {
+	var channelPeaks [16]int
+	var channelCounts [16]int
+	active := make(map[channelNote]int)
+	total := 0
+	peak := 0
+	events := f.TimedEvents()
+	groupStart := 0
+	for groupStart < len(events) {
+		groupEnd := groupStart + 1
+		for (groupEnd < len(events)) &&
+			(events[groupEnd].Tick == events[groupStart].Tick) {
+			groupEnd++
+		}
+		group := events[groupStart:groupEnd]
+		for _, event := range group {
+			channel, note, ok := noteOffInfo(event.Message)
+			if !ok {
+				continue
+			}
+			channel &= 0xf
+			key := channelNote{channel, note}
+			if active[key] == 0 {
+				continue
+			}
+			active[key]--
+			channelCounts[channel]--
+			total--
+		}
+		for _, event := range group {
+			if !isNoteOn(event.Message) {
+				continue
+			}
+			v := event.Message.(*NoteOnEvent)
+			channel := v.Channel & 0xf
+			active[channelNote{channel, v.Note}]++
+			channelCounts[channel]++
+			total++
+			if channelCounts[channel] > channelPeaks[channel] {
+				channelPeaks[channel] = channelCounts[channel]
+			}
+			if total > peak {
+				peak = total
+			}
+		}
+		groupStart = groupEnd
+	}
+	return peak, channelPeaks
+}
+
+// Returns the maximum number of notes sounding at the same time, across all
+// tracks and channels in the file.
+func (f *SMFFile) MaxPolyphony() int {
+	peak, _ := f.polyphony()
+	return peak
+}
+
+// Returns the maximum number of notes sounding at the same time on each
+// channel in the file.
+func (f *SMFFile) MaxPolyphonyPerChannel() [16]int {
+	_, channelPeaks := f.polyphony()
+	return channelPeaks
+}
+
+// The maximum number of time windows that NoteDensity will return, rows that
+// ChannelPianoRoll and PianoRoll will return, or bar lines that BarLines will
+// return. Since the number of windows depends on the time of a file's final
+// event, a single event with a large time delta could otherwise require a huge
+// allocation.
+const MaxTimeWindows = 1 << 18
+
+// Returns the number of windows of the given size needed to cover every tick
+// up to and including lastTick, or an error if it exceeds MaxTimeWindows.
+func timeWindowCount(lastTick, windowTicks uint32) (int, error) {
+	count := uint64(lastTick)/uint64(windowTicks) + 1
+	if count > MaxTimeWindows {
+		return 0, fmt.Errorf("Covering %d ticks using %d-tick windows "+
+			"requires %d windows, more than the limit of %d",
+			uint64(lastTick)+1, windowTicks, count, MaxTimeWindows)
+	}
+	return int(count), nil
+}
+
+// Returns the number of note-on events (with nonzero velocity) in each
+// consecutive window of windowTicks ticks, starting at tick 0 and covering
+// every event in the file, across all tracks. For example, the value at index
+// 2 is the number of notes starting at or after tick 2 * windowTicks, but
+// before tick 3 * windowTicks. Returns nil if windowTicks is 0 or the file
+// contains no events. Returns an error if more than MaxTimeWindows windows
+// would be needed; use a larger window in that case.
+func (f *SMFFile) NoteDensity(windowTicks uint32) ([]int, error) {
+	if windowTicks == 0 {
+		return nil, nil
+	}
+	events := f.TimedEvents()
+	if len(events) == 0 {
+		return nil, nil
+	}
+	count, e := timeWindowCount(events[len(events)-1].Tick, windowTicks)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := make([]int, count)
+	for _, event := range events {
+		if isNoteOn(event.Message) {
+			toReturn[event.Tick/windowTicks]++
+		}
+	}
+	return toReturn, nil
+}
+
+// If m is a Roland GS "use for rhythm part" sysex message, this returns the
+// channel it affects, whether the channel is set to be a drum part, and true.
+// Returns false for any other message.
+func gsRhythmPartChannel(m MIDIMessage) (uint8, bool, bool) {
+	sysex, ok := m.(*SystemExclusiveMessage)
+	if !ok {
+		return 0, false, false
+	}
+	// Roland, any device ID, GS model ID, data set command, then the
+	// address 40 1x 15, the value, and a checksum.
+	data := sysex.DataBytes
+	if (len(data) != 9) || (data[0] != 0x41) || (data[2] != 0x42) ||
+		(data[3] != 0x12) || (data[4] != 0x40) || ((data[5] & 0xf0) != 0x10) ||
+		(data[6] != 0x15) {
+		return 0, false, false
+	}
+	// GS numbers its blocks so that block 0 is part 10, blocks 1 through 9
+	// are parts 1 through 9, and the rest are parts 11 through 16.
+	block := data[5] & 0x0f
+	channel := block - 1
+	if block == 0 {
+		channel = 9
+	} else if block >= 10 {
+		channel = block
+	}
+	return channel, data[7] != 0, true
+}
+
+// Returns which channels are used for percussion at any point in the file.
+// Channel 9 (channel 10 when numbered from 1), which General MIDI uses for
+// percussion, is always considered to be a percussion channel. Other channels
+// are considered to be percussion channels if they're set to a drum part
+// using a Roland GS "use for rhythm part" sysex message, or if a drum bank
+// (bank select MSB 127, used by Yamaha XG, or 120, used by General MIDI 2) is
+// selected on them. The result can be passed to the SMFTrack methods that
+// take a set of percussion channels to skip.
+func (f *SMFFile) PercussionChannels() [16]bool {
+	var toReturn [16]bool
+	toReturn[gmPercussionChannel] = true
+	for _, event := range f.TimedEvents() {
+		switch v := event.Message.(type) {
+		case *ControlChangeEvent:
+			if (v.ControllerNumber == 0) &&
+				((v.Value == 127) || (v.Value == 120)) {
+				toReturn[v.Channel&0xf] = true
+			}
+		case *SystemExclusiveMessage:
+			channel, isDrums, ok := gsRhythmPartChannel(v)
+			if ok && isDrums {
+				toReturn[channel] = true
+			}
+		}
+	}
+	return toReturn
+}
+
+// Returns true if the file contains at least one note, and every note is on
+// a percussion channel, as determined by PercussionChannels.
+func (f *SMFFile) IsPercussionOnly() bool {
+	percussion := f.PercussionChannels()
+	hasNotes := false
+	for _, t := range f.Tracks {
+		for _, m := range t.Messages {
+			if !isNoteOn(m) {
+				continue
+			}
+			if !percussion[m.(*NoteOnEvent).Channel&0xf] {
+				return false
+			}
+			hasNotes = true
+		}
+	}
+	return hasNotes
+}
+
+// Summarizes the velocities of the note-on events in a file. Obtain one using
+// SMFFile.VelocityStats().
+type VelocityStat struct {
+	// The number of note-on events with a nonzero velocity.
+	Count int
+	// The minimum and maximum velocities. Both are 0 if Count is 0.
+	Min uint8
+	Max uint8
+	// The average velocity, or 0 if Count is 0.
+	Mean float64
+	// The number of note-on events with each velocity. Histogram[0] is always
+	// 0, since zero-velocity note-ons are note-offs.
+	Histogram [128]int
+}
+
+// Returns statistics about the velocities of every note-on event in the file,
+// across all tracks and channels. Note-on events with a velocity of 0 are
+// ignored, since they're actually note-offs. Invalid velocities above 127,
+// which can only occur in events created in code, are counted as 127. A file
+// in which every note has the same velocity will have Min equal to Max.
+func (f *SMFFile) VelocityStats() VelocityStat {
+	var toReturn VelocityStat
+	total := 0
+	for _, t := range f.Tracks {
+		for _, m := range t.Messages {
+			if !isNoteOn(m) {
+				continue
+			}
+			velocity := m.(*NoteOnEvent).Velocity
+			if velocity > 127 {
+				velocity = 127
+			}
+			if (toReturn.Count == 0) || (velocity < toReturn.Min) {
+				toReturn.Min = velocity
+			}
+			if velocity > toReturn.Max {
+				toReturn.Max = velocity
+			}
+			toReturn.Histogram[velocity]++
+			toReturn.Count++
+			total += int(velocity)
+		}
+	}
+	if toReturn.Count != 0 {
+		toReturn.Mean = float64(total) / float64(toReturn.Count)
+	}
+	return toReturn
+}
@@ -0,0 +1,284 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestIsPercussionOnly(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 9, Note: 36, Velocity: 100},
+			&NoteOnEvent{Channel: 9, Note: 36, Velocity: 0},
+			&NoteOnEvent{Channel: 10, Note: 38, Velocity: 100},
+			&NoteOnEvent{Channel: 10, Note: 38, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 0, 10, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	if smf.IsPercussionOnly() {
+		t.Logf("Channel 10 was incorrectly considered to be percussion\n")
+		t.FailNow()
+	}
+	// Set part 11 (channel 10) to be a drum part using GS sysex.
+	gsDrums := &SystemExclusiveMessage{
+		DataBytes: []byte{0x41, 0x10, 0x42, 0x12, 0x40, 0x1a, 0x15, 0x02,
+			0x0f},
+	}
+	track.Messages = append([]MIDIMessage{gsDrums}, track.Messages...)
+	track.TimeDeltas = append([]uint32{0}, track.TimeDeltas...)
+	if !smf.IsPercussionOnly() {
+		t.Logf("Channel 10 wasn't detected as a GS drum part\n")
+		t.FailNow()
+	}
+	percussion := smf.PercussionChannels()
+	for i, isPercussion := range percussion {
+		if isPercussion != ((i == 9) || (i == 10)) {
+			t.Logf("Got incorrect percussion channels: %v\n", percussion)
+			t.FailNow()
+		}
+	}
+}
+
+func TestVelocityStats(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 40},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+					&NoteOffEvent{Channel: 0, Note: 62, Velocity: 64},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 10, 0, 10, 0, 10, 0},
+			},
+		},
+	}
+	stats := smf.VelocityStats()
+	if (stats.Count != 3) || (stats.Min != 40) || (stats.Max != 100) ||
+		(stats.Mean != 80) {
+		t.Logf("Got incorrect velocity stats\n")
+		t.FailNow()
+	}
+	if (stats.Histogram[40] != 1) || (stats.Histogram[100] != 2) ||
+		(stats.Histogram[0] != 0) {
+		t.Logf("Got incorrect velocity histogram\n")
+		t.FailNow()
+	}
+	// Invalid velocities should be clamped to 127, rather than wrapping around
+	// to silent notes.
+	smf.Tracks[0].Messages[0].(*NoteOnEvent).Velocity = 128
+	stats = smf.VelocityStats()
+	if (stats.Count != 3) || (stats.Min != 100) || (stats.Max != 127) ||
+		(stats.Histogram[127] != 1) || (stats.Histogram[0] != 0) {
+		t.Logf("Got incorrect stats for an invalid velocity: %+v\n", stats)
+		t.FailNow()
+	}
+	empty := &SMFFile{Division: TimeDivision(96)}
+	stats = empty.VelocityStats()
+	if (stats.Count != 0) || (stats.Min != 0) || (stats.Mean != 0) {
+		t.Logf("Got incorrect stats for a file with no notes: %+v\n", stats)
+		t.FailNow()
+	}
+}
+
+func TestTransposeToKey(t *testing.T) {
+	// A C major scale with a long tonic chord, and a drum note that shouldn't
+	// be moved.
+	track := &SMFTrack{}
+	for _, n := range []MIDINote{60, 62, 64, 65, 67, 69, 71, 72} {
+		track.AddNote(24, 0, n, 100, 24)
+	}
+	track.AddNote(24, 0, 48, 100, 192)
+	track.AddNote(0, 0, 64, 100, 192)
+	track.AddNote(0, 0, 67, 100, 192)
+	track.AddNote(0, 9, 38, 100, 24)
+	track.Append(0, EndOfTrackMetaEvent(0))
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	key, _ := smf.EstimateKey()
+	if (key == nil) || (key.SharpOrFlatCount != 0) || key.IsMinor {
+		t.Logf("Expected the test file to be in C major, got %v\n", key)
+		t.FailNow()
+	}
+	shift, e := smf.TransposeToKey(&KeySignatureMetaEvent{
+		SharpOrFlatCount: 2,
+	})
+	if e != nil {
+		t.Logf("Failed transposing to D major: %s\n", e)
+		t.FailNow()
+	}
+	if shift != 2 {
+		t.Logf("Expected a shift of 2 semitones, got %d\n", shift)
+		t.FailNow()
+	}
+	first, ok := track.Messages[0].(*KeySignatureMetaEvent)
+	if !ok || (first.SharpOrFlatCount != 2) || first.IsMinor {
+		t.Logf("Didn't insert the new key signature: %s\n", track.Messages[0])
+		t.FailNow()
+	}
+	if track.Messages[1].(*NoteOnEvent).Note != 62 {
+		t.Logf("Didn't transpose the first note: %s\n", track.Messages[1])
+		t.FailNow()
+	}
+	for _, m := range track.Messages {
+		v, ok := m.(*NoteOnEvent)
+		if ok && (v.Channel == 9) && (v.Note != 38) {
+			t.Logf("Transposed a percussion note: %s\n", v)
+			t.FailNow()
+		}
+	}
+	key, _ = smf.EstimateKey()
+	if key.SharpOrFlatCount != 2 {
+		t.Logf("The transposed file is in the wrong key: %s\n", key)
+		t.FailNow()
+	}
+	// G major is 5 semitones above D major, and the existing key signature
+	// should be replaced.
+	shift, e = smf.TransposeToKey(&KeySignatureMetaEvent{
+		SharpOrFlatCount: 1,
+	})
+	if (e != nil) || (shift != 5) {
+		t.Logf("Got incorrect shift to G major: %d (error: %v)\n", shift, e)
+		t.FailNow()
+	}
+	if track.Messages[0].(*KeySignatureMetaEvent).SharpOrFlatCount != 1 {
+		t.Logf("Didn't replace the key signature: %s\n", track.Messages[0])
+		t.FailNow()
+	}
+	_, e = smf.TransposeToKey(&KeySignatureMetaEvent{SharpOrFlatCount: 9})
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid key\n")
+		t.FailNow()
+	}
+}
+
+func TestNoteDensity(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 99, 1, 0, 200},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 1, Note: 48, Velocity: 100},
+					&NoteOffEvent{Channel: 1, Note: 48},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{150, 10, 0},
+			},
+		},
+	}
+	// Ticks 0-99 contain two note-ons, 100-199 contain one (the note-offs
+	// at tick 100 don't count), and the track ends at tick 300.
+	expected := []int{2, 1, 0, 0}
+	density, e := smf.NoteDensity(100)
+	if e != nil {
+		t.Logf("Failed getting note density: %s\n", e)
+		t.FailNow()
+	}
+	if len(density) != len(expected) {
+		t.Logf("Expected %d windows, got %d: %v\n", len(expected),
+			len(density), density)
+		t.FailNow()
+	}
+	for i, v := range expected {
+		if density[i] != v {
+			t.Logf("Expected %d notes in window %d, got %d\n", v, i,
+				density[i])
+			t.FailNow()
+		}
+	}
+	density, e = smf.NoteDensity(0)
+	if (e != nil) || (density != nil) {
+		t.Logf("Expected nil for a window size of 0\n")
+		t.FailNow()
+	}
+	density, e = (&SMFFile{}).NoteDensity(100)
+	if (e != nil) || (density != nil) {
+		t.Logf("Expected nil for a file without events\n")
+		t.FailNow()
+	}
+
+	// A single event with a huge time delta shouldn't cause a huge
+	// allocation.
+	smf.Tracks[1].TimeDeltas[2] = 0xffffff00
+	_, e = smf.NoteDensity(1)
+	if e == nil {
+		t.Logf("Didn't get an error for too many windows\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for too many windows: %s\n", e)
+}
+
+func TestTranspose(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 9, Note: 36, Velocity: 100},
+					&AftertouchEvent{Channel: 0, Note: 60, Pressure: 5},
+					&NoteOffEvent{Channel: 0, Note: 60},
+					&NoteOffEvent{Channel: 9, Note: 36},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 10, 10, 0, 0},
+			},
+		},
+	}
+	count, e := smf.Transpose(-3, false)
+	if e != nil {
+		t.Logf("Failed transposing notes: %s\n", e)
+		t.FailNow()
+	}
+	if count != 3 {
+		t.Logf("Expected to transpose 3 events, got %d\n", count)
+		t.FailNow()
+	}
+	messages := smf.Tracks[0].Messages
+	if (messages[0].(*NoteOnEvent).Note != 57) ||
+		(messages[2].(*AftertouchEvent).Note != 57) ||
+		(messages[3].(*NoteOffEvent).Note != 57) {
+		t.Logf("The notes weren't transposed correctly\n")
+		t.FailNow()
+	}
+	if messages[1].(*NoteOnEvent).Note != 36 {
+		t.Logf("A percussion note was transposed\n")
+		t.FailNow()
+	}
+	count, e = smf.Transpose(100, true)
+	if e == nil {
+		t.Logf("Didn't get an error transposing out of range\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error transposing out of range: %s\n", e)
+	if messages[0].(*NoteOnEvent).Note != 57 {
+		t.Logf("A failed transposition modified the file\n")
+		t.FailNow()
+	}
+	count, e = smf.Transpose(1, true)
+	if (e != nil) || (count != 5) ||
+		(messages[1].(*NoteOnEvent).Note != 37) {
+		t.Logf("Percussion notes weren't included when requested\n")
+		t.FailNow()
+	}
+}
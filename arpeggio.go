@@ -0,0 +1,159 @@
+package midi
+
+// This file contains code for replacing chords with arpeggios.
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Specifies the order in which SMFTrack.Arpeggiate plays the notes of a chord.
+type ArpPattern int
+
+const (
+	// Plays the notes from lowest to highest, then repeats.
+	ArpUp ArpPattern = iota
+	// Plays the notes from highest to lowest, then repeats.
+	ArpDown
+	// Plays the notes from lowest to highest and back down, without repeating
+	// the highest or lowest note, then repeats.
+	ArpUpDown
+)
+
+func (p ArpPattern) String() string {
+	switch p {
+	case ArpUp:
+		return "up"
+	case ArpDown:
+		return "down"
+	case ArpUpDown:
+		return "up-down"
+	}
+	return fmt.Sprintf("unknown arpeggio pattern %d", int(p))
+}
+
+// Returns the order in which to play the given notes, which must be sorted by
+// increasing pitch, for one cycle of the pattern. Returns nil if the pattern
+// is invalid.
+func (p ArpPattern) sequence(notes []notePair) []notePair {
+	switch p {
+	case ArpUp:
+		return notes
+	case ArpDown:
+		toReturn := make([]notePair, len(notes))
+		for i, n := range notes {
+			toReturn[len(notes)-1-i] = n
+		}
+		return toReturn
+	case ArpUpDown:
+		toReturn := append([]notePair{}, notes...)
+		for i := len(notes) - 2; i > 0; i-- {
+			toReturn = append(toReturn, notes[i])
+		}
+		return toReturn
+	}
+	return nil
+}
+
+// Replaces every chord in the track with an arpeggio. A chord is two or more
+// notes on the same channel that start at the same time, not counting notes
+// that are never ended or that have a duration of 0. Notes starting up to
+// window ticks after the first note of a chord, while that note is still
+// sounding, are also included in the chord, so that strummed or humanized
+// chords can be detected. The chord's notes are replaced by a sequence of
+// single notes, each stepTicks long, starting when the chord's first note
+// starts, following the given pattern, and lasting until the chord's longest
+// note ends. Each note in the arpeggio uses the velocity of the chord note it
+// replaces, and the last one is shortened if necessary so it ends with the
+// chord. Notes on the channels for which percussion is true aren't changed;
+// usually this will be the result of SMFFile.PercussionChannels. Does nothing
+// if stepTicks is 0 or the pattern is invalid.
+func (t *SMFTrack) Arpeggiate(stepTicks uint32, pattern ArpPattern,
+	window uint32, percussion [16]bool) {
+	if (stepTicks == 0) || (pattern < ArpUp) || (pattern > ArpUpDown) {
+		return
+	}
+	type chord struct {
+		tick  uint32
+		notes []notePair
+	}
+	ticks := t.AbsoluteTicks()
+	var chords []*chord
+	// The most recent chord started on each channel.
+	var current [16]*chord
+	for _, p := range t.pairNotes() {
+		if (p.offIndex < 0) || (ticks[p.offIndex] == ticks[p.onIndex]) {
+			continue
+		}
+		channel := t.Messages[p.onIndex].(*NoteOnEvent).Channel & 0xf
+		if percussion[channel] {
+			continue
+		}
+		c := current[channel]
+		if (c != nil) && ((ticks[p.onIndex] - c.tick) <= window) &&
+			(ticks[c.notes[0].offIndex] > ticks[p.onIndex]) {
+			c.notes = append(c.notes, p)
+			continue
+		}
+		c = &chord{
+			tick:  ticks[p.onIndex],
+			notes: []notePair{p},
+		}
+		current[channel] = c
+		chords = append(chords, c)
+	}
+	removed := make(map[int]bool)
+	var added []timedMessage
+	for _, c := range chords {
+		notes := c.notes
+		if len(notes) < 2 {
+			continue
+		}
+		sort.SliceStable(notes, func(a, b int) bool {
+			x := t.Messages[notes[a].onIndex].(*NoteOnEvent)
+			y := t.Messages[notes[b].onIndex].(*NoteOnEvent)
+			return x.Note < y.Note
+		})
+		end := uint64(0)
+		for _, p := range notes {
+			removed[p.onIndex] = true
+			removed[p.offIndex] = true
+			if uint64(ticks[p.offIndex]) > end {
+				end = uint64(ticks[p.offIndex])
+			}
+		}
+		sequence := pattern.sequence(notes)
+		step := 0
+		for tick := uint64(c.tick); tick < end; tick += uint64(stepTicks) {
+			p := sequence[step%len(sequence)]
+			step++
+			noteOn := t.Messages[p.onIndex].(*NoteOnEvent)
+			offTick := tick + uint64(stepTicks)
+			if offTick > end {
+				offTick = end
+			}
+			added = append(added, timedMessage{
+				tick: uint32(tick),
+				message: &NoteOnEvent{
+					Channel:  noteOn.Channel,
+					Note:     noteOn.Note,
+					Velocity: noteOn.Velocity,
+				},
+			}, timedMessage{
+				tick: uint32(offTick),
+				message: newNoteOffLike(t.Messages[p.offIndex],
+					noteOn.Channel, noteOn.Note),
+			})
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	var events []timedMessage
+	for i, v := range t.timedMessages() {
+		if !removed[i] {
+			events = append(events, v)
+		}
+	}
+	t.setTimedMessages(append(events, added...))
+}
@@ -0,0 +1,135 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestArpeggiate(t *testing.T) {
+	// A C major chord held for 4 beats, followed by a single note.
+	original := &SMFTrack{}
+	original.AddNote(0, 0, 64, 90, 384)
+	original.AddNote(0, 0, 60, 100, 384)
+	original.AddNote(0, 0, 67, 80, 384)
+	original.AddNote(384, 0, 72, 100, 96)
+	original.Append(0, EndOfTrackMetaEvent(0))
+	tests := []struct {
+		pattern  ArpPattern
+		expected []MIDINote
+	}{
+		{ArpUp, []MIDINote{60, 64, 67, 60, 72}},
+		{ArpDown, []MIDINote{67, 64, 60, 67, 72}},
+		{ArpUpDown, []MIDINote{60, 64, 67, 64, 72}},
+	}
+	for _, test := range tests {
+		track := original.Copy()
+		track.Arpeggiate(96, test.pattern, 0, [16]bool{})
+		ticks := track.AbsoluteTicks()
+		var notes []MIDINote
+		for i, m := range track.Messages {
+			if !isNoteOn(m) {
+				continue
+			}
+			v := m.(*NoteOnEvent)
+			if ticks[i] != uint32(len(notes)*96) {
+				t.Logf("Note %d of the %s pattern starts at tick %d\n",
+					len(notes), test.pattern, ticks[i])
+				t.FailNow()
+			}
+			notes = append(notes, v.Note)
+		}
+		if len(notes) != len(test.expected) {
+			t.Logf("Expected %s pattern %v\n", test.pattern, test.expected)
+			t.FailNow()
+		}
+		for i := range notes {
+			if notes[i] != test.expected[i] {
+				t.Logf("Expected %s pattern %v\n", test.pattern,
+					test.expected)
+				t.FailNow()
+			}
+		}
+		if len(track.OverlappingNotes()) != 0 {
+			t.Logf("The %s pattern contains overlapping notes\n",
+				test.pattern)
+			t.FailNow()
+		}
+		for _, p := range track.pairNotes() {
+			if (p.offIndex < 0) || ((ticks[p.offIndex] - ticks[p.onIndex]) !=
+				96) {
+				t.Logf("Got incorrect note length in the %s pattern\n",
+					test.pattern)
+				t.FailNow()
+			}
+		}
+	}
+	// The velocity of each arpeggiated note should match the original.
+	track := original.Copy()
+	track.Arpeggiate(96, ArpUp, 0, [16]bool{})
+	if track.Messages[0].(*NoteOnEvent).Velocity != 100 {
+		t.Logf("Didn't keep the original velocity: %s\n", track.Messages[0])
+		t.FailNow()
+	}
+	before := len(track.Messages)
+	track.Arpeggiate(0, ArpUp, 0, [16]bool{})
+	track.Arpeggiate(96, ArpPattern(10), 0, [16]bool{})
+	if len(track.Messages) != before {
+		t.Logf("Modified the track with invalid arguments\n")
+		t.FailNow()
+	}
+}
+
+func TestArpeggiateStrummed(t *testing.T) {
+	// A strummed chord, with each note starting 8 ticks after the previous
+	// one, followed by a note that starts while the chord is still held.
+	original := &SMFTrack{}
+	original.AddNote(0, 0, 60, 100, 384)
+	original.AddNote(8, 0, 64, 100, 376)
+	original.AddNote(8, 0, 67, 100, 368)
+	original.AddNote(176, 0, 72, 100, 96)
+	original.Append(0, EndOfTrackMetaEvent(0))
+	track := original.Copy()
+	track.Arpeggiate(96, ArpUp, 0, [16]bool{})
+	if len(track.Messages) != len(original.Messages) {
+		t.Logf("Arpeggiated a strummed chord without a window\n")
+		t.FailNow()
+	}
+	track.Arpeggiate(96, ArpUp, 16, [16]bool{})
+	ticks := track.AbsoluteTicks()
+	expected := []struct {
+		tick uint32
+		note MIDINote
+	}{
+		{0, 60},
+		{96, 64},
+		{192, 72},
+		{192, 67},
+		{288, 60},
+	}
+	var onIndices []int
+	for i, m := range track.Messages {
+		if isNoteOn(m) {
+			onIndices = append(onIndices, i)
+		}
+	}
+	if len(onIndices) != len(expected) {
+		t.Logf("Expected %d notes, got %d\n", len(expected), len(onIndices))
+		t.FailNow()
+	}
+	for i, x := range expected {
+		v := track.Messages[onIndices[i]].(*NoteOnEvent)
+		if (ticks[onIndices[i]] != x.tick) || (v.Note != x.note) {
+			t.Logf("Expected note %d to be %s at tick %d, got %s at tick "+
+				"%d\n", i, x.note, x.tick, v.Note, ticks[onIndices[i]])
+			t.FailNow()
+		}
+	}
+	// Nothing should change if the chord's channel is used for percussion.
+	var percussion [16]bool
+	percussion[0] = true
+	track = original.Copy()
+	track.Arpeggiate(96, ArpUp, 16, percussion)
+	if len(track.Messages) != len(original.Messages) {
+		t.Logf("Arpeggiated notes on a percussion channel\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,93 @@
+package midi
+
+// This file contains an io.Reader that produces the SMF bytes for a track's
+// events on demand.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Implements io.Reader, returning the encoded time delta and message bytes
+// for each event in a track, one event at a time.
+type trackByteReader struct {
+	track *SMFTrack
+	// The index of the next event to encode.
+	index         int
+	runningStatus byte
+	// Bytes from the most recently encoded event that haven't been read yet.
+	pending []byte
+	// If non-nil, this is returned by all subsequent calls to Read.
+	err error
+}
+
+// Encodes the next event in the track into r.pending. Sets r.err to io.EOF if
+// there are no more events, or to another error if encoding fails.
+func (r *trackByteReader) encodeNext() {
+	t := r.track
+	if r.index >= len(t.Messages) {
+		r.err = io.EOF
+		return
+	}
+	if r.index >= len(t.TimeDeltas) {
+		r.err = fmt.Errorf("Bad track: has %d messages, but %d times",
+			len(t.Messages), len(t.TimeDeltas))
+		return
+	}
+	var buffer bytes.Buffer
+	e := WriteVariableInt(&buffer, t.TimeDeltas[r.index])
+	if e != nil {
+		r.err = fmt.Errorf("Couldn't write time delta for event %d: %s",
+			r.index, e)
+		return
+	}
+	data, e := t.Messages[r.index].SMFData(&r.runningStatus)
+	if e != nil {
+		r.err = fmt.Errorf("Couldn't get bytes for event %d: %s", r.index, e)
+		return
+	}
+	buffer.Write(data)
+	r.pending = buffer.Bytes()
+	r.index++
+}
+
+func (r *trackByteReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.pending) == 0 {
+			if r.err != nil {
+				break
+			}
+			r.encodeNext()
+			continue
+		}
+		copied := copy(p[n:], r.pending)
+		r.pending = r.pending[copied:]
+		n += copied
+	}
+	if (n == 0) && (len(p) != 0) {
+		return 0, r.err
+	}
+	return n, nil
+}
+
+// Returns a reader producing the SMF bytes of the events in the track with the
+// given index: each event's time delta followed by its message, using running
+// status. This is the same data that WriteToFile writes in the track's chunk,
+// without the chunk header. Events are encoded as they're read, so the full
+// track's data is never held in memory. If the track index is invalid or an
+// event can't be encoded, the reader returns an error once all bytes before
+// the invalid event have been read.
+func (f *SMFFile) EventByteStream(trackIndex int) io.Reader {
+	if (trackIndex < 0) || (trackIndex >= len(f.Tracks)) {
+		return &trackByteReader{
+			track: &SMFTrack{},
+			err: fmt.Errorf("Invalid track index %d (the file has %d "+
+				"tracks)", trackIndex, len(f.Tracks)),
+		}
+	}
+	return &trackByteReader{
+		track: f.Tracks[trackIndex],
+	}
+}
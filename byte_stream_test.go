@@ -0,0 +1,49 @@
+package midi
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEventByteStream(t *testing.T) {
+	f, e := os.Open("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed opening test file: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	smf, e := ParseSMFFile(f)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	for i, track := range smf.Tracks {
+		var expected bytes.Buffer
+		e = track.WriteToFile(&expected)
+		if e != nil {
+			t.Logf("Failed writing track %d: %s\n", i, e)
+			t.FailNow()
+		}
+		var streamed bytes.Buffer
+		// Use a small buffer to make sure events are split across reads.
+		_, e = io.CopyBuffer(&streamed, struct{ io.Reader }{
+			smf.EventByteStream(i)}, make([]byte, 3))
+		if e != nil {
+			t.Logf("Failed reading track %d's byte stream: %s\n", i, e)
+			t.FailNow()
+		}
+		// Skip the 8-byte chunk header written by WriteToFile.
+		if !bytes.Equal(streamed.Bytes(), expected.Bytes()[8:]) {
+			t.Logf("Track %d's byte stream doesn't match its chunk\n", i)
+			t.FailNow()
+		}
+	}
+	_, e = io.ReadAll(smf.EventByteStream(len(smf.Tracks)))
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid track index\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an invalid track index: %s\n", e)
+}
@@ -0,0 +1,281 @@
+package midi
+
+// This file contains code for converting SMF files to a canonical form, so that
+// files containing the same events produce identical output.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Returns a number used to order simultaneous events in canonical form.
+// Meta-events come first, followed by sysex messages, other channel messages,
+// note-offs, and finally note-ons.
+func canonicalRank(m MIDIMessage) int {
+	if isNoteOn(m) {
+		return 4
+	}
+	_, _, isOff := noteOffInfo(m)
+	if isOff {
+		return 3
+	}
+	switch m.(type) {
+	case ChannelMessage:
+		return 2
+	case *SystemExclusiveMessage:
+		return 1
+	}
+	return 0
+}
+
+// Returns the bytes for m without running status, to use when comparing
+// messages. Falls back to the message's string if it can't be encoded.
+func canonicalBytes(m MIDIMessage) []byte {
+	runningStatus := byte(0)
+	data, e := m.SMFData(&runningStatus)
+	if e != nil {
+		return []byte(m.String())
+	}
+	return data
+}
+
+// If m is a note-on, note-off, or polyphonic aftertouch event, this returns
+// the channel and key it applies to, and true. Returns false otherwise.
+func noteEventKey(m MIDIMessage) (channelNote, bool) {
+	switch v := m.(type) {
+	case *NoteOnEvent:
+		return channelNote{v.Channel & 0xf, v.Note}, true
+	case *NoteOffEvent:
+		return channelNote{v.Channel & 0xf, v.Note}, true
+	case *AftertouchEvent:
+		return channelNote{v.Channel & 0xf, v.Note}, true
+	}
+	return channelNote{}, false
+}
+
+// Returns true if the order of the simultaneous events a and b doesn't matter:
+// they're channel messages on different channels, or note events for different
+// keys on the same channel. The order of any other pair of events, including
+// all meta-events and sysex messages, is considered significant.
+func eventsCommute(a, b MIDIMessage) bool {
+	channelA, ok := a.(ChannelMessage)
+	if !ok {
+		return false
+	}
+	channelB, ok := b.(ChannelMessage)
+	if !ok {
+		return false
+	}
+	if (channelA.GetChannel() & 0xf) != (channelB.GetChannel() & 0xf) {
+		return true
+	}
+	keyA, ok := noteEventKey(a)
+	if !ok {
+		return false
+	}
+	keyB, ok := noteEventKey(b)
+	if !ok {
+		return false
+	}
+	return keyA != keyB
+}
+
+// Sorts the given simultaneous events into a canonical order, without
+// changing the relative order of any two events for which eventsCommute
+// returns false. Of the events that can come next, the one with the lowest
+// canonicalRank, and then the lowest bytes, is always chosen first.
+func canonicalizeGroup(group []timedMessage) {
+	if len(group) < 2 {
+		return
+	}
+	encoded := make([][]byte, len(group))
+	ranks := make([]int, len(group))
+	for i, v := range group {
+		encoded[i] = canonicalBytes(v.message)
+		ranks[i] = canonicalRank(v.message)
+	}
+	// blockers[i] is the number of earlier events that must still be placed
+	// before event i can be.
+	blockers := make([]int, len(group))
+	blocks := make([][]int, len(group))
+	for i := range group {
+		for j := i + 1; j < len(group); j++ {
+			if !eventsCommute(group[i].message, group[j].message) {
+				blocks[i] = append(blocks[i], j)
+				blockers[j]++
+			}
+		}
+	}
+	placed := make([]bool, len(group))
+	sorted := make([]timedMessage, 0, len(group))
+	for len(sorted) < len(group) {
+		next := -1
+		for i := range group {
+			if placed[i] || (blockers[i] != 0) {
+				continue
+			}
+			if next < 0 {
+				next = i
+				continue
+			}
+			if ranks[i] != ranks[next] {
+				if ranks[i] < ranks[next] {
+					next = i
+				}
+				continue
+			}
+			if bytes.Compare(encoded[i], encoded[next]) < 0 {
+				next = i
+			}
+		}
+		placed[next] = true
+		for _, j := range blocks[next] {
+			blockers[j]--
+		}
+		sorted = append(sorted, group[next])
+	}
+	copy(group, sorted)
+}
+
+// Sorts the track's events into a canonical order: by absolute time, and then
+// by canonicalRank and the bytes of each message, for simultaneous events
+// whose order doesn't matter. Adds an end-of-track event if the track doesn't
+// have one.
+func (t *SMFTrack) canonicalize() {
+	events := t.timedMessages()
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].tick < events[b].tick
+	})
+	groupStart := 0
+	for groupStart < len(events) {
+		groupEnd := groupStart + 1
+		for (groupEnd < len(events)) &&
+			(events[groupEnd].tick == events[groupStart].tick) {
+			groupEnd++
+		}
+		canonicalizeGroup(events[groupStart:groupEnd])
+		groupStart = groupEnd
+	}
+	endTick := uint32(0)
+	if len(events) > 0 {
+		endTick = events[len(events)-1].tick
+	}
+	// setTimedMessages will merge this with any existing end-of-track event.
+	events = append(events, timedMessage{
+		tick:    endTick,
+		message: EndOfTrackMetaEvent(0),
+	})
+	t.setTimedMessages(events)
+}
+
+// Converts the file to a canonical form, so that files containing the same
+// events at the same times produce identical output from WriteToFile. Events
+// in each track are sorted by time. Simultaneous events are sorted in a
+// deterministic order (meta-events, sysex messages, other channel messages,
+// note-offs, and note-ons, with ties broken by the events' bytes), but only
+// where their order doesn't affect playback: channel messages on different
+// channels, or note events for different keys. Other simultaneous events,
+// such as two controller changes on the same channel, or any sysex or
+// meta-events, keep their original relative order. Every track is given an
+// end-of-track event, if it doesn't have one. The order of the tracks isn't
+// changed.
+func (f *SMFFile) Canonicalize() {
+	for _, t := range f.Tracks {
+		t.canonicalize()
+	}
+}
+
+// Writes data identifying the track's events to w, for comparing or hashing
+// tracks: the number of events, followed by each event's time delta, the
+// length of its canonicalBytes, and the bytes themselves. Unlike WriteToFile,
+// this includes events that can't be encoded, using their descriptions. The
+// track should already be in canonical form. Write errors are ignored, since
+// this is only used with bytes.Buffers and hashes, which never return them.
+func (t *SMFTrack) writeComparisonData(w io.Writer) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(t.Messages)))
+	w.Write(header[:4])
+	for i, m := range t.Messages {
+		data := canonicalBytes(m)
+		binary.BigEndian.PutUint32(header[:4], t.TimeDeltas[i])
+		binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+		w.Write(header[:])
+		w.Write(data)
+	}
+}
+
+// Removes every track that contains the same events at the same times as an
+// earlier track in the file. Tracks are compared using the canonical form
+// described in Canonicalize, so simultaneous events are only ignored if their
+// order doesn't matter; for example, two tracks sending the same controller
+// changes on one channel in a different order aren't duplicates. Events that
+// can't be written are compared using their descriptions, so tracks
+// containing them are only removed if they match exactly. The tracks that are
+// kept aren't modified. Returns the number of tracks that were removed.
+func (f *SMFFile) RemoveDuplicateTracks() int {
+	seen := make(map[string]bool)
+	kept := make([]*SMFTrack, 0, len(f.Tracks))
+	for _, t := range f.Tracks {
+		canonical := t.Copy()
+		canonical.canonicalize()
+		var buffer bytes.Buffer
+		canonical.writeComparisonData(&buffer)
+		key := buffer.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, t)
+	}
+	toReturn := len(f.Tracks) - len(kept)
+	f.Tracks = kept
+	return toReturn
+}
+
+// Returns a SHA-256 hash of the file's musical content, which is the same for
+// any two files containing the same events at the same times, using the same
+// time division, regardless of how the events are encoded. The hash is
+// computed using the canonical form described in Canonicalize, so it ignores
+// running status and the order of simultaneous events where the order doesn't
+// affect playback. The order of the tracks is included in the hash. Every
+// note-off, including a note-on with a velocity of 0, is treated as a note-off
+// event with a velocity of 0, so release velocities are ignored. Extra header
+// bytes are also ignored. The file isn't modified. Events that can't be
+// written are hashed using their descriptions, so files containing them only
+// have the same hash if those events are the same.
+func (f *SMFFile) ContentHash() [sha256.Size]byte {
+	canonical := &SMFFile{
+		Division: f.Division,
+		Tracks:   make([]*SMFTrack, len(f.Tracks)),
+	}
+	for i, t := range f.Tracks {
+		track := t.Copy()
+		for j, m := range track.Messages {
+			channel, note, isOff := noteOffInfo(m)
+			if isOff {
+				track.Messages[j] = &NoteOffEvent{
+					Channel: channel,
+					Note:    note,
+				}
+			}
+		}
+		canonical.Tracks[i] = track
+	}
+	canonical.Canonicalize()
+	hash := sha256.New()
+	var division [2]byte
+	binary.BigEndian.PutUint16(division[:], uint16(canonical.Division))
+	hash.Write(division[:])
+	for _, t := range canonical.Tracks {
+		// Each track starts with a marker and its event count, so the
+		// boundaries between tracks affect the hash.
+		hash.Write([]byte("MTrk"))
+		t.writeComparisonData(hash)
+	}
+	var toReturn [sha256.Size]byte
+	copy(toReturn[:], hash.Sum(nil))
+	return toReturn
+}
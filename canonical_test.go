@@ -0,0 +1,237 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestRemoveDuplicateTracks(t *testing.T) {
+	original := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			&NoteOffEvent{Channel: 0, Note: 64},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 10, 0, 0},
+	}
+	// The same notes, but with the simultaneous note-ons in a different
+	// order.
+	reordered := original.Copy()
+	reordered.Messages[0], reordered.Messages[1] = reordered.Messages[1],
+		reordered.Messages[0]
+	different := original.Copy()
+	different.TimeDeltas[2] = 20
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{original, reordered, different},
+	}
+	removed := smf.RemoveDuplicateTracks()
+	if removed != 1 {
+		t.Logf("Expected to remove 1 track, removed %d\n", removed)
+		t.FailNow()
+	}
+	if (smf.Tracks[0] != original) || (smf.Tracks[1] != different) {
+		t.Logf("The wrong tracks were kept\n")
+		t.FailNow()
+	}
+
+	// Tracks that only differ in the order of simultaneous controller changes
+	// on the same channel aren't duplicates, since the last value wins.
+	controls := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 7, Value: 10},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 7, Value: 100},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0},
+	}
+	swapped := controls.Copy()
+	swapped.Messages[0], swapped.Messages[1] = swapped.Messages[1],
+		swapped.Messages[0]
+	smf.Tracks = []*SMFTrack{controls, swapped}
+	removed = smf.RemoveDuplicateTracks()
+	if (removed != 0) || (len(smf.Tracks) != 2) {
+		t.Logf("Removed %d track(s) differing in the order of same-channel "+
+			"controller changes\n", removed)
+		t.FailNow()
+	}
+
+	// Tracks with events that can't be written must still be compared using
+	// their contents.
+	invalidA := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 16, Note: 60, Velocity: 100},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0},
+	}
+	invalidB := invalidA.Copy()
+	invalidB.Messages[0].(*NoteOnEvent).Note = 62
+	smf.Tracks = []*SMFTrack{invalidA, invalidB, invalidA.Copy()}
+	removed = smf.RemoveDuplicateTracks()
+	if (removed != 1) || (smf.Tracks[0] != invalidA) ||
+		(smf.Tracks[1] != invalidB) {
+		t.Logf("Removed %d track(s) containing unwritable events, expected "+
+			"only the copy to be removed\n", removed)
+		t.FailNow()
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	original := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+					&NoteOffEvent{Channel: 0, Note: 60, Velocity: 64},
+					&NoteOffEvent{Channel: 0, Note: 64},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 10, 0, 0},
+			},
+		},
+	}
+	// The same notes, started in the opposite order, and ended using a
+	// note-on with a velocity of 0 rather than a note-off with a release
+	// velocity.
+	reencoded := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					&NoteOffEvent{Channel: 0, Note: 64},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 10, 0, 0},
+			},
+		},
+	}
+	if original.ContentHash() != reencoded.ContentHash() {
+		t.Logf("Files with the same content have different hashes\n")
+		t.FailNow()
+	}
+	if _, ok := reencoded.Tracks[0].Messages[2].(*NoteOnEvent); !ok {
+		t.Logf("Computing the hash modified the file\n")
+		t.FailNow()
+	}
+	reencoded.Tracks[0].TimeDeltas[2] = 20
+	if original.ContentHash() == reencoded.ContentHash() {
+		t.Logf("Files with different content have the same hash\n")
+		t.FailNow()
+	}
+
+	// Tracks with events that can't be written must still affect the hash.
+	invalid := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 16, Note: 60, Velocity: 100},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0},
+	}
+	withInvalid := &SMFFile{
+		Division: original.Division,
+		Tracks:   []*SMFTrack{original.Tracks[0], invalid},
+	}
+	if withInvalid.ContentHash() == original.ContentHash() {
+		t.Logf("Adding a track with an unwritable event didn't change the " +
+			"hash\n")
+		t.FailNow()
+	}
+	otherInvalid := &SMFFile{
+		Division: original.Division,
+		Tracks:   []*SMFTrack{original.Tracks[0], invalid.Copy()},
+	}
+	otherInvalid.Tracks[1].Messages[0].(*NoteOnEvent).Note = 62
+	if withInvalid.ContentHash() == otherInvalid.ContentHash() {
+		t.Logf("Files differing in unwritable events have the same hash\n")
+		t.FailNow()
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	first := &SMFTrack{
+		Messages: []MIDIMessage{
+			SetTempoMetaEvent(500000),
+			&TextMetaEvent{TextEventType: 1, Data: []byte("a")},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 20},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 10},
+			&ControlChangeEvent{Channel: 1, ControllerNumber: 7, Value: 90},
+			&NoteOffEvent{Channel: 0, Note: 64},
+			&NoteOffEvent{Channel: 0, Note: 60},
+		},
+		TimeDeltas: []uint32{0, 0, 0, 0, 0, 0, 0, 10, 0},
+	}
+	second := first.Copy()
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{first, second},
+	}
+	smf.Canonicalize()
+	// The notes on different keys, and the CC on a different channel, are
+	// reordered, but the meta-events and the same-channel CCs aren't.
+	expected := []MIDIMessage{
+		SetTempoMetaEvent(500000),
+		&TextMetaEvent{TextEventType: 1, Data: []byte("a")},
+		&ControlChangeEvent{Channel: 1, ControllerNumber: 7, Value: 90},
+		&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+		&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+		&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 20},
+		&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 10},
+		&NoteOffEvent{Channel: 0, Note: 60},
+		&NoteOffEvent{Channel: 0, Note: 64},
+		EndOfTrackMetaEvent(0),
+	}
+	if len(first.Messages) != len(expected) {
+		t.Logf("Expected %d events after canonicalizing, got %d\n",
+			len(expected), len(first.Messages))
+		t.FailNow()
+	}
+	for i, m := range expected {
+		if first.Messages[i].String() != m.String() {
+			t.Logf("Expected event %d to be %s, got %s\n", i, m,
+				first.Messages[i])
+			t.FailNow()
+		}
+	}
+	if first.TimeDeltas[7] != 10 {
+		t.Logf("Canonicalizing changed the note-offs' time\n")
+		t.FailNow()
+	}
+	if (smf.Tracks[0] != first) || (smf.Tracks[1] != second) {
+		t.Logf("Canonicalizing reordered the tracks\n")
+		t.FailNow()
+	}
+
+	// Putting the simultaneous notes in a different order in the input
+	// shouldn't change the canonical form.
+	other := &SMFTrack{
+		Messages: []MIDIMessage{
+			SetTempoMetaEvent(500000),
+			&TextMetaEvent{TextEventType: 1, Data: []byte("a")},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 20},
+			&ControlChangeEvent{Channel: 1, ControllerNumber: 7, Value: 90},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 10},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			&NoteOffEvent{Channel: 0, Note: 64},
+		},
+		TimeDeltas: []uint32{0, 0, 0, 0, 0, 0, 0, 10, 0},
+	}
+	other.canonicalize()
+	for i, m := range first.Messages {
+		if other.Messages[i].String() != m.String() {
+			t.Logf("Got different canonical forms for event %d: %s vs %s\n",
+				i, m, other.Messages[i])
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package midi
+
+// This file contains code for detecting chords formed by simultaneous notes.
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Identifies the type of a chord, such as major or minor.
+type ChordQuality int
+
+const (
+	ChordMajor ChordQuality = iota
+	ChordMinor
+	ChordDiminished
+	ChordAugmented
+	ChordSuspended2
+	ChordSuspended4
+	ChordDominant7
+	ChordMajor7
+	ChordMinor7
+	ChordHalfDiminished7
+	ChordDiminished7
+)
+
+func (q ChordQuality) String() string {
+	switch q {
+	case ChordMajor:
+		return "major"
+	case ChordMinor:
+		return "minor"
+	case ChordDiminished:
+		return "diminished"
+	case ChordAugmented:
+		return "augmented"
+	case ChordSuspended2:
+		return "sus2"
+	case ChordSuspended4:
+		return "sus4"
+	case ChordDominant7:
+		return "dominant 7th"
+	case ChordMajor7:
+		return "major 7th"
+	case ChordMinor7:
+		return "minor 7th"
+	case ChordHalfDiminished7:
+		return "half-diminished 7th"
+	case ChordDiminished7:
+		return "diminished 7th"
+	}
+	return fmt.Sprintf("unknown chord quality %d", int(q))
+}
+
+// The names of the pitch classes, starting from C.
+var pitchClassNames = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G",
+	"G#", "A", "A#", "B"}
+
+// Holds a chord detected by DetectChords.
+type Chord struct {
+	// The absolute time at which the chord's window starts, in ticks.
+	Tick uint32
+	// The length of the chord's window, in ticks.
+	Duration uint32
+	// The pitch class of the chord's root, where C = 0, C# = 1, etc.
+	Root    uint8
+	Quality ChordQuality
+	// The distinct pitches of all notes sounding during the chord's window,
+	// sorted from lowest to highest. This may include notes that aren't part
+	// of the chord.
+	Pitches []MIDINote
+}
+
+func (c *Chord) String() string {
+	return fmt.Sprintf("%s %s at tick %d", pitchClassNames[c.Root%12],
+		c.Quality, c.Tick)
+}
+
+// Holds the pitch classes making up a type of chord, relative to its root.
+type chordTemplate struct {
+	quality ChordQuality
+	// Bit i is set if the chord contains the pitch class i semitones above
+	// the root.
+	intervals uint16
+}
+
+// Returns a bitmask with bits set for each of the given intervals.
+func intervalMask(intervals ...uint8) uint16 {
+	toReturn := uint16(0)
+	for _, v := range intervals {
+		toReturn |= 1 << v
+	}
+	return toReturn
+}
+
+// The recognized chord types. If a set of pitches matches more than one
+// template, the one with more notes is preferred.
+var chordTemplates = []chordTemplate{
+	{ChordMajor, intervalMask(0, 4, 7)},
+	{ChordMinor, intervalMask(0, 3, 7)},
+	{ChordDiminished, intervalMask(0, 3, 6)},
+	{ChordAugmented, intervalMask(0, 4, 8)},
+	{ChordSuspended2, intervalMask(0, 2, 7)},
+	{ChordSuspended4, intervalMask(0, 5, 7)},
+	{ChordDominant7, intervalMask(0, 4, 7, 10)},
+	{ChordMajor7, intervalMask(0, 4, 7, 11)},
+	{ChordMinor7, intervalMask(0, 3, 7, 10)},
+	{ChordHalfDiminished7, intervalMask(0, 3, 6, 10)},
+	{ChordDiminished7, intervalMask(0, 3, 6, 9)},
+}
+
+// Returns the number of set bits in the mask.
+func countBits(mask uint16) int {
+	toReturn := 0
+	for mask != 0 {
+		toReturn += int(mask & 1)
+		mask >>= 1
+	}
+	return toReturn
+}
+
+// Identifies the chord formed by the given pitches, which must be sorted from
+// lowest to highest. Returns the root pitch class and chord quality, and true,
+// or false if the pitches don't contain a recognized chord. Pitches that
+// aren't part of the identified chord are ignored. If several chords match,
+// the one containing the most notes is chosen, followed by the one whose root
+// is the lowest pitch.
+func identifyChord(pitches []MIDINote) (uint8, ChordQuality, bool) {
+	classes := uint16(0)
+	for _, p := range pitches {
+		classes |= 1 << (p % 12)
+	}
+	if countBits(classes) < 3 {
+		return 0, 0, false
+	}
+	bass := uint8(pitches[0] % 12)
+	found := false
+	bestRoot := uint8(0)
+	var bestTemplate chordTemplate
+	bestSize := 0
+	for _, template := range chordTemplates {
+		size := countBits(template.intervals)
+		for root := uint8(0); root < 12; root++ {
+			// Rotate the template so that it starts at the root.
+			mask := (template.intervals << root) |
+				(template.intervals >> (12 - root))
+			mask &= 0xfff
+			if (mask & classes) != mask {
+				continue
+			}
+			better := !found || (size > bestSize) ||
+				((size == bestSize) && (root == bass) && (bestRoot != bass))
+			if !better {
+				continue
+			}
+			found = true
+			bestRoot = root
+			bestTemplate = template
+			bestSize = size
+		}
+	}
+	return bestRoot, bestTemplate.quality, found
+}
+
+// Divides the file into consecutive windows of windowTicks ticks, starting at
+// tick 0, and attempts to identify a chord formed by the notes sounding during
+// each window. Notes on percussion channels, as reported by
+// PercussionChannels, are ignored. Returns the detected chords in time order;
+// windows in which no recognized chord is sounding are omitted. Returns nil if
+// windowTicks is 0.
+func (f *SMFFile) DetectChords(windowTicks uint32) []Chord {
+	if windowTicks == 0 {
+		return nil
+	}
+	var notes []Note
+	percussion := f.PercussionChannels()
+	for _, n := range f.Notes() {
+		if !percussion[n.Channel&0xf] {
+			notes = append(notes, n)
+		}
+	}
+	var toReturn []Chord
+	// Notes are sorted by start time, so each window only needs to check the
+	// notes starting from the first one that hadn't ended before it. (The
+	// window boundaries are 64-bit to avoid overflow at the end of the file.)
+	firstNote := 0
+	step := uint64(windowTicks)
+	for start := uint64(0); firstNote < len(notes); start += step {
+		end := start + step
+		var pitches []MIDINote
+		seen := make(map[MIDINote]bool)
+		for i := firstNote; i < len(notes); i++ {
+			n := &(notes[i])
+			noteStart := uint64(n.Tick)
+			noteEnd := noteStart + uint64(n.Duration)
+			if noteStart >= end {
+				break
+			}
+			if (noteStart < start) && (noteEnd <= start) {
+				continue
+			}
+			if !seen[n.Pitch] {
+				seen[n.Pitch] = true
+				pitches = append(pitches, n.Pitch)
+			}
+		}
+		for (firstNote < len(notes)) && ((uint64(notes[firstNote].Tick) +
+			uint64(notes[firstNote].Duration)) < end) {
+			firstNote++
+		}
+		sort.Slice(pitches, func(a, b int) bool {
+			return pitches[a] < pitches[b]
+		})
+		root, quality, ok := identifyChord(pitches)
+		if !ok {
+			continue
+		}
+		toReturn = append(toReturn, Chord{
+			Tick:     uint32(start),
+			Duration: windowTicks,
+			Root:     root,
+			Quality:  quality,
+			Pitches:  pitches,
+		})
+	}
+	return toReturn
+}
@@ -0,0 +1,89 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestDetectChords(t *testing.T) {
+	// A C, Am, F, G progression, with one chord per 384-tick bar.
+	chords := [][]MIDINote{
+		{60, 64, 67},
+		{57, 60, 64},
+		{53, 57, 60},
+		{55, 59, 62},
+	}
+	track := &SMFTrack{}
+	for _, chord := range chords {
+		for _, n := range chord {
+			track.Messages = append(track.Messages, &NoteOnEvent{
+				Channel:  0,
+				Note:     n,
+				Velocity: 100,
+			})
+			track.TimeDeltas = append(track.TimeDeltas, 0)
+		}
+		for i, n := range chord {
+			track.Messages = append(track.Messages, &NoteOffEvent{
+				Channel: 0,
+				Note:    n,
+			})
+			delta := uint32(0)
+			if i == 0 {
+				delta = 384
+			}
+			track.TimeDeltas = append(track.TimeDeltas, delta)
+		}
+	}
+	// Add a percussion note, which should be ignored.
+	track.Messages = append(track.Messages, &NoteOnEvent{
+		Channel:  9,
+		Note:     38,
+		Velocity: 100,
+	}, &NoteOffEvent{
+		Channel: 9,
+		Note:    38,
+	}, EndOfTrackMetaEvent(0))
+	track.TimeDeltas = append(track.TimeDeltas, 0, 10, 0)
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	detected := smf.DetectChords(384)
+	expected := []Chord{
+		{Tick: 0, Root: 0, Quality: ChordMajor},
+		{Tick: 384, Root: 9, Quality: ChordMinor},
+		{Tick: 768, Root: 5, Quality: ChordMajor},
+		{Tick: 1152, Root: 7, Quality: ChordMajor},
+	}
+	if len(detected) != len(expected) {
+		t.Logf("Expected %d chords, got %d\n", len(expected), len(detected))
+		t.FailNow()
+	}
+	for i, c := range expected {
+		d := detected[i]
+		if (d.Tick != c.Tick) || (d.Root != c.Root) ||
+			(d.Quality != c.Quality) {
+			t.Logf("Expected chord %d to be %s, got %s\n", i, &c, &d)
+			t.FailNow()
+		}
+	}
+}
+
+func TestIdentifyChord(t *testing.T) {
+	// Am7 also contains a C major triad, but the larger chord is preferred.
+	root, quality, ok := identifyChord([]MIDINote{57, 60, 64, 67})
+	if !ok || (root != 9) || (quality != ChordMinor7) {
+		t.Logf("Failed identifying Am7: got %d %s\n", root, quality)
+		t.FailNow()
+	}
+	root, quality, ok = identifyChord([]MIDINote{55, 59, 62, 65})
+	if !ok || (root != 7) || (quality != ChordDominant7) {
+		t.Logf("Failed identifying G7: got %d %s\n", root, quality)
+		t.FailNow()
+	}
+	_, _, ok = identifyChord([]MIDINote{60, 67, 72})
+	if ok {
+		t.Logf("Identified a chord with only two pitch classes\n")
+		t.FailNow()
+	}
+}
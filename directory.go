@@ -0,0 +1,135 @@
+package midi
+
+// This file contains helpers for processing directories of SMF files.
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Holds an error that occurred while processing a single file.
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Filename, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Returned by ProcessDirectory if one or more files couldn't be processed.
+// Contains one entry per failed file, in the order they were processed.
+type DirectoryErrors []*FileError
+
+func (e DirectoryErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Error()
+	}
+	return fmt.Sprintf("Failed processing %d file(s): %s", len(e),
+		strings.Join(messages, "; "))
+}
+
+// Returns true if the name has an extension used by SMF files: .mid or .midi,
+// ignoring case.
+func isSMFFilename(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return (extension == ".mid") || (extension == ".midi")
+}
+
+// Opens and parses the named SMF file.
+func parseNamedSMFFile(name string) (*SMFFile, error) {
+	f, e := os.Open(name)
+	if e != nil {
+		return nil, fmt.Errorf("Failed opening file: %w", e)
+	}
+	defer f.Close()
+	toReturn, e := ParseSMFFile(f)
+	if e != nil {
+		return nil, fmt.Errorf("Failed parsing file: %w", e)
+	}
+	return toReturn, nil
+}
+
+// Parses every SMF file (with a .mid or .midi extension) in the given
+// directory, and calls fn for each one, in lexical order of their paths. If
+// recursive is true, files in subdirectories are included as well. Files that
+// fail to parse, or for which fn returns an error, don't stop processing of
+// the remaining files. Instead, if any such errors occur, they are returned
+// in a DirectoryErrors value after all files have been processed. Any other
+// error, such as a failure to read the directory, is returned immediately.
+func ProcessDirectory(dir string, recursive bool,
+	fn func(name string, f *SMFFile) error) error {
+	var names []string
+	e := filepath.WalkDir(dir, func(path string, d fs.DirEntry,
+		e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() {
+			if !recursive && (path != dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isSMFFilename(path) {
+			names = append(names, path)
+		}
+		return nil
+	})
+	if e != nil {
+		return fmt.Errorf("Failed reading directory %s: %w", dir, e)
+	}
+	var failures DirectoryErrors
+	for _, name := range names {
+		smf, e := parseNamedSMFFile(name)
+		if e == nil {
+			e = fn(name, smf)
+		}
+		if e != nil {
+			failures = append(failures, &FileError{
+				Filename: name,
+				Err:      e,
+			})
+		}
+	}
+	if len(failures) != 0 {
+		return failures
+	}
+	return nil
+}
+
+// Groups the given files by their ContentHash, and returns the names of the
+// files in each group containing more than one file. In other words, each
+// returned group lists files with identical musical content, even if they're
+// encoded differently. The names in each group are sorted, and the groups are
+// sorted by their first name. Returns nil if there are no duplicates. This is
+// intended to be used with files loaded using ProcessDirectory.
+func FindDuplicates(files map[string]*SMFFile) [][]string {
+	groups := make(map[[sha256.Size]byte][]string)
+	for name, f := range files {
+		hash := f.ContentHash()
+		groups[hash] = append(groups[hash], name)
+	}
+	var toReturn [][]string
+	for _, names := range groups {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		toReturn = append(toReturn, names)
+	}
+	sort.Slice(toReturn, func(a, b int) bool {
+		return toReturn[a][0] < toReturn[b][0]
+	})
+	return toReturn
+}
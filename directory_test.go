@@ -0,0 +1,132 @@
+package midi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	// Each file contains a single note; files with the same note are
+	// duplicates.
+	notes := map[string]MIDINote{
+		"d.mid": 60,
+		"b.mid": 62,
+		"a.mid": 60,
+		"c.mid": 64,
+		"e.mid": 62,
+	}
+	files := make(map[string]*SMFFile)
+	for name, note := range notes {
+		files[name] = &SMFFile{
+			Division: TimeDivision(96),
+			Tracks: []*SMFTrack{
+				{
+					Messages: []MIDIMessage{
+						&NoteOnEvent{Channel: 0, Note: note, Velocity: 100},
+						&NoteOffEvent{Channel: 0, Note: note},
+						EndOfTrackMetaEvent(0),
+					},
+					TimeDeltas: []uint32{0, 96, 0},
+				},
+			},
+		}
+	}
+	groups := FindDuplicates(files)
+	if (len(groups) != 2) || (len(groups[0]) != 2) || (len(groups[1]) != 2) {
+		t.Logf("Expected two groups of two files\n")
+		t.FailNow()
+	}
+	if (groups[0][0] != "a.mid") || (groups[0][1] != "d.mid") ||
+		(groups[1][0] != "b.mid") || (groups[1][1] != "e.mid") {
+		t.Logf("Got incorrect groups\n")
+		t.FailNow()
+	}
+	delete(files, "d.mid")
+	delete(files, "e.mid")
+	if FindDuplicates(files) != nil {
+		t.Logf("Expected nil when there are no duplicates\n")
+		t.FailNow()
+	}
+}
+
+func TestProcessDirectory(t *testing.T) {
+	data, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed reading test file: %s\n", e)
+		t.FailNow()
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"b.mid":         data,
+		"a.mid":         []byte("not a MIDI file"),
+		"notes.txt":     []byte("ignored"),
+		"sub/c.MIDI":    data,
+		"sub/deep/d.md": data,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		e = os.MkdirAll(filepath.Dir(path), 0755)
+		if e == nil {
+			e = os.WriteFile(path, content, 0644)
+		}
+		if e != nil {
+			t.Logf("Failed creating test file %s: %s\n", name, e)
+			t.FailNow()
+		}
+	}
+	var processed []string
+	fn := func(name string, f *SMFFile) error {
+		processed = append(processed, filepath.ToSlash(name[len(dir)+1:]))
+		if len(f.Tracks) == 0 {
+			return fmt.Errorf("No tracks")
+		}
+		return nil
+	}
+	e = ProcessDirectory(dir, false, fn)
+	var failures DirectoryErrors
+	if !errors.As(e, &failures) {
+		t.Logf("Expected a DirectoryErrors value, got %v\n", e)
+		t.FailNow()
+	}
+	if (len(failures) != 1) ||
+		(filepath.Base(failures[0].Filename) != "a.mid") {
+		t.Logf("Expected only a.mid to fail, got %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	if (len(processed) != 1) || (processed[0] != "b.mid") {
+		t.Logf("Expected only b.mid to be processed, got %v\n", processed)
+		t.FailNow()
+	}
+
+	processed = nil
+	e = ProcessDirectory(dir, true, fn)
+	if !errors.As(e, &failures) || (len(failures) != 1) {
+		t.Logf("Expected one failure when recursing, got %v\n", e)
+		t.FailNow()
+	}
+	if (len(processed) != 2) || (processed[0] != "b.mid") ||
+		(processed[1] != "sub/c.MIDI") {
+		t.Logf("Got incorrect recursively processed files: %v\n", processed)
+		t.FailNow()
+	}
+
+	// Errors returned by fn should be reported for the file, too.
+	os.Remove(filepath.Join(dir, "a.mid"))
+	e = ProcessDirectory(dir, true, func(name string, f *SMFFile) error {
+		return fmt.Errorf("Test error")
+	})
+	if !errors.As(e, &failures) || (len(failures) != 2) {
+		t.Logf("Expected two failures from fn, got %v\n", e)
+		t.FailNow()
+	}
+
+	e = ProcessDirectory(filepath.Join(dir, "missing"), false, fn)
+	if (e == nil) || errors.As(e, &failures) {
+		t.Logf("Expected a plain error for a missing directory, got %v\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,44 @@
+package midi
+
+// This file contains functions for exporting the events in SMF files to other
+// text formats.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Escapes tabs and line breaks in an event's string, so it fits in one field
+// of a tab-separated line.
+var tsvEscaper = strings.NewReplacer("\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// Writes every event in the file to w as tab-separated values, sorted by time
+// as in TimedEvents. The first line is a header naming the columns: track,
+// absolute_tick, seconds, and event_string. The seconds column is computed
+// using the file's TempoMap, and the event_string column contains the event's
+// String() value, with any tabs or line breaks escaped as "\t", "\n", or
+// "\r".
+func (f *SMFFile) WriteTSV(w io.Writer) error {
+	output := bufio.NewWriter(w)
+	_, e := fmt.Fprintf(output, "track\tabsolute_tick\tseconds\tevent_string\n")
+	if e != nil {
+		return fmt.Errorf("Failed writing TSV header: %s", e)
+	}
+	tempoMap := f.TempoMap()
+	for _, event := range f.TimedEvents() {
+		_, e = fmt.Fprintf(output, "%d\t%d\t%.6f\t%s\n", event.Track,
+			event.Tick, tempoMap.Seconds(event.Tick),
+			tsvEscaper.Replace(event.Message.String()))
+		if e != nil {
+			return fmt.Errorf("Failed writing event %d in track %d: %s",
+				event.Index, event.Track, e)
+		}
+	}
+	e = output.Flush()
+	if e != nil {
+		return fmt.Errorf("Failed writing TSV data: %s", e)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package midi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTSV(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{
+						TextEventType: 0x01,
+						Data:          []byte("a\tb"),
+					},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 96, 0},
+			},
+		},
+	}
+	var output bytes.Buffer
+	e := smf.WriteTSV(&output)
+	if e != nil {
+		t.Logf("Failed writing TSV: %s\n", e)
+		t.FailNow()
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Logf("Expected 5 lines of output, got %d\n", len(lines))
+		t.FailNow()
+	}
+	if lines[0] != "track\tabsolute_tick\tseconds\tevent_string" {
+		t.Logf("Got incorrect header: %s\n", lines[0])
+		t.FailNow()
+	}
+	for i, line := range lines {
+		if strings.Count(line, "\t") != 3 {
+			t.Logf("Line %d doesn't contain 4 columns: %s\n", i, line)
+			t.FailNow()
+		}
+	}
+	if !strings.HasPrefix(lines[3], "0\t96\t0.500000\t") {
+		t.Logf("Got incorrect line for the note-off: %s\n", lines[3])
+		t.FailNow()
+	}
+}
@@ -0,0 +1,144 @@
+package midi
+
+// This file contains functions for generating new MIDI content.
+
+import (
+	"fmt"
+	"math"
+)
+
+// The velocity used for notes created by functions in this file.
+const defaultGeneratedVelocity = 100
+
+// Some common interval patterns, in semitones, for use with GenerateScale.
+var (
+	MajorScaleIntervals         = []int{2, 2, 1, 2, 2, 2, 1}
+	NaturalMinorScaleIntervals  = []int{2, 1, 2, 2, 1, 2, 2}
+	HarmonicMinorScaleIntervals = []int{2, 1, 2, 2, 1, 3, 1}
+	ChromaticScaleIntervals     = []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+)
+
+// Returns a new track containing an ascending scale, played on the given
+// channel. The scale starts at root, and each subsequent note is the given
+// number of semitones above the previous one, so a pattern of n intervals
+// produces n + 1 notes (e.g. MajorScaleIntervals produces a full octave,
+// ending on the root an octave higher). Each note lasts for noteTicks ticks,
+// and the next note starts immediately after it. The scale stops early if a
+// note would fall outside of the valid range of MIDI notes. The returned
+// track ends with an end-of-track event. The channel is masked to 4 bits.
+func GenerateScale(root MIDINote, intervals []int, noteTicks uint32,
+	channel uint8) *SMFTrack {
+	channel &= 0xf
+	notes := []MIDINote{root & 0x7f}
+	current := int(root & 0x7f)
+	for _, interval := range intervals {
+		current += interval
+		if (current < 0) || (current > 0x7f) {
+			break
+		}
+		notes = append(notes, MIDINote(current))
+	}
+	toReturn := &SMFTrack{
+		Messages:   make([]MIDIMessage, 0, len(notes)*2+1),
+		TimeDeltas: make([]uint32, 0, len(notes)*2+1),
+	}
+	for _, n := range notes {
+		toReturn.Append(0, &NoteOnEvent{
+			Channel:  channel,
+			Note:     n,
+			Velocity: defaultGeneratedVelocity,
+		})
+		toReturn.Append(noteTicks, &NoteOffEvent{
+			Channel: channel,
+			Note:    n,
+		})
+	}
+	toReturn.Append(0, EndOfTrackMetaEvent(0))
+	return toReturn
+}
+
+// Returns a new single-track (format 0) file playing the given notes at the
+// given tempo, in beats (quarter notes) per minute. The track starts with a
+// set-tempo event and ends with an end-of-track event after the last note
+// ends. Note-offs are placed before note-ons occurring at the same time, so
+// notes that immediately repeat the same pitch are played correctly. Returns
+// an error if any argument or note is invalid, including notes with a
+// duration of 0.
+func QuickSMF(ticksPerQuarter uint16, bpm float64, notes []Note) (*SMFFile,
+	error) {
+	if (ticksPerQuarter == 0) || (ticksPerQuarter > 0x7fff) {
+		return nil, fmt.Errorf("Invalid number of ticks per quarter note: %d",
+			ticksPerQuarter)
+	}
+	if !(bpm > 0) {
+		return nil, fmt.Errorf("Invalid tempo: %f BPM", bpm)
+	}
+	microseconds := math.Round(60000000.0 / bpm)
+	if (microseconds < 1) || (microseconds > 0xffffff) {
+		return nil, fmt.Errorf("Tempo of %f BPM is out of range", bpm)
+	}
+	noteOffs := make([]timedMessage, 0, len(notes))
+	noteOns := make([]timedMessage, 0, len(notes))
+	for i, n := range notes {
+		if n.Channel > 0xf {
+			return nil, fmt.Errorf("Note %d has invalid channel %d", i,
+				n.Channel)
+		}
+		if n.Pitch > 0x7f {
+			return nil, fmt.Errorf("Note %d has invalid pitch %d", i,
+				uint8(n.Pitch))
+		}
+		if (n.Velocity == 0) || (n.Velocity > 0x7f) {
+			return nil, fmt.Errorf("Note %d has invalid velocity %d", i,
+				n.Velocity)
+		}
+		if n.Duration == 0 {
+			return nil, fmt.Errorf("Note %d has a duration of 0", i)
+		}
+		end := uint64(n.Tick) + uint64(n.Duration)
+		if end > 0xffffffff {
+			return nil, fmt.Errorf("Note %d ends too late: tick %d", i, end)
+		}
+		noteOns = append(noteOns, timedMessage{
+			tick: n.Tick,
+			message: &NoteOnEvent{
+				Channel:  n.Channel,
+				Note:     n.Pitch,
+				Velocity: n.Velocity,
+			},
+		})
+		noteOffs = append(noteOffs, timedMessage{
+			tick: uint32(end),
+			message: &NoteOffEvent{
+				Channel: n.Channel,
+				Note:    n.Pitch,
+			},
+		})
+	}
+	events := make([]timedMessage, 0, len(notes)*2+2)
+	events = append(events, timedMessage{
+		tick:    0,
+		message: SetTempoMetaEvent(uint32(microseconds)),
+	})
+	// Since events are stable-sorted by time, putting the note-offs first
+	// means they'll come before any simultaneous note-ons.
+	events = append(events, noteOffs...)
+	events = append(events, noteOns...)
+	// setTimedMessages will move this after the final note-off.
+	events = append(events, timedMessage{
+		tick:    0,
+		message: EndOfTrackMetaEvent(0),
+	})
+	track := &SMFTrack{}
+	track.setTimedMessages(events)
+	for i, d := range track.TimeDeltas {
+		if d > 0x0fffffff {
+			return nil, fmt.Errorf("Time delta for event %d is too large: %d",
+				i, d)
+		}
+	}
+	return &SMFFile{
+		Division: TimeDivision(ticksPerQuarter),
+		Tracks:   []*SMFTrack{track},
+	}, nil
+}
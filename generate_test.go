@@ -0,0 +1,96 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestGenerateScale(t *testing.T) {
+	track := GenerateScale(60, MajorScaleIntervals, 48, 3)
+	expected := []MIDINote{60, 62, 64, 65, 67, 69, 71, 72}
+	pairs := track.pairNotes()
+	if len(pairs) != len(expected) {
+		t.Logf("Expected %d notes, got %d\n", len(expected), len(pairs))
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	for i, p := range pairs {
+		noteOn := track.Messages[p.onIndex].(*NoteOnEvent)
+		if noteOn.Note != expected[i] {
+			t.Logf("Expected note %d to be %s, got %s\n", i, expected[i],
+				noteOn.Note)
+			t.FailNow()
+		}
+		if noteOn.Channel != 3 {
+			t.Logf("Note %d has the wrong channel: %d\n", i, noteOn.Channel)
+			t.FailNow()
+		}
+		if (p.offIndex < 0) || (ticks[p.offIndex]-ticks[p.onIndex] != 48) {
+			t.Logf("Note %d doesn't last for 48 ticks\n", i)
+			t.FailNow()
+		}
+	}
+	_, isEnd := track.Messages[len(track.Messages)-1].(EndOfTrackMetaEvent)
+	if !isEnd {
+		t.Logf("The track didn't end with an end-of-track event\n")
+		t.FailNow()
+	}
+	// The scale should stop before going past the highest MIDI note.
+	track = GenerateScale(120, MajorScaleIntervals, 48, 0)
+	if len(track.pairNotes()) != 5 {
+		t.Logf("Expected 5 notes in a scale starting at 120, got %d\n",
+			len(track.pairNotes()))
+		t.FailNow()
+	}
+}
+
+func TestQuickSMF(t *testing.T) {
+	notes := []Note{
+		{Channel: 0, Pitch: 60, Velocity: 100, Tick: 0, Duration: 96},
+		{Channel: 0, Pitch: 60, Velocity: 100, Tick: 96, Duration: 96},
+		{Channel: 1, Pitch: 48, Velocity: 80, Tick: 0, Duration: 192},
+	}
+	smf, e := QuickSMF(96, 90, notes)
+	if e != nil {
+		t.Logf("Failed creating file: %s\n", e)
+		t.FailNow()
+	}
+	track := smf.Tracks[0]
+	tempo, ok := track.Messages[0].(SetTempoMetaEvent)
+	if !ok || (tempo != 666667) {
+		t.Logf("The file didn't start with the correct tempo\n")
+		t.FailNow()
+	}
+	data, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing the created file: %s\n", e)
+		t.FailNow()
+	}
+	parsed, e := ParseSMFBytes(data)
+	if e != nil {
+		t.Logf("Failed parsing the created file: %s\n", e)
+		t.FailNow()
+	}
+	got := parsed.Notes()
+	if len(got) != len(notes) {
+		t.Logf("Expected %d notes, got %d\n", len(notes), len(got))
+		t.FailNow()
+	}
+	for _, n := range notes {
+		found := false
+		for _, v := range got {
+			if v == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Logf("Didn't find note %+v in the created file\n", n)
+			t.FailNow()
+		}
+	}
+	_, e = QuickSMF(96, 0, notes)
+	if e == nil {
+		t.Logf("Didn't get an error for a tempo of 0 BPM\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,289 @@
+// The gm package contains tables of names defined by the General MIDI
+// standard, such as instrument, drum, and controller names. It doesn't depend
+// on the midi package, so the tables can be used on their own.
+package gm
+
+// The names of the 128 General MIDI instruments, indexed by program number
+// (starting from 0).
+var InstrumentNames = [128]string{
+	"Acoustic Grand Piano",
+	"Bright Acoustic Piano",
+	"Electric Grand Piano",
+	"Honky-tonk Piano",
+	"Electric Piano 1",
+	"Electric Piano 2",
+	"Harpsichord",
+	"Clavinet",
+	"Celesta",
+	"Glockenspiel",
+	"Music Box",
+	"Vibraphone",
+	"Marimba",
+	"Xylophone",
+	"Tubular Bells",
+	"Dulcimer",
+	"Drawbar Organ",
+	"Percussive Organ",
+	"Rock Organ",
+	"Church Organ",
+	"Reed Organ",
+	"Accordion",
+	"Harmonica",
+	"Tango Accordion",
+	"Acoustic Guitar (nylon)",
+	"Acoustic Guitar (steel)",
+	"Electric Guitar (jazz)",
+	"Electric Guitar (clean)",
+	"Electric Guitar (muted)",
+	"Overdriven Guitar",
+	"Distortion Guitar",
+	"Guitar Harmonics",
+	"Acoustic Bass",
+	"Electric Bass (finger)",
+	"Electric Bass (pick)",
+	"Fretless Bass",
+	"Slap Bass 1",
+	"Slap Bass 2",
+	"Synth Bass 1",
+	"Synth Bass 2",
+	"Violin",
+	"Viola",
+	"Cello",
+	"Contrabass",
+	"Tremolo Strings",
+	"Pizzicato Strings",
+	"Orchestral Harp",
+	"Timpani",
+	"String Ensemble 1",
+	"String Ensemble 2",
+	"Synth Strings 1",
+	"Synth Strings 2",
+	"Choir Aahs",
+	"Voice Oohs",
+	"Synth Voice",
+	"Orchestra Hit",
+	"Trumpet",
+	"Trombone",
+	"Tuba",
+	"Muted Trumpet",
+	"French Horn",
+	"Brass Section",
+	"Synth Brass 1",
+	"Synth Brass 2",
+	"Soprano Sax",
+	"Alto Sax",
+	"Tenor Sax",
+	"Baritone Sax",
+	"Oboe",
+	"English Horn",
+	"Bassoon",
+	"Clarinet",
+	"Piccolo",
+	"Flute",
+	"Recorder",
+	"Pan Flute",
+	"Blown Bottle",
+	"Shakuhachi",
+	"Whistle",
+	"Ocarina",
+	"Lead 1 (square)",
+	"Lead 2 (sawtooth)",
+	"Lead 3 (calliope)",
+	"Lead 4 (chiff)",
+	"Lead 5 (charang)",
+	"Lead 6 (voice)",
+	"Lead 7 (fifths)",
+	"Lead 8 (bass + lead)",
+	"Pad 1 (new age)",
+	"Pad 2 (warm)",
+	"Pad 3 (polysynth)",
+	"Pad 4 (choir)",
+	"Pad 5 (bowed)",
+	"Pad 6 (metallic)",
+	"Pad 7 (halo)",
+	"Pad 8 (sweep)",
+	"FX 1 (rain)",
+	"FX 2 (soundtrack)",
+	"FX 3 (crystal)",
+	"FX 4 (atmosphere)",
+	"FX 5 (brightness)",
+	"FX 6 (goblins)",
+	"FX 7 (echoes)",
+	"FX 8 (sci-fi)",
+	"Sitar",
+	"Banjo",
+	"Shamisen",
+	"Koto",
+	"Kalimba",
+	"Bagpipe",
+	"Fiddle",
+	"Shanai",
+	"Tinkle Bell",
+	"Agogo",
+	"Steel Drums",
+	"Woodblock",
+	"Taiko Drum",
+	"Melodic Tom",
+	"Synth Drum",
+	"Reverse Cymbal",
+	"Guitar Fret Noise",
+	"Breath Noise",
+	"Seashore",
+	"Bird Tweet",
+	"Telephone Ring",
+	"Helicopter",
+	"Applause",
+	"Gunshot",
+}
+
+// Maps General MIDI percussion notes (played on channel 10, which is index 9
+// when numbering channels from 0) to the name of the drum sound they trigger.
+var DrumNames = map[uint8]string{
+	35: "Acoustic Bass Drum",
+	36: "Bass Drum 1",
+	37: "Side Stick",
+	38: "Acoustic Snare",
+	39: "Hand Clap",
+	40: "Electric Snare",
+	41: "Low Floor Tom",
+	42: "Closed Hi-Hat",
+	43: "High Floor Tom",
+	44: "Pedal Hi-Hat",
+	45: "Low Tom",
+	46: "Open Hi-Hat",
+	47: "Low-Mid Tom",
+	48: "Hi-Mid Tom",
+	49: "Crash Cymbal 1",
+	50: "High Tom",
+	51: "Ride Cymbal 1",
+	52: "Chinese Cymbal",
+	53: "Ride Bell",
+	54: "Tambourine",
+	55: "Splash Cymbal",
+	56: "Cowbell",
+	57: "Crash Cymbal 2",
+	58: "Vibraslap",
+	59: "Ride Cymbal 2",
+	60: "Hi Bongo",
+	61: "Low Bongo",
+	62: "Mute Hi Conga",
+	63: "Open Hi Conga",
+	64: "Low Conga",
+	65: "High Timbale",
+	66: "Low Timbale",
+	67: "High Agogo",
+	68: "Low Agogo",
+	69: "Cabasa",
+	70: "Maracas",
+	71: "Short Whistle",
+	72: "Long Whistle",
+	73: "Short Guiro",
+	74: "Long Guiro",
+	75: "Claves",
+	76: "Hi Wood Block",
+	77: "Low Wood Block",
+	78: "Mute Cuica",
+	79: "Open Cuica",
+	80: "Mute Triangle",
+	81: "Open Triangle",
+}
+
+// Maps control change controller numbers to the names given to them by the
+// MIDI 1.0 and General MIDI specifications. Undefined controllers aren't
+// included.
+var ControllerNames = map[uint8]string{
+	0:   "Bank Select",
+	1:   "Modulation Wheel",
+	2:   "Breath Controller",
+	4:   "Foot Controller",
+	5:   "Portamento Time",
+	6:   "Data Entry",
+	7:   "Channel Volume",
+	8:   "Balance",
+	10:  "Pan",
+	11:  "Expression Controller",
+	12:  "Effect Control 1",
+	13:  "Effect Control 2",
+	16:  "General Purpose Controller 1",
+	17:  "General Purpose Controller 2",
+	18:  "General Purpose Controller 3",
+	19:  "General Purpose Controller 4",
+	32:  "Bank Select LSB",
+	33:  "Modulation Wheel LSB",
+	34:  "Breath Controller LSB",
+	36:  "Foot Controller LSB",
+	37:  "Portamento Time LSB",
+	38:  "Data Entry LSB",
+	39:  "Channel Volume LSB",
+	40:  "Balance LSB",
+	42:  "Pan LSB",
+	43:  "Expression Controller LSB",
+	44:  "Effect Control 1 LSB",
+	45:  "Effect Control 2 LSB",
+	64:  "Sustain Pedal",
+	65:  "Portamento On/Off",
+	66:  "Sostenuto",
+	67:  "Soft Pedal",
+	68:  "Legato Footswitch",
+	69:  "Hold 2",
+	70:  "Sound Variation",
+	71:  "Timbre/Harmonic Intensity",
+	72:  "Release Time",
+	73:  "Attack Time",
+	74:  "Brightness",
+	75:  "Decay Time",
+	76:  "Vibrato Rate",
+	77:  "Vibrato Depth",
+	78:  "Vibrato Delay",
+	79:  "Sound Controller 10",
+	80:  "General Purpose Controller 5",
+	81:  "General Purpose Controller 6",
+	82:  "General Purpose Controller 7",
+	83:  "General Purpose Controller 8",
+	84:  "Portamento Control",
+	88:  "High Resolution Velocity Prefix",
+	91:  "Reverb Send Level",
+	92:  "Tremolo Depth",
+	93:  "Chorus Send Level",
+	94:  "Celeste Depth",
+	95:  "Phaser Depth",
+	96:  "Data Increment",
+	97:  "Data Decrement",
+	98:  "Non-Registered Parameter Number LSB",
+	99:  "Non-Registered Parameter Number MSB",
+	100: "Registered Parameter Number LSB",
+	101: "Registered Parameter Number MSB",
+	120: "All Sound Off",
+	121: "Reset All Controllers",
+	122: "Local Control",
+	123: "All Notes Off",
+	124: "Omni Mode Off",
+	125: "Omni Mode On",
+	126: "Mono Mode On",
+	127: "Poly Mode On",
+}
+
+// Returns the name of the General MIDI instrument with the given program
+// number, starting from 0. Returns an empty string if the program number is
+// greater than 127.
+func InstrumentName(program uint8) string {
+	if program > 127 {
+		return ""
+	}
+	return InstrumentNames[program]
+}
+
+// Returns the name of the General MIDI drum sound triggered by the given note
+// on the percussion channel, and true. Returns false if the note doesn't
+// correspond to a drum sound.
+func DrumName(note uint8) (string, bool) {
+	name, ok := DrumNames[note]
+	return name, ok
+}
+
+// Returns the name of the given controller number, and true. Returns false if
+// the controller number is undefined.
+func ControllerName(controller uint8) (string, bool) {
+	name, ok := ControllerNames[controller]
+	return name, ok
+}
@@ -0,0 +1,42 @@
+package gm
+
+import (
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	for i, name := range InstrumentNames {
+		if name == "" {
+			t.Logf("Missing a name for instrument %d\n", i)
+			t.FailNow()
+		}
+	}
+	if InstrumentName(0) != "Acoustic Grand Piano" {
+		t.Logf("Got incorrect name for program 0: %s\n", InstrumentName(0))
+		t.FailNow()
+	}
+	if InstrumentName(128) != "" {
+		t.Logf("Didn't get an empty name for an invalid program\n")
+		t.FailNow()
+	}
+	name, ok := DrumName(38)
+	if !ok || (name != "Acoustic Snare") {
+		t.Logf("Got incorrect name for drum 38: %s (%v)\n", name, ok)
+		t.FailNow()
+	}
+	_, ok = DrumName(10)
+	if ok {
+		t.Logf("Got a name for an undefined drum sound\n")
+		t.FailNow()
+	}
+	name, ok = ControllerName(64)
+	if !ok || (name != "Sustain Pedal") {
+		t.Logf("Got incorrect name for controller 64: %s (%v)\n", name, ok)
+		t.FailNow()
+	}
+	_, ok = ControllerName(3)
+	if ok {
+		t.Logf("Got a name for an undefined controller\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,28 @@
+package midi
+
+// This file contains helpers for describing events using the names defined by
+// the General MIDI standard, which are stored in the gm subpackage.
+
+import (
+	"github.com/yalue/midi/gm"
+)
+
+// The channel used for percussion by General MIDI: channel 10 when numbered
+// from 1.
+const gmPercussionChannel = 9
+
+// Returns a description of the given note played on the given channel. For
+// gmPercussionChannel, this will be the name of the General MIDI drum sound,
+// if the note maps to one. Otherwise, this is the same as the note's String()
+// value. Channels that are only set to a drum part by other events, as
+// reported by SMFFile.PercussionChannels, aren't known when describing a
+// single event, so their notes are described by pitch.
+func describeNote(channel uint8, note MIDINote) string {
+	if channel == gmPercussionChannel {
+		name, ok := gm.DrumName(uint8(note))
+		if ok {
+			return name
+		}
+	}
+	return note.String()
+}
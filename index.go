@@ -0,0 +1,205 @@
+package midi
+
+// This file contains code for locating the chunks in an SMF file without
+// parsing their contents, and for lazily reading the events of a single track.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Describes where a chunk's data is located within an SMF file.
+type ChunkLocation struct {
+	// The file offset of the chunk's data, following its type and length.
+	Offset int64
+	// The length of the chunk's data, in bytes.
+	Length uint32
+}
+
+// Holds the header of an SMF file, along with the locations of its track
+// chunks, so that individual tracks can be read without parsing the entire
+// file. Obtain one using IndexSMFFile.
+type SMFIndex struct {
+	Header SMFHeader
+	// The location of each track chunk, in the order they appear in the file.
+	Tracks []ChunkLocation
+	// The options used when decoding events from the indexed tracks. May be
+	// nil.
+	options *ParseOptions
+}
+
+// Reads the header of the SMF file in r, and the type and length of each of
+// its track chunks, without reading any events. Returns an error if the file
+// doesn't contain as many track chunks as its header claims, or if a chunk
+// extends past the end of the file. Unlike ParseSMFFile, this doesn't tolerate
+// stray bytes between chunks.
+func IndexSMFFile(r io.ReaderAt) (*SMFIndex, error) {
+	return IndexSMFFileWithOptions(r, nil)
+}
+
+// Like IndexSMFFile, but uses the given parsing options, which are also used
+// by the index's TrackIterators when decoding events. The options may be nil,
+// in which case the defaults are used. If MaxTracks is set, at most that many
+// tracks are indexed. RecordOffsets is ignored.
+func IndexSMFFileWithOptions(r io.ReaderAt, options *ParseOptions) (*SMFIndex,
+	error) {
+	header, e := parseSMFHeader(io.NewSectionReader(r, 0, math.MaxInt64))
+	if e != nil {
+		return nil, fmt.Errorf("Failed parsing SMF header: %s", e)
+	}
+	toReturn := &SMFIndex{
+		Header:  *header,
+		Tracks:  make([]ChunkLocation, 0, header.TrackCount),
+		options: options,
+	}
+	trackCount := int(header.TrackCount)
+	if (options != nil) && (options.MaxTracks > 0) &&
+		(options.MaxTracks < trackCount) {
+		trackCount = options.MaxTracks
+	}
+	offset := 8 + int64(header.ChunkSize)
+	chunkHeader := make([]byte, 8)
+	for i := 0; i < trackCount; i++ {
+		_, e = r.ReadAt(chunkHeader, offset)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading chunk header for track "+
+				"%d at offset 0x%X: %s", i, offset, e)
+		}
+		if string(chunkHeader[0:4]) != "MTrk" {
+			return nil, fmt.Errorf("Bad chunk type for track %d: %q", i,
+				string(chunkHeader[0:4]))
+		}
+		location := ChunkLocation{
+			Offset: offset + 8,
+			Length: binary.BigEndian.Uint32(chunkHeader[4:8]),
+		}
+		// Make sure the last byte of the chunk can be read, if it has any.
+		if location.Length != 0 {
+			end := location.Offset + int64(location.Length)
+			_, e = r.ReadAt(chunkHeader[0:1], end-1)
+			if e != nil {
+				return nil, fmt.Errorf("Track %d's length of %d bytes "+
+					"extends past the end of the file", i, location.Length)
+			}
+		}
+		toReturn.Tracks = append(toReturn.Tracks, location)
+		offset = location.Offset + int64(location.Length)
+	}
+	return toReturn, nil
+}
+
+// Reads the events in a single track of an SMF file one at a time, without
+// loading the entire track into memory. Obtain one using
+// SMFIndex.TrackIterator.
+type TrackIterator struct {
+	r             io.ReaderAt
+	trackIndex    int
+	location      ChunkLocation
+	options       *ParseOptions
+	reader        *io.LimitedReader
+	runningStatus byte
+	// The index and absolute time of the next event to be decoded.
+	index int
+	tick  uint32
+	// Set to true after the end-of-track event has been returned.
+	done bool
+	// An event that was decoded while seeking, but not yet returned by Next.
+	pending *TimedEvent
+}
+
+// Returns an iterator over the events in the track with the given index,
+// reading the track's data from r, which must contain the same file that was
+// indexed. Events are decoded using the options the index was created with.
+func (idx *SMFIndex) TrackIterator(r io.ReaderAt, trackIndex int) (
+	*TrackIterator, error) {
+	if (trackIndex < 0) || (trackIndex >= len(idx.Tracks)) {
+		return nil, fmt.Errorf("Invalid track index: %d", trackIndex)
+	}
+	toReturn := &TrackIterator{
+		r:          r,
+		trackIndex: trackIndex,
+		location:   idx.Tracks[trackIndex],
+		options:    idx.options,
+	}
+	toReturn.rewind()
+	return toReturn, nil
+}
+
+// Moves the iterator back to the start of its track.
+func (t *TrackIterator) rewind() {
+	t.reader = &io.LimitedReader{
+		R: io.NewSectionReader(t.r, t.location.Offset,
+			int64(t.location.Length)),
+		N: int64(t.location.Length),
+	}
+	t.runningStatus = 0
+	t.index = 0
+	t.tick = 0
+	t.done = false
+	t.pending = nil
+}
+
+// Decodes and returns the next event in the track. Returns io.EOF once every
+// event has been returned. Any data following an end-of-track event is
+// ignored.
+func (t *TrackIterator) Next() (TimedEvent, error) {
+	if t.pending != nil {
+		toReturn := *t.pending
+		t.pending = nil
+		return toReturn, nil
+	}
+	if t.done {
+		return TimedEvent{}, io.EOF
+	}
+	timeDelta, e := ReadVariableInt(t.reader)
+	if e != nil {
+		if e == io.EOF {
+			t.done = true
+			return TimedEvent{}, io.EOF
+		}
+		return TimedEvent{}, fmt.Errorf("Failed reading time delta for "+
+			"event %d: %s", t.index, e)
+	}
+	message, e := ReadSMFMessageWithOptions(t.reader, &t.runningStatus,
+		t.options)
+	if e != nil {
+		return TimedEvent{}, fmt.Errorf("Failed reading MIDI message for "+
+			"event %d: %s", t.index, e)
+	}
+	t.tick += timeDelta
+	toReturn := TimedEvent{
+		Track:   t.trackIndex,
+		Index:   t.index,
+		Tick:    t.tick,
+		Message: message,
+	}
+	t.index++
+	if _, isEnd := message.(EndOfTrackMetaEvent); isEnd {
+		t.done = true
+	}
+	return toReturn, nil
+}
+
+// Repositions the iterator so that the next call to Next returns the first
+// event at or after the given absolute time, in ticks. Since events can't be
+// located without decoding every event before them, this decodes the track
+// from its start. If no events occur at or after the tick, subsequent calls
+// to Next will return io.EOF.
+func (t *TrackIterator) SeekToTick(tick uint32) error {
+	t.rewind()
+	for {
+		event, e := t.Next()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return fmt.Errorf("Failed seeking to tick %d: %w", tick, e)
+		}
+		if event.Tick >= tick {
+			t.pending = &event
+			return nil
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package midi
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTrackIterator(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0, 96, 0},
+			},
+		},
+	}
+	data, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing test file: %s\n", e)
+		t.FailNow()
+	}
+	r := bytes.NewReader(data)
+	idx, e := IndexSMFFile(r)
+	if e != nil {
+		t.Logf("Failed indexing test file: %s\n", e)
+		t.FailNow()
+	}
+	if len(idx.Tracks) != 2 {
+		t.Logf("Expected 2 tracks in the index, got %d\n", len(idx.Tracks))
+		t.FailNow()
+	}
+	_, e = idx.TrackIterator(r, 2)
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid track index\n")
+		t.FailNow()
+	}
+	iterator, e := idx.TrackIterator(r, 1)
+	if e != nil {
+		t.Logf("Failed getting track iterator: %s\n", e)
+		t.FailNow()
+	}
+	count := 0
+	for {
+		event, e := iterator.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Logf("Failed reading event %d: %s\n", count, e)
+			t.FailNow()
+		}
+		if (event.Index != count) || (event.Track != 1) {
+			t.Logf("Got incorrect location for event %d: %+v\n", count,
+				event)
+			t.FailNow()
+		}
+		count++
+	}
+	if count != 5 {
+		t.Logf("Expected 5 events, got %d\n", count)
+		t.FailNow()
+	}
+	e = iterator.SeekToTick(96)
+	if e != nil {
+		t.Logf("Failed seeking to tick 96: %s\n", e)
+		t.FailNow()
+	}
+	event, e := iterator.Next()
+	if e != nil {
+		t.Logf("Failed reading event after seeking: %s\n", e)
+		t.FailNow()
+	}
+	if (event.Index != 1) || (event.Tick != 96) {
+		t.Logf("Got incorrect event after seeking: %+v\n", event)
+		t.FailNow()
+	}
+	event, e = iterator.Next()
+	if (e != nil) || (event.Index != 2) {
+		t.Logf("Didn't continue from the correct event after seeking\n")
+		t.FailNow()
+	}
+	e = iterator.SeekToTick(1000)
+	if e != nil {
+		t.Logf("Failed seeking past the end of the track: %s\n", e)
+		t.FailNow()
+	}
+	_, e = iterator.Next()
+	if e != io.EOF {
+		t.Logf("Expected io.EOF after seeking past the end, got %v\n", e)
+		t.FailNow()
+	}
+	// Truncating the file should cause an error when indexing.
+	_, e = IndexSMFFile(bytes.NewReader(data[:len(data)-2]))
+	if e == nil {
+		t.Logf("Didn't get an error when indexing a truncated file\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for a truncated file: %s\n", e)
+}
+
+func TestIndexSMFFileWithOptions(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					UndefinedSystemEvent(0xf4),
+					&TextMetaEvent{
+						TextEventType: 1,
+						Data:          []byte("twenty bytes of text"),
+					},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 0},
+			},
+			{
+				Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{0},
+			},
+		},
+	}
+	data, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing test file: %s\n", e)
+		t.FailNow()
+	}
+	r := bytes.NewReader(data)
+
+	// The undefined status should be rejected by default.
+	idx, e := IndexSMFFile(r)
+	if e != nil {
+		t.Logf("Failed indexing test file: %s\n", e)
+		t.FailNow()
+	}
+	iterator, e := idx.TrackIterator(r, 0)
+	if e != nil {
+		t.Logf("Failed getting track iterator: %s\n", e)
+		t.FailNow()
+	}
+	_, e = iterator.Next()
+	if e == nil {
+		t.Logf("Didn't get an error for an undefined status by default\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an undefined status: %s\n", e)
+
+	options := &ParseOptions{
+		MaxEventBytes:              10,
+		MaxTracks:                  1,
+		AllowUndefinedSystemEvents: true,
+	}
+	idx, e = IndexSMFFileWithOptions(r, options)
+	if e != nil {
+		t.Logf("Failed indexing test file with options: %s\n", e)
+		t.FailNow()
+	}
+	if len(idx.Tracks) != 1 {
+		t.Logf("Expected MaxTracks to limit the index to 1 track, got %d\n",
+			len(idx.Tracks))
+		t.FailNow()
+	}
+	iterator, e = idx.TrackIterator(r, 0)
+	if e != nil {
+		t.Logf("Failed getting track iterator with options: %s\n", e)
+		t.FailNow()
+	}
+	event, e := iterator.Next()
+	if e != nil {
+		t.Logf("Failed reading an undefined status with options: %s\n", e)
+		t.FailNow()
+	}
+	if event.Message != UndefinedSystemEvent(0xf4) {
+		t.Logf("Got incorrect first event: %s\n", event.Message)
+		t.FailNow()
+	}
+	_, e = iterator.Next()
+	if e == nil {
+		t.Logf("Didn't get an error for an event exceeding MaxEventBytes\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an event exceeding MaxEventBytes: %s\n", e)
+}
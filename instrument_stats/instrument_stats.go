@@ -6,8 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"github.com/yalue/midi"
+	"github.com/yalue/midi/gm"
 	"os"
-	"path/filepath"
 	"runtime"
 )
 
@@ -18,35 +18,44 @@ type instrumentStats struct {
 	// event.
 	eventCounts [128]uint64
 	// A slice containing 128 entries: one value per MIDI percussion
-	// instrument event (basically, a count of each note played on channel 10)
+	// instrument event (basically, a count of each note played on a
+	// percussion channel)
 	percussionEventCounts [128]uint64
+	// A channel to treat as percussion in every file, in addition to the
+	// channels reported by PercussionChannels. Starts from 0, or is negative
+	// if there's no such channel.
+	extraPercussionChannel int
 }
 
 // Dumps the total counts for each instrument to stdout.
 func (s *instrumentStats) printInfo() {
 	for i := 0; i < 128; i++ {
-		fmt.Printf("Instrument %d: %d events.\n", i, s.eventCounts[i])
+		fmt.Printf("Instrument %d (%s): %d events.\n", i,
+			gm.InstrumentName(uint8(i)), s.eventCounts[i])
 	}
 	for i := 0; i < 128; i++ {
-		fmt.Printf("Percussion instrument %d: %d events.\n", i,
+		name, ok := gm.DrumName(uint8(i))
+		if !ok {
+			name = "undefined"
+		}
+		fmt.Printf("Percussion instrument %d (%s): %d events.\n", i, name,
 			s.percussionEventCounts[i])
 	}
 }
 
-// Adds the instrument-events for the named MIDI file to the running totals.
+// Adds the instrument-events for the given MIDI file to the running totals.
 // Returns an error if one occurs.
-func (s *instrumentStats) addFile(name string) error {
-	f, e := os.Open(name)
-	if e != nil {
-		return fmt.Errorf("Failed opening %s: %w", name, e)
-	}
-	defer f.Close()
-	smf, e := midi.ParseSMFFile(f)
-	if e != nil {
-		return fmt.Errorf("Failed parsing %s: %w", name, e)
-	}
+func (s *instrumentStats) addFile(smf *midi.SMFFile) error {
 	var channelInstruments [16]uint8
+	percussion := smf.PercussionChannels()
+	if s.extraPercussionChannel >= 0 {
+		percussion[s.extraPercussionChannel] = true
+	}
 	for _, track := range smf.Tracks {
+		// Skip conductor tracks or any other tracks that don't play notes.
+		if !track.HasNotes() {
+			continue
+		}
 		// For each track we'll reset the known instruments to 0. This may be
 		// incorrect...
 		for i := 0; i < 16; i++ {
@@ -62,8 +71,8 @@ func (s *instrumentStats) addFile(name string) error {
 					// don't count it.
 					continue
 				}
-				// Percussion = anything in channel 10 (index 9)
-				if noteOn.Channel == 9 {
+				// Percussion = anything in a percussion channel
+				if percussion[noteOn.Channel&0xf] {
 					s.percussionEventCounts[noteOn.Note]++
 				} else {
 					s.eventCounts[channelInstruments[noteOn.Channel]]++
@@ -85,30 +94,52 @@ func (s *instrumentStats) addFile(name string) error {
 
 func run() int {
 	var baseDir string
+	var recursive bool
+	var percussionChannel int
 	flag.StringVar(&baseDir, "dir", "", "The directory to scan for .mid files")
+	flag.BoolVar(&recursive, "recursive", false, "If set, also scan "+
+		"subdirectories of -dir.")
+	flag.IntVar(&percussionChannel, "percussion_channel", -1, "A channel "+
+		"to treat as percussion in every file, starting from 0. Channel 9, "+
+		"and any channels that a file sets to a drum part, are always "+
+		"treated as percussion.")
 	flag.Parse()
 	if baseDir == "" {
 		fmt.Println("A base directory must be specified." +
 			"Run with -help for usage.")
 		return 1
 	}
-	filenames, e := filepath.Glob(baseDir + "/*.mid")
-	if e != nil {
-		fmt.Printf("Failed looking up MIDI files in dir %s: %s\n", baseDir, e)
+	if percussionChannel > 15 {
+		fmt.Printf("Invalid percussion channel: %d\n", percussionChannel)
 		return 1
 	}
-	if len(filenames) <= 0 {
-		fmt.Printf("Didn't find any MIDI (.mid) files in dir %s.\n", baseDir)
-		return 1
+	stats := &instrumentStats{
+		extraPercussionChannel: percussionChannel,
 	}
-	stats := &instrumentStats{}
-	for i, name := range filenames {
-		fmt.Printf("Scanning file %d/%d: %s\n", i+1, len(filenames), name)
-		e = stats.addFile(name)
-		if e != nil {
-			fmt.Printf("Failed analyzing file %s: %s\n", name, e)
-		}
+	fileCount := 0
+	e := midi.ProcessDirectory(baseDir, recursive, func(name string,
+		smf *midi.SMFFile) error {
+		fileCount++
+		fmt.Printf("Scanning file %d: %s\n", fileCount, name)
+		e := stats.addFile(smf)
 		runtime.GC()
+		return e
+	})
+	if e != nil {
+		failures, ok := e.(midi.DirectoryErrors)
+		if !ok {
+			fmt.Printf("Failed looking up MIDI files in dir %s: %s\n",
+				baseDir, e)
+			return 1
+		}
+		for _, f := range failures {
+			fmt.Printf("Failed analyzing file %s: %s\n", f.Filename, f.Err)
+		}
+	}
+	if fileCount == 0 {
+		fmt.Printf("Didn't find any valid MIDI (.mid) files in dir %s.\n",
+			baseDir)
+		return 1
 	}
 	stats.printInfo()
 	return 0
@@ -0,0 +1,170 @@
+package midi
+
+// This file contains code for working with time signatures and bars.
+
+import (
+	"fmt"
+)
+
+// Returns the length of a bar in the given time signature, in ticks, using
+// the given number of ticks per quarter note. Returns an error if the time
+// signature is invalid, or if a bar isn't a whole number of ticks long.
+func (s *TimeSignatureMetaEvent) ticksPerBar(ticksPerQuarter uint16) (uint32,
+	error) {
+	if (s.Numerator == 0) || (s.Denominator > 31) {
+		return 0, fmt.Errorf("Invalid time signature: %s", s)
+	}
+	// A bar contains Numerator notes, each 4 / 2^Denominator quarter notes
+	// long.
+	wholeNoteTicks := uint64(ticksPerQuarter) * 4 * uint64(s.Numerator)
+	divisor := uint64(1) << s.Denominator
+	if (wholeNoteTicks % divisor) != 0 {
+		return 0, fmt.Errorf("A bar in %s doesn't contain a whole number of "+
+			"ticks", s)
+	}
+	toReturn := wholeNoteTicks / divisor
+	if (toReturn == 0) || (toReturn > 0xffffffff) {
+		return 0, fmt.Errorf("Invalid bar length for %s: %d ticks", s,
+			toReturn)
+	}
+	return uint32(toReturn), nil
+}
+
+// The time signature used when a file doesn't specify one: 4/4.
+var defaultTimeSignature = TimeSignatureMetaEvent{
+	Numerator:                      4,
+	Denominator:                    2,
+	ClocksPerMetronomeTick:         24,
+	Notated32ndNotesPerQuarterNote: 8,
+}
+
+// Returns the length, in ticks, of the pickup (anacrusis) before the first
+// downbeat, or 0 if the first note starts on a downbeat. Bar lines are
+// computed using the time signature in effect when the first note starts (or
+// 4/4, if there is no time signature by then), starting from the time of that
+// time signature. So, a file with a pickup is expected to start its first note
+// partway through a bar, e.g. on beat 4 of an otherwise-empty first bar.
+// Returns an error if the file uses an SMPTE-based time division, contains no
+// notes, or has an invalid time signature.
+func (f *SMFFile) Anacrusis() (uint32, error) {
+	ticksPerQuarter := f.Division.TicksPerQuarterNote()
+	if ticksPerQuarter == 0 {
+		return 0, fmt.Errorf("Can't find bars using time division %s",
+			f.Division)
+	}
+	firstNote, ok := f.firstNoteTick()
+	if !ok {
+		return 0, fmt.Errorf("The file doesn't contain any notes")
+	}
+	signature := &defaultTimeSignature
+	signatureTick := uint32(0)
+	for _, event := range f.TimedEvents() {
+		if event.Tick > firstNote {
+			break
+		}
+		v, ok := event.Message.(*TimeSignatureMetaEvent)
+		if ok {
+			signature = v
+			signatureTick = event.Tick
+		}
+	}
+	barTicks, e := signature.ticksPerBar(ticksPerQuarter)
+	if e != nil {
+		return 0, e
+	}
+	offset := (firstNote - signatureTick) % barTicks
+	if offset == 0 {
+		return 0, nil
+	}
+	return barTicks - offset, nil
+}
+
+// Holds a time signature event, along with its time and location in the file.
+type TimedTimeSignature struct {
+	Signature *TimeSignatureMetaEvent
+	// The absolute time of the event, in ticks.
+	Tick uint32
+	// The time of the event, in seconds from the start of the file.
+	Seconds float64
+	// The index of the track containing the event.
+	Track int
+}
+
+// Returns every time signature event in the file, from all tracks, sorted by
+// time. Simultaneous events are ordered as in TimedEvents. Returns nil if the
+// file doesn't contain any time signatures, in which case 4/4 time is usually
+// assumed.
+func (f *SMFFile) TimeSignatureChanges() []TimedTimeSignature {
+	var toReturn []TimedTimeSignature
+	var tempoMap *TempoMap
+	for _, event := range f.TimedEvents() {
+		v, ok := event.Message.(*TimeSignatureMetaEvent)
+		if !ok {
+			continue
+		}
+		if tempoMap == nil {
+			tempoMap = f.TempoMap()
+		}
+		toReturn = append(toReturn, TimedTimeSignature{
+			Signature: v,
+			Tick:      event.Tick,
+			Seconds:   tempoMap.Seconds(event.Tick),
+			Track:     event.Track,
+		})
+	}
+	return toReturn
+}
+
+// Returns the absolute time, in ticks, of each bar line in the file, in
+// increasing order, up to and including the time of the last event. The first
+// bar starts at tick 0, using 4/4 time until the first time signature. Each
+// time signature event starts a new bar, even if it occurs partway through a
+// bar in the previous time signature. If several time signatures occur at the
+// same time, the last one is used. Returns an error if the file uses an
+// SMPTE-based time division, contains an invalid time signature, or contains
+// more than MaxTimeWindows bars.
+func (f *SMFFile) BarLines() ([]uint32, error) {
+	ticksPerQuarter := f.Division.TicksPerQuarterNote()
+	if ticksPerQuarter == 0 {
+		return nil, fmt.Errorf("Can't find bars using time division %s",
+			f.Division)
+	}
+	// Collect the time signatures in effect starting at each tick where one
+	// changes.
+	type meterChange struct {
+		tick      uint32
+		signature *TimeSignatureMetaEvent
+	}
+	changes := []meterChange{{0, &defaultTimeSignature}}
+	for _, v := range f.TimeSignatureChanges() {
+		previous := &(changes[len(changes)-1])
+		if previous.tick == v.Tick {
+			previous.signature = v.Signature
+			continue
+		}
+		changes = append(changes, meterChange{v.Tick, v.Signature})
+	}
+	endTick := uint64(f.lastEventTick())
+	var toReturn []uint32
+	for i, c := range changes {
+		barTicks, e := c.signature.ticksPerBar(ticksPerQuarter)
+		if e != nil {
+			return nil, fmt.Errorf("Bad time signature at tick %d: %w",
+				c.tick, e)
+		}
+		// Bars in this time signature continue until the next change, or
+		// through the end of the file.
+		limit := endTick + 1
+		if i < (len(changes) - 1) {
+			limit = uint64(changes[i+1].tick)
+		}
+		for t := uint64(c.tick); t < limit; t += uint64(barTicks) {
+			if len(toReturn) >= MaxTimeWindows {
+				return nil, fmt.Errorf("The file contains more than %d bars",
+					MaxTimeWindows)
+			}
+			toReturn = append(toReturn, uint32(t))
+		}
+	}
+	return toReturn, nil
+}
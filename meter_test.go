@@ -0,0 +1,146 @@
+package midi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnacrusis(t *testing.T) {
+	// A 3/4 bar is 288 ticks at 96 ticks per quarter note, so a note starting
+	// at tick 192 is a one-beat pickup.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&TimeSignatureMetaEvent{Numerator: 3, Denominator: 2},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 192, 96, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	pickup, e := smf.Anacrusis()
+	if e != nil {
+		t.Logf("Failed getting anacrusis: %s\n", e)
+		t.FailNow()
+	}
+	if pickup != 96 {
+		t.Logf("Expected a 96-tick pickup, got %d\n", pickup)
+		t.FailNow()
+	}
+	// Starting the note on the second bar's downbeat means no pickup.
+	track.TimeDeltas[1] = 288
+	pickup, e = smf.Anacrusis()
+	if (e != nil) || (pickup != 0) {
+		t.Logf("Expected no pickup, got %d (error: %v)\n", pickup, e)
+		t.FailNow()
+	}
+	smf.Division = TimeDivision(0xe728)
+	_, e = smf.Anacrusis()
+	if e == nil {
+		t.Logf("Didn't get an error for an SMPTE time division\n")
+		t.FailNow()
+	}
+}
+
+func TestBarLines(t *testing.T) {
+	// Starts in the default 4/4 (384 ticks per bar), then changes to 3/4
+	// (288 ticks per bar) partway through the second bar.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TimeSignatureMetaEvent{Numerator: 3, Denominator: 2},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{600, 576},
+			},
+		},
+	}
+	bars, e := smf.BarLines()
+	if e != nil {
+		t.Logf("Failed getting bar lines: %s\n", e)
+		t.FailNow()
+	}
+	expected := []uint32{0, 384, 600, 888, 1176}
+	if len(bars) != len(expected) {
+		t.Logf("Expected bar lines %v, got %v\n", expected, bars)
+		t.FailNow()
+	}
+	for i := range expected {
+		if bars[i] != expected[i] {
+			t.Logf("Expected bar lines %v, got %v\n", expected, bars)
+			t.FailNow()
+		}
+	}
+	// A single event with a huge time delta shouldn't cause a huge
+	// allocation.
+	smf.Tracks[0].TimeDeltas[1] = 0xf0000000
+	_, e = smf.BarLines()
+	if e == nil {
+		t.Logf("Didn't get an error for too many bars\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for too many bars: %s\n", e)
+	smf.Tracks[0].TimeDeltas[1] = 576
+	smf.Tracks[0].Messages[0].(*TimeSignatureMetaEvent).Numerator = 0
+	_, e = smf.BarLines()
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid time signature\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an invalid time signature: %s\n", e)
+	smf.Division = TimeDivision(0xe728)
+	_, e = smf.BarLines()
+	if e == nil {
+		t.Logf("Didn't get an error for an SMPTE time division\n")
+		t.FailNow()
+	}
+}
+
+func TestTimeSignatureChanges(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&TimeSignatureMetaEvent{Numerator: 4, Denominator: 2},
+					&TimeSignatureMetaEvent{Numerator: 6, Denominator: 3},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 192, 0},
+			},
+		},
+	}
+	changes := smf.TimeSignatureChanges()
+	if len(changes) != 2 {
+		t.Logf("Expected 2 time signature changes, got %d\n", len(changes))
+		t.FailNow()
+	}
+	// The second change is 1 quarter note at 120 BPM, then 1 at 60 BPM.
+	second := changes[1]
+	if (second.Tick != 192) || (second.Track != 1) ||
+		(math.Abs(second.Seconds-1.5) > 0.0001) {
+		t.Logf("Got incorrect second time signature change: %+v\n", second)
+		t.FailNow()
+	}
+	if second.Signature.Numerator != 6 {
+		t.Logf("Got incorrect time signature: %s\n", second.Signature)
+		t.FailNow()
+	}
+	smf.Tracks = smf.Tracks[:1]
+	if smf.TimeSignatureChanges() != nil {
+		t.Logf("Expected nil for a file without time signatures\n")
+		t.FailNow()
+	}
+}
@@ -6,13 +6,18 @@ package midi
 import (
 	"bytes"
 	"fmt"
+	"github.com/yalue/midi/gm"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Reads and returns the next byte from r.
+// Reads and returns the next byte from r. Returns io.EOF if no more data is
+// available.
 func readByte(r io.Reader) (uint8, error) {
 	tmp := []uint8{0}
-	_, e := r.Read(tmp)
+	_, e := io.ReadFull(r, tmp)
 	return tmp[0], e
 }
 
@@ -76,6 +81,17 @@ func WriteVariableInt(w io.Writer, n uint32) error {
 	return e
 }
 
+// Returns the number of bytes that WriteVariableInt would write for n. Doesn't
+// check whether n is too large to be written.
+func variableIntLength(n uint32) int {
+	toReturn := 1
+	for n > 0x7f {
+		toReturn++
+		n = n >> 7
+	}
+	return toReturn
+}
+
 // A basic interface that all MIDI messages support.
 type MIDIMessage interface {
 	// A string representation of the event.
@@ -86,6 +102,14 @@ type MIDIMessage interface {
 	SMFData(runningStatus *byte) ([]byte, error)
 }
 
+// This interface is implemented by all MIDI messages that are associated with
+// a channel, e.g. note-on or program-change events.
+type ChannelMessage interface {
+	MIDIMessage
+	GetChannel() uint8
+	SetChannel(c uint8) error
+}
+
 // Holds a sysex-type message. Implements the MIDIMessage interface.
 type SystemExclusiveMessage struct {
 	// Holds all bytes in the message, not including the leading F0 or trailing
@@ -94,6 +118,11 @@ type SystemExclusiveMessage struct {
 }
 
 func (m *SystemExclusiveMessage) String() string {
+	description := m.describe()
+	if description != "" {
+		return fmt.Sprintf("System exclusive message (%s). %d bytes: % x.",
+			description, len(m.DataBytes), m.DataBytes)
+	}
 	return fmt.Sprintf("System exclusive message. %d bytes: % x.",
 		len(m.DataBytes), m.DataBytes)
 }
@@ -118,11 +147,67 @@ func (m *SystemExclusiveMessage) SMFData(runningStatus *byte) ([]byte, error) {
 	return toReturn.Bytes(), nil
 }
 
+// The default limit on the size of a single sysex message's or meta-event's
+// data, in bytes.
+const DefaultMaxEventBytes = 16 * 1024 * 1024
+
+// Holds options that control how SMF data is parsed. Passing a nil
+// *ParseOptions to functions that take one uses the default settings.
+type ParseOptions struct {
+	// The maximum size, in bytes, of the data in a single sysex message or
+	// meta-event. Events declaring larger sizes are rejected before any
+	// memory is allocated for them. If this is 0, DefaultMaxEventBytes is
+	// used instead.
+	MaxEventBytes uint32
+	// If true, the file offset of each event will be recorded in its track's
+	// EventOffsets slice.
+	RecordOffsets bool
+	// If nonzero, at most this many tracks will be parsed, and the rest of
+	// the file will not be read. The track count in the file's header still
+	// limits the number of tracks that are parsed.
+	MaxTracks int
+	// If true, the undefined system common status bytes 0xf4 and 0xf5 will
+	// be parsed as UndefinedSystemEvents, rather than causing an error. Some
+	// proprietary files contain these bytes.
+	AllowUndefinedSystemEvents bool
+}
+
+func (o *ParseOptions) maxEventBytes() uint32 {
+	if (o == nil) || (o.MaxEventBytes == 0) {
+		return DefaultMaxEventBytes
+	}
+	return o.MaxEventBytes
+}
+
+// Allocates a buffer for and reads the given number of bytes of event data
+// from r. Returns an error without allocating the buffer if length exceeds
+// the limit in the options, or if r is an *io.LimitedReader with fewer bytes
+// remaining than length.
+func readEventData(r io.Reader, length uint32, options *ParseOptions) ([]byte,
+	error) {
+	limit := options.maxEventBytes()
+	if length > limit {
+		return nil, fmt.Errorf("Event length of %d bytes exceeds the limit "+
+			"of %d bytes", length, limit)
+	}
+	limitedReader, ok := r.(*io.LimitedReader)
+	if ok && (int64(length) > limitedReader.N) {
+		return nil, fmt.Errorf("Event length of %d bytes exceeds the %d "+
+			"bytes remaining", length, limitedReader.N)
+	}
+	data := make([]byte, length)
+	_, e := io.ReadFull(r, data)
+	if e != nil {
+		return nil, e
+	}
+	return data, nil
+}
+
 // Reads the next system exclusive message from the given input stream. The
 // first byte (F0 or F7) must have already been read, and must be passed in as
 // the firstByte argument.
-func parseSystemExclusiveMessage(r io.Reader, firstByte byte) (MIDIMessage,
-	error) {
+func parseSystemExclusiveMessage(r io.Reader, firstByte byte,
+	options *ParseOptions) (MIDIMessage, error) {
 	length, e := ReadVariableInt(r)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't read SysEx message length: %s", e)
@@ -131,8 +216,7 @@ func parseSystemExclusiveMessage(r io.Reader, firstByte byte) (MIDIMessage,
 		// TODO: Should a 0-length SysEx message actually be an error?
 		return nil, fmt.Errorf("Got a SysEx message with 0 length")
 	}
-	data := make([]byte, length)
-	_, e = r.Read(data)
+	data, e := readEventData(r, length, options)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't read SysEx message data: %s", e)
 	}
@@ -229,7 +313,38 @@ func (t *TextMetaEvent) String() string {
 	default:
 		eventType = fmt.Sprintf("Unknown text event type %d", t.TextEventType)
 	}
-	return fmt.Sprintf("%s: %s", eventType, t.Data)
+	return fmt.Sprintf("%s: %s", eventType, escapeText(t.Data))
+}
+
+// Returns the event's text, without any escaping. This is the same as the
+// Data field, converted to a string, so it may contain invalid UTF-8 or
+// control characters.
+func (t *TextMetaEvent) Text() string {
+	return string(t.Data)
+}
+
+// Returns the given text as a string that is safe to print to a terminal.
+// Printable UTF-8 characters are left unchanged, but backslashes are escaped
+// as \\, and any other bytes (control characters or invalid UTF-8) are
+// escaped as \xNN.
+func escapeText(data []byte) string {
+	var toReturn strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		// DecodeRune returns a size of 1 for invalid UTF-8.
+		valid := (r != utf8.RuneError) || (size > 1)
+		if r == '\\' {
+			toReturn.WriteString("\\\\")
+		} else if valid && unicode.IsPrint(r) {
+			toReturn.Write(data[:size])
+		} else {
+			for _, b := range data[:size] {
+				fmt.Fprintf(&toReturn, "\\x%02x", b)
+			}
+		}
+		data = data[size:]
+	}
+	return toReturn.String()
 }
 
 func (t *TextMetaEvent) SMFData(runningStatus *byte) ([]byte, error) {
@@ -271,6 +386,25 @@ func (t EndOfTrackMetaEvent) SMFData(runningStatus *byte) ([]byte, error) {
 	return formatMetaEventBytes(0x2f, nil)
 }
 
+// Holds the status byte of an undefined system common message (0xf4 or 0xf5).
+// These messages aren't assigned a meaning or any data bytes by the MIDI
+// spec, but occasionally appear in proprietary files. They're only parsed if
+// ParseOptions.AllowUndefinedSystemEvents is set.
+type UndefinedSystemEvent uint8
+
+func (u UndefinedSystemEvent) String() string {
+	return fmt.Sprintf("Undefined system event 0x%02x", uint8(u))
+}
+
+func (u UndefinedSystemEvent) SMFData(runningStatus *byte) ([]byte, error) {
+	if (u != 0xf4) && (u != 0xf5) {
+		return nil, fmt.Errorf("Invalid undefined system event status: "+
+			"0x%02x", uint8(u))
+	}
+	*runningStatus = 0
+	return []byte{byte(u)}, nil
+}
+
 // Holds the 24-bit value for a "set tempo" meta-event. This contains the
 // number of microseconds per quarter note.
 type SetTempoMetaEvent uint32
@@ -305,9 +439,37 @@ func parseSetTempoMetaEvent(data []byte) (MIDIMessage, error) {
 	return SetTempoMetaEvent(toReturn), nil
 }
 
+// Identifies the frame rate used by an SMPTE offset. The values correspond to
+// the bits stored in the top of the SMPTE offset's hours byte.
+type SMPTEFrameRate uint8
+
+const (
+	SMPTE24FPS   SMPTEFrameRate = 0
+	SMPTE25FPS   SMPTEFrameRate = 1
+	SMPTE2997FPS SMPTEFrameRate = 2
+	SMPTE30FPS   SMPTEFrameRate = 3
+)
+
+func (r SMPTEFrameRate) String() string {
+	switch r {
+	case SMPTE24FPS:
+		return "24 fps"
+	case SMPTE25FPS:
+		return "25 fps"
+	case SMPTE2997FPS:
+		return "29.97 fps (drop frame)"
+	case SMPTE30FPS:
+		return "30 fps"
+	}
+	return fmt.Sprintf("Unknown SMPTE frame rate %d", uint8(r))
+}
+
 // Holds an SMPTE offset meta-event's data. I may replace this with a format
 // that's more human-readable in the future.
 type SMPTEOffsetMetaEvent struct {
+	// The frame rate, which is stored in the top bits of the hours byte in
+	// the SMF data.
+	FrameRate        SMPTEFrameRate
 	Hours            uint8
 	Minutes          uint8
 	Seconds          uint8
@@ -319,23 +481,79 @@ func (s *SMPTEOffsetMetaEvent) String() string {
 	// The fractional frames specifies hundredths of a frame.
 	frame := float32(s.Frames)
 	frame += float32(s.FractionalFrames) / 100.0
-	return fmt.Sprintf("SMPTE offset: %d:%d:%d, %f frames", s.Hours, s.Minutes,
-		s.Seconds, frame)
+	return fmt.Sprintf("SMPTE offset: %d:%d:%d, %f frames (%s)", s.Hours,
+		s.Minutes, s.Seconds, frame, s.FrameRate)
 }
 
 func (s *SMPTEOffsetMetaEvent) SMFData(runningStatus *byte) ([]byte, error) {
 	*runningStatus = 0
-	return formatMetaEventBytes(0x54, []byte{s.Hours, s.Minutes, s.Seconds,
+	if s.FrameRate > SMPTE30FPS {
+		return nil, fmt.Errorf("Invalid SMPTE frame rate: %d",
+			uint8(s.FrameRate))
+	}
+	if s.Hours > 0x1f {
+		return nil, fmt.Errorf("Invalid SMPTE offset hours: %d", s.Hours)
+	}
+	hoursByte := (uint8(s.FrameRate) << 5) | s.Hours
+	return formatMetaEventBytes(0x54, []byte{hoursByte, s.Minutes, s.Seconds,
 		s.Frames, s.FractionalFrames})
 }
 
+// Creates a new SMPTE offset meta-event. The fps argument must be 24, 25, 29
+// (for 29.97 fps drop-frame), or 30. Returns an error if any of the values
+// are out of range. The fractional frames (hundredths of a frame) will be
+// zero.
+func NewSMPTEOffset(h, m, s, frames uint8, fps uint8) (*SMPTEOffsetMetaEvent,
+	error) {
+	var rate SMPTEFrameRate
+	// Drop-frame timecode still numbers frames from 0 to 29; it just skips
+	// some frame numbers at the start of most minutes.
+	frameLimit := fps
+	switch fps {
+	case 24:
+		rate = SMPTE24FPS
+	case 25:
+		rate = SMPTE25FPS
+	case 29:
+		rate = SMPTE2997FPS
+		frameLimit = 30
+	case 30:
+		rate = SMPTE30FPS
+	default:
+		return nil, fmt.Errorf("Unsupported SMPTE frame rate: %d", fps)
+	}
+	if h >= 24 {
+		return nil, fmt.Errorf("Invalid SMPTE offset hours: %d", h)
+	}
+	if m >= 60 {
+		return nil, fmt.Errorf("Invalid SMPTE offset minutes: %d", m)
+	}
+	if s >= 60 {
+		return nil, fmt.Errorf("Invalid SMPTE offset seconds: %d", s)
+	}
+	if frames >= frameLimit {
+		return nil, fmt.Errorf("Invalid SMPTE offset frame %d at %d fps",
+			frames, fps)
+	}
+	return &SMPTEOffsetMetaEvent{
+		FrameRate: rate,
+		Hours:     h,
+		Minutes:   m,
+		Seconds:   s,
+		Frames:    frames,
+	}, nil
+}
+
 func parseSMPTEOffsetMetaEvent(data []byte) (MIDIMessage, error) {
 	if len(data) != 5 {
 		return nil, fmt.Errorf("Invalid SMPTE offset meta-event length: %d",
 			len(data))
 	}
+	// The top bit of the hours byte is reserved, the next two bits contain
+	// the frame rate, and the remaining five bits contain the hours.
 	return &SMPTEOffsetMetaEvent{
-		Hours:            data[0],
+		FrameRate:        SMPTEFrameRate((data[0] >> 5) & 3),
+		Hours:            data[0] & 0x1f,
 		Minutes:          data[1],
 		Seconds:          data[2],
 		Frames:           data[3],
@@ -448,10 +666,28 @@ func parseKeySignatureMetaEvent(data []byte) (MIDIMessage, error) {
 	}, nil
 }
 
+// Holds the required data length for a type of meta-event with a fixed size.
+type fixedMetaEventLength struct {
+	name   string
+	length uint32
+}
+
+// Maps each meta-event type with a fixed size to its required data length.
+var fixedMetaEventLengths = map[uint8]fixedMetaEventLength{
+	0x00: {"sequence number", 2},
+	0x20: {"channel prefix", 1},
+	0x2f: {"end-of-track", 0},
+	0x51: {"set tempo", 3},
+	0x54: {"SMPTE offset", 5},
+	0x58: {"time signature", 4},
+	0x59: {"key signature", 2},
+}
+
 // Parses a meta-event message in an SMF file. Returns an error if an unknown
 // meta-event is encountered. Assumes the 0xff byte at the start of the message
 // has already been consumed.
-func parseMetaEvent(r io.Reader) (MIDIMessage, error) {
+func parseMetaEvent(r io.Reader, options *ParseOptions) (MIDIMessage,
+	error) {
 	eventType, e := readByte(r)
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading meta-event type: %s", e)
@@ -460,10 +696,16 @@ func parseMetaEvent(r io.Reader) (MIDIMessage, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading meta-event length: %s", e)
 	}
+	// Check the length of fixed-size events before reading their data.
+	fixedLength, ok := fixedMetaEventLengths[eventType]
+	if ok && (eventLength != fixedLength.length) {
+		return nil, fmt.Errorf("Bad %s meta-event length: expected %d "+
+			"byte(s), got %d", fixedLength.name, fixedLength.length,
+			eventLength)
+	}
 	var eventData []byte
 	if eventLength != 0 {
-		eventData = make([]byte, eventLength)
-		_, e = r.Read(eventData)
+		eventData, e = readEventData(r, eventLength, options)
 		if e != nil {
 			return nil, fmt.Errorf("Failed reading meta-event data: %s", e)
 		}
@@ -475,17 +717,9 @@ func parseMetaEvent(r io.Reader) (MIDIMessage, error) {
 		return parseTextMetaEvent(eventType, eventData)
 	}
 	if eventType == 0x20 {
-		if eventLength != 1 {
-			return nil, fmt.Errorf("Bad channel prefix meta-event length: %d",
-				eventLength)
-		}
 		return ChannelPrefixMetaEvent(eventData[0]), nil
 	}
 	if eventType == 0x2f {
-		if eventLength != 0 {
-			return nil, fmt.Errorf("Bad end-of-track meta-event length: %d",
-				eventLength)
-		}
 		return EndOfTrackMetaEvent(0), nil
 	}
 	if eventType == 0x51 {
@@ -528,8 +762,14 @@ type NoteOffEvent struct {
 }
 
 func (v *NoteOffEvent) String() string {
-	return fmt.Sprintf("Channel %d: %s off, velocity = %d", v.Channel, v.Note,
-		v.Velocity)
+	return fmt.Sprintf("Channel %d: %s off, velocity = %d", v.Channel,
+		v.DescribeNote(), v.Velocity)
+}
+
+// Returns the name of the General MIDI drum sound if this event is on channel
+// 9 (the General MIDI percussion channel), or the note's pitch name otherwise.
+func (v *NoteOffEvent) DescribeNote() string {
+	return describeNote(v.Channel, v.Note)
 }
 
 func (v *NoteOffEvent) SMFData(runningStatus *byte) ([]byte, error) {
@@ -602,8 +842,15 @@ type NoteOnEvent struct {
 }
 
 func (v *NoteOnEvent) String() string {
-	return fmt.Sprintf("Channel %d: %s on, velocity = %d", v.Channel, v.Note,
-		v.Velocity)
+	return fmt.Sprintf("Channel %d: %s on, velocity = %d", v.Channel,
+		v.DescribeNote(), v.Velocity)
+}
+
+// Returns the name of the General MIDI drum sound if this event is on channel
+// 9 (the General MIDI percussion channel), for example "Acoustic Snare" for
+// note 38. Returns the note's pitch name otherwise.
+func (v *NoteOnEvent) DescribeNote() string {
+	return describeNote(v.Channel, v.Note)
 }
 
 func (v *NoteOnEvent) SMFData(runningStatus *byte) ([]byte, error) {
@@ -777,6 +1024,11 @@ func (v *ControlChangeEvent) String() string {
 	case 127:
 		return c + fmt.Sprintf("Poly mode on (v = %d)", v.Value)
 	}
+	name, ok := gm.ControllerName(v.ControllerNumber)
+	if ok {
+		return c + fmt.Sprintf("Control change, controller number %d (%s), "+
+			"value %d", v.ControllerNumber, name, v.Value)
+	}
 	return c + fmt.Sprintf("Control change, controller number %d, value %d",
 		v.ControllerNumber, v.Value)
 }
@@ -1032,15 +1284,26 @@ func parsePitchBendEvent(r io.Reader, firstByte, channel uint8) (MIDIMessage,
 	}, nil
 }
 
+// Returns the number of data bytes following the given channel message status
+// byte: 1 for program change and channel pressure messages, and 2 for the
+// other channel messages. Returns 0 if the status isn't a channel message
+// status byte.
+func channelMessageDataLength(status byte) int {
+	switch status & 0xf0 {
+	case 0x80, 0x90, 0xa0, 0xb0, 0xe0:
+		return 2
+	case 0xc0, 0xd0:
+		return 1
+	}
+	return 0
+}
+
 func parseChannelMessage(r io.Reader, firstByte byte, runningStatus *byte) (
 	MIDIMessage, error) {
 	status := firstByte
 	// Use the running status if the first byte is not a status byte.
 	if (status & 0x80) == 0 {
 		status = *runningStatus
-	} else {
-		// We got a new status byte, so update the running status.
-		*runningStatus = status
 	}
 	// If "status" is not a status byte, then neither the first byte nor the
 	// running status indicated a valid status.
@@ -1048,6 +1311,14 @@ func parseChannelMessage(r io.Reader, firstByte byte, runningStatus *byte) (
 		return nil, fmt.Errorf("Can't parse a channel message without a " +
 			"valid status or running status")
 	}
+	// Guard against system status bytes here, so they're never used as the
+	// running status and never cause data bytes to be misinterpreted.
+	if channelMessageDataLength(status) == 0 {
+		return nil, fmt.Errorf("Status byte 0x%02x isn't a channel message",
+			status)
+	}
+	// We got a valid new status byte, so update the running status.
+	*runningStatus = status
 	channel := status & 0xf
 	switch status & 0xf0 {
 	case 0x80:
@@ -1065,13 +1336,21 @@ func parseChannelMessage(r io.Reader, firstByte byte, runningStatus *byte) (
 	case 0xe0:
 		return parsePitchBendEvent(r, firstByte, channel)
 	}
-	return nil, fmt.Errorf("Parsing MIDI channel message not yet implemented.")
+	// This is unreachable, since the status was checked above.
+	return nil, fmt.Errorf("Unhandled channel message status 0x%02x", status)
 }
 
 // Parses and returns the MIDI message at the start of r. Requires a running
 // status byte that may be modified by calling this function. If a running
 // status is not set, then runningStatus must be zero.
 func ReadSMFMessage(r io.Reader, runningStatus *byte) (MIDIMessage, error) {
+	return ReadSMFMessageWithOptions(r, runningStatus, nil)
+}
+
+// Like ReadSMFMessage, but uses the given parsing options. The options may be
+// nil, in which case the defaults are used.
+func ReadSMFMessageWithOptions(r io.Reader, runningStatus *byte,
+	options *ParseOptions) (MIDIMessage, error) {
 	firstByte, e := readByte(r)
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading start of MIDI message: %s", e)
@@ -1079,12 +1358,18 @@ func ReadSMFMessage(r io.Reader, runningStatus *byte) (MIDIMessage, error) {
 	if (firstByte == 0xf0) || (firstByte == 0xf7) {
 		// Sysex messages reset running status.
 		*runningStatus = 0
-		return parseSystemExclusiveMessage(r, firstByte)
+		return parseSystemExclusiveMessage(r, firstByte, options)
 	}
 	if firstByte == 0xff {
 		// Meta-events also reset running status.
 		*runningStatus = 0
-		return parseMetaEvent(r)
+		return parseMetaEvent(r, options)
+	}
+	if ((firstByte == 0xf4) || (firstByte == 0xf5)) && (options != nil) &&
+		options.AllowUndefinedSystemEvents {
+		// System common messages cancel running status.
+		*runningStatus = 0
+		return UndefinedSystemEvent(firstByte), nil
 	}
 	if (firstByte & 0xf0) == 0xf0 {
 		// TODO: Eventually support the remaining messages here, e.g. more
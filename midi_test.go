@@ -137,3 +137,240 @@ func TestVariableIntWrite(t *testing.T) {
 	}
 	t.Logf("Got expected error when writing int that's too big: %s\n", e)
 }
+
+func TestSMPTEOffsetFrameRate(t *testing.T) {
+	// An SMPTE offset of 1:02:03, frame 4.05, at 30 fps. The hours byte has
+	// the frame rate (3) in bits 5 and 6.
+	data := []byte{0xff, 0x54, 5, 0x61, 2, 3, 4, 5}
+	runningStatus := byte(0)
+	m, e := ReadSMFMessage(bytes.NewReader(data), &runningStatus)
+	if e != nil {
+		t.Logf("Failed parsing SMPTE offset: %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Parsed SMPTE offset: %s\n", m)
+	offset, ok := m.(*SMPTEOffsetMetaEvent)
+	if !ok {
+		t.Logf("Expected an SMPTE offset, got %s\n", m)
+		t.FailNow()
+	}
+	if offset.FrameRate != SMPTE30FPS {
+		t.Logf("Expected a 30 fps frame rate, got %s\n", offset.FrameRate)
+		t.FailNow()
+	}
+	if offset.Hours != 1 {
+		t.Logf("Expected 1 hour, got %d\n", offset.Hours)
+		t.FailNow()
+	}
+	output, e := offset.SMFData(&runningStatus)
+	if e != nil {
+		t.Logf("Failed getting SMPTE offset data: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(output, data) {
+		t.Logf("Re-encoded SMPTE offset didn't match: got % x, expected "+
+			"% x\n", output, data)
+		t.FailNow()
+	}
+	created, e := NewSMPTEOffset(1, 2, 3, 4, 30)
+	if e != nil {
+		t.Logf("Failed creating SMPTE offset: %s\n", e)
+		t.FailNow()
+	}
+	if (created.FrameRate != SMPTE30FPS) || (created.Hours != 1) {
+		t.Logf("Created incorrect SMPTE offset: %s\n", created)
+		t.FailNow()
+	}
+	_, e = NewSMPTEOffset(1, 2, 3, 25, 25)
+	if e == nil {
+		t.Logf("Didn't get expected error for an out-of-range frame\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for out-of-range frame: %s\n", e)
+	// 29.97 fps drop-frame timecode still uses frame numbers up to 29.
+	created, e = NewSMPTEOffset(1, 2, 3, 29, 29)
+	if e != nil {
+		t.Logf("Failed creating SMPTE offset at frame 29 of 29.97 fps: %s\n",
+			e)
+		t.FailNow()
+	}
+	if created.FrameRate != SMPTE2997FPS {
+		t.Logf("Expected a 29.97 fps frame rate, got %s\n", created.FrameRate)
+		t.FailNow()
+	}
+	_, e = NewSMPTEOffset(1, 2, 3, 30, 29)
+	if e == nil {
+		t.Logf("Didn't get an error for frame 30 at 29.97 fps\n")
+		t.FailNow()
+	}
+}
+
+func TestMetaEventLengthValidation(t *testing.T) {
+	// A set tempo event with a 4-byte length, followed by a valid one.
+	data := []byte{0xff, 0x51, 0x04, 0x07, 0xa1, 0x20, 0x00}
+	runningStatus := byte(0)
+	_, e := ReadSMFMessage(bytes.NewReader(data), &runningStatus)
+	if e == nil {
+		t.Logf("Didn't get an error for a bad set tempo length\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for a bad set tempo length: %s\n", e)
+	data = []byte{0xff, 0x51, 0x03, 0x07, 0xa1, 0x20}
+	m, e := ReadSMFMessage(bytes.NewReader(data), &runningStatus)
+	if e != nil {
+		t.Logf("Failed parsing a valid set tempo event: %s\n", e)
+		t.FailNow()
+	}
+	if m.(SetTempoMetaEvent) != 500000 {
+		t.Logf("Got incorrect tempo: %s\n", m)
+		t.FailNow()
+	}
+}
+
+func TestTextEventEscaping(t *testing.T) {
+	event := &TextMetaEvent{
+		TextEventType: 1,
+		Data:          []byte("a\x1b[2J\\ é\xff"),
+	}
+	expected := "Generic text event: a\\x1b[2J\\\\ é\\xff"
+	if event.String() != expected {
+		t.Logf("Expected %q, got %q\n", expected, event.String())
+		t.FailNow()
+	}
+	if event.Text() != string(event.Data) {
+		t.Logf("Text() didn't return the original data\n")
+		t.FailNow()
+	}
+}
+
+func TestUndefinedSystemEvent(t *testing.T) {
+	data := []byte{0xf4}
+	runningStatus := byte(0x90)
+	_, e := ReadSMFMessage(bytes.NewReader(data), &runningStatus)
+	if e == nil {
+		t.Logf("Didn't get an error for an undefined status in strict mode\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an undefined status: %s\n", e)
+	options := &ParseOptions{
+		AllowUndefinedSystemEvents: true,
+	}
+	m, e := ReadSMFMessageWithOptions(bytes.NewReader(data), &runningStatus,
+		options)
+	if e != nil {
+		t.Logf("Failed parsing an undefined status in lenient mode: %s\n", e)
+		t.FailNow()
+	}
+	if m != UndefinedSystemEvent(0xf4) {
+		t.Logf("Got incorrect message: %s\n", m)
+		t.FailNow()
+	}
+	if runningStatus != 0 {
+		t.Logf("The undefined status didn't cancel running status\n")
+		t.FailNow()
+	}
+	written, e := m.SMFData(&runningStatus)
+	if e != nil {
+		t.Logf("Failed writing the undefined system event: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(written, data) {
+		t.Logf("Wrote incorrect data: % x\n", written)
+		t.FailNow()
+	}
+	// Other undefined or unsupported status bytes should still be errors.
+	_, e = ReadSMFMessageWithOptions(bytes.NewReader([]byte{0xf9}),
+		&runningStatus, options)
+	if e == nil {
+		t.Logf("Didn't get an error for status 0xf9\n")
+		t.FailNow()
+	}
+}
+
+func TestChannelMessageDataLength(t *testing.T) {
+	// Program change and channel pressure take one data byte, so running
+	// status must not consume the following byte.
+	data := []byte{0xc3, 0x05, 0x06, 0xd3, 0x40, 0x41}
+	r := bytes.NewReader(data)
+	runningStatus := byte(0)
+	expected := []string{
+		(&ProgramChangeEvent{Channel: 3, Value: 5}).String(),
+		(&ProgramChangeEvent{Channel: 3, Value: 6}).String(),
+		(&ChannelPressureEvent{Channel: 3, Value: 0x40}).String(),
+		(&ChannelPressureEvent{Channel: 3, Value: 0x41}).String(),
+	}
+	for i, s := range expected {
+		m, e := ReadSMFMessage(r, &runningStatus)
+		if e != nil {
+			t.Logf("Failed reading message %d: %s\n", i, e)
+			t.FailNow()
+		}
+		if m.String() != s {
+			t.Logf("Expected message %d to be %s, got %s\n", i, s, m)
+			t.FailNow()
+		}
+	}
+	// A system status byte must never be used as the running status.
+	runningStatus = 0xf8
+	_, e := ReadSMFMessage(bytes.NewReader([]byte{0x10, 0x20}),
+		&runningStatus)
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid running status\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an invalid running status: %s\n", e)
+	if runningStatus != 0xf8 {
+		t.Logf("The invalid status changed the running status to 0x%02x\n",
+			runningStatus)
+		t.FailNow()
+	}
+	if (channelMessageDataLength(0xe0) != 2) ||
+		(channelMessageDataLength(0xf0) != 0) {
+		t.Logf("Got incorrect channel message data lengths\n")
+		t.FailNow()
+	}
+}
+
+func TestMaxEventBytes(t *testing.T) {
+	// A sysex message with 9 data bytes, followed by the 0xf7 terminator, and
+	// a text meta-event with 10 bytes of text.
+	sysex := []byte{0xf0, 10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0xf7}
+	text := append([]byte{0xff, 0x01, 10}, []byte("0123456789")...)
+	for _, data := range [][]byte{sysex, text} {
+		var runningStatus byte
+		_, e := ReadSMFMessageWithOptions(bytes.NewReader(data),
+			&runningStatus, &ParseOptions{MaxEventBytes: 10})
+		if e != nil {
+			t.Logf("Failed parsing event within the size limit: %s\n", e)
+			t.FailNow()
+		}
+		_, e = ReadSMFMessageWithOptions(bytes.NewReader(data),
+			&runningStatus, nil)
+		if e != nil {
+			t.Logf("Failed parsing event with the default limit: %s\n", e)
+			t.FailNow()
+		}
+		_, e = ReadSMFMessageWithOptions(bytes.NewReader(data),
+			&runningStatus, &ParseOptions{MaxEventBytes: 9})
+		if e == nil {
+			t.Logf("Didn't get an error for an event exceeding the size "+
+				"limit: % x\n", data)
+			t.FailNow()
+		}
+		t.Logf("Got expected error for an oversized event: %s\n", e)
+	}
+}
+
+func TestDescribeNote(t *testing.T) {
+	snare := &NoteOnEvent{Channel: 9, Note: 38, Velocity: 100}
+	if snare.DescribeNote() != "Acoustic Snare" {
+		t.Logf("Got incorrect drum description: %s\n", snare.DescribeNote())
+		t.FailNow()
+	}
+	// Only the General MIDI percussion channel is described using drum names.
+	note := &NoteOffEvent{Channel: 3, Note: 38}
+	if note.DescribeNote() != MIDINote(38).String() {
+		t.Logf("Got incorrect note description: %s\n", note.DescribeNote())
+		t.FailNow()
+	}
+}
@@ -0,0 +1,224 @@
+package midi
+
+// This file contains code for working with notes as single units with a start
+// time and duration, rather than as separate note-on and note-off events.
+
+import (
+	"sort"
+)
+
+// Holds a single note, combining a note-on event with the event that ends it.
+type Note struct {
+	Channel  uint8
+	Pitch    MIDINote
+	Velocity uint8
+	// The absolute time at which the note starts, in ticks.
+	Tick uint32
+	// The length of the note, in ticks.
+	Duration uint32
+}
+
+// Returns every note in the file, from all tracks, sorted by start time.
+// Simultaneous notes are ordered by track, and then by their order within the
+// track. Notes that are never ended are considered to last until the final
+// event in their track.
+func (f *SMFFile) Notes() []Note {
+	var toReturn []Note
+	for _, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		if len(ticks) == 0 {
+			continue
+		}
+		trackEnd := ticks[len(ticks)-1]
+		for _, p := range t.pairNotes() {
+			noteOn := t.Messages[p.onIndex].(*NoteOnEvent)
+			end := trackEnd
+			if p.offIndex >= 0 {
+				end = ticks[p.offIndex]
+			}
+			toReturn = append(toReturn, Note{
+				Channel:  noteOn.Channel,
+				Pitch:    noteOn.Note,
+				Velocity: noteOn.Velocity,
+				Tick:     ticks[p.onIndex],
+				Duration: end - ticks[p.onIndex],
+			})
+		}
+	}
+	sort.SliceStable(toReturn, func(a, b int) bool {
+		return toReturn[a].Tick < toReturn[b].Tick
+	})
+	return toReturn
+}
+
+// Reduces the notes on the given channel (or on all channels other than the
+// percussion channels reported by PercussionChannels, if channel is -1) to a
+// monophonic line. At every point in time, only the sounding note for which
+// prefer returns true when compared against every other sounding note is kept.
+// If a note is interrupted by a preferred note and is still sounding after the
+// preferred note ends, the remainder of the interrupted note is included as a
+// separate note.
+func (f *SMFFile) monophonicLine(channel int,
+	prefer func(a, b *Note) bool) []Note {
+	var notes []Note
+	percussion := f.PercussionChannels()
+	for _, n := range f.Notes() {
+		if n.Duration == 0 {
+			continue
+		}
+		if channel < 0 {
+			if percussion[n.Channel&0xf] {
+				continue
+			}
+		} else if int(n.Channel) != channel {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	// Every time a note starts or ends is a point where the line may change.
+	var boundaries []uint32
+	for _, n := range notes {
+		boundaries = append(boundaries, n.Tick, n.Tick+n.Duration)
+	}
+	sort.Slice(boundaries, func(a, b int) bool {
+		return boundaries[a] < boundaries[b]
+	})
+	var toReturn []Note
+	var active []int
+	nextNote := 0
+	previous := -1
+	for i, tick := range boundaries {
+		if ((i + 1) >= len(boundaries)) || (boundaries[i+1] == tick) {
+			continue
+		}
+		for (nextNote < len(notes)) && (notes[nextNote].Tick <= tick) {
+			active = append(active, nextNote)
+			nextNote++
+		}
+		// Remove ended notes and find the preferred one among the rest.
+		best := -1
+		remaining := active[:0]
+		for _, index := range active {
+			n := &(notes[index])
+			if (n.Tick + n.Duration) <= tick {
+				continue
+			}
+			remaining = append(remaining, index)
+			if (best < 0) || prefer(n, &(notes[best])) {
+				best = index
+			}
+		}
+		active = remaining
+		if best < 0 {
+			previous = -1
+			continue
+		}
+		segmentEnd := boundaries[i+1]
+		if best == previous {
+			last := &(toReturn[len(toReturn)-1])
+			last.Duration = segmentEnd - last.Tick
+			continue
+		}
+		n := notes[best]
+		n.Tick = tick
+		n.Duration = segmentEnd - tick
+		toReturn = append(toReturn, n)
+		previous = best
+	}
+	return toReturn
+}
+
+// Extracts a melody from the notes on the given channel, or from all channels
+// other than percussion channels if channel is -1, using the "skyline"
+// algorithm: at every point in time, only the highest sounding note is kept.
+// Returns the resulting monophonic line, sorted by time. If a note is
+// interrupted by a higher note, but continues sounding after the higher note
+// ends, the remainder is included as a separate note.
+func (f *SMFFile) ExtractMelody(channel int) []Note {
+	return f.monophonicLine(channel, func(a, b *Note) bool {
+		return a.Pitch > b.Pitch
+	})
+}
+
+// Extracts a bass line from the notes on the given channel, or from all
+// channels other than percussion channels if channel is -1. This is the
+// same as ExtractMelody, except that the lowest sounding note is kept at every
+// point in time, rather than the highest.
+func (f *SMFFile) ExtractBassLine(channel int) []Note {
+	return f.monophonicLine(channel, func(a, b *Note) bool {
+		return a.Pitch < b.Pitch
+	})
+}
+
+// Returns the time of the final event in the file, across all tracks.
+func (f *SMFFile) lastEventTick() uint32 {
+	toReturn := uint32(0)
+	for _, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		if (len(ticks) != 0) && (ticks[len(ticks)-1] > toReturn) {
+			toReturn = ticks[len(ticks)-1]
+		}
+	}
+	return toReturn
+}
+
+// Returns a grid showing which channels are playing each pitch over time.
+// Each row covers timeStepTicks ticks, starting from tick 0, and there are
+// enough rows to include the final event in the file. Bit c of the value in
+// row i and column p is set if a note with pitch p is sounding on channel c
+// at any time during row i. Notes with a length of zero are considered to be
+// sounding during the row in which they start. Returns nil if timeStepTicks
+// is 0 or the file contains no events. Returns an error if more than
+// MaxTimeWindows rows would be needed; use a larger time step in that case.
+func (f *SMFFile) ChannelPianoRoll(timeStepTicks uint32) ([][128]uint16,
+	error) {
+	if timeStepTicks == 0 {
+		return nil, nil
+	}
+	hasEvents := false
+	for _, t := range f.Tracks {
+		if len(t.Messages) != 0 {
+			hasEvents = true
+			break
+		}
+	}
+	if !hasEvents {
+		return nil, nil
+	}
+	count, e := timeWindowCount(f.lastEventTick(), timeStepTicks)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := make([][128]uint16, count)
+	for _, n := range f.Notes() {
+		first := n.Tick / timeStepTicks
+		last := first
+		if n.Duration != 0 {
+			// The row containing the note's final tick.
+			last = (n.Tick + n.Duration - 1) / timeStepTicks
+		}
+		for row := first; row <= last; row++ {
+			toReturn[row][n.Pitch&0x7f] |= 1 << (n.Channel & 0xf)
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns a grid showing which pitches are sounding over time, on any
+// channel. Each row covers timeStepTicks ticks, and the value in row i and
+// column p is true if a note with pitch p is sounding at any time during row
+// i. See ChannelPianoRoll for more details, and for a version of this that
+// distinguishes between channels.
+func (f *SMFFile) PianoRoll(timeStepTicks uint32) ([][128]bool, error) {
+	channelRoll, e := f.ChannelPianoRoll(timeStepTicks)
+	if (e != nil) || (channelRoll == nil) {
+		return nil, e
+	}
+	toReturn := make([][128]bool, len(channelRoll))
+	for i, row := range channelRoll {
+		for pitch, channels := range row {
+			toReturn[i][pitch] = channels != 0
+		}
+	}
+	return toReturn, nil
+}
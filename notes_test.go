@@ -0,0 +1,128 @@
+package midi
+
+import (
+	"testing"
+)
+
+// Returns a file containing a single track with a sustained low note on
+// channel 0, interrupted by a higher note on channel 0 and a percussion note.
+func getMelodyTestFile() *SMFFile {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 48, Velocity: 80},
+			&NoteOnEvent{Channel: 0, Note: 72, Velocity: 80},
+			&NoteOnEvent{Channel: 9, Note: 81, Velocity: 80},
+			&NoteOffEvent{Channel: 0, Note: 72},
+			&NoteOffEvent{Channel: 9, Note: 81},
+			&NoteOffEvent{Channel: 0, Note: 48},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 0, 10, 0, 10, 0},
+	}
+	return &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+}
+
+// Returns an error message if the notes don't have the given pitches, start
+// times, and durations, or an empty string if they do.
+func checkNotes(notes []Note, pitches []MIDINote, ticks,
+	durations []uint32) string {
+	if len(notes) != len(pitches) {
+		return "Got the wrong number of notes"
+	}
+	for i, n := range notes {
+		if (n.Pitch != pitches[i]) || (n.Tick != ticks[i]) ||
+			(n.Duration != durations[i]) {
+			return "Got an incorrect note"
+		}
+	}
+	return ""
+}
+
+func TestExtractMelody(t *testing.T) {
+	smf := getMelodyTestFile()
+	melody := smf.ExtractMelody(-1)
+	// The percussion note should be ignored, and the low note should resume
+	// after the high note ends.
+	message := checkNotes(melody, []MIDINote{48, 72, 48},
+		[]uint32{0, 10, 20}, []uint32{10, 10, 10})
+	if message != "" {
+		t.Logf("%s\n", message)
+		t.FailNow()
+	}
+	melody = smf.ExtractMelody(9)
+	message = checkNotes(melody, []MIDINote{81}, []uint32{10},
+		[]uint32{10})
+	if message != "" {
+		t.Logf("%s for channel 9\n", message)
+		t.FailNow()
+	}
+}
+
+func TestExtractBassLine(t *testing.T) {
+	smf := getMelodyTestFile()
+	bass := smf.ExtractBassLine(0)
+	// The low note sounds throughout, so it should be the only note.
+	message := checkNotes(bass, []MIDINote{48}, []uint32{0}, []uint32{30})
+	if message != "" {
+		t.Logf("%s\n", message)
+		t.FailNow()
+	}
+}
+
+func TestPianoRoll(t *testing.T) {
+	smf := getMelodyTestFile()
+	roll, e := smf.ChannelPianoRoll(10)
+	if e != nil {
+		t.Logf("Failed getting the channel piano roll: %s\n", e)
+		t.FailNow()
+	}
+	if len(roll) != 4 {
+		t.Logf("Expected 4 rows, got %d\n", len(roll))
+		t.FailNow()
+	}
+	if (roll[1][48] != 1) || (roll[1][72] != 1) || (roll[1][81] != 1<<9) {
+		t.Logf("Got incorrect channels in row 1\n")
+		t.FailNow()
+	}
+	if (roll[2][48] != 1) || (roll[2][72] != 0) {
+		t.Logf("Got incorrect channels in row 2\n")
+		t.FailNow()
+	}
+	boolRoll, e := smf.PianoRoll(10)
+	if e != nil {
+		t.Logf("Failed getting the piano roll: %s\n", e)
+		t.FailNow()
+	}
+	for i, row := range boolRoll {
+		count := 0
+		for _, sounding := range row {
+			if sounding {
+				count++
+			}
+		}
+		expected := []int{1, 3, 1, 0}[i]
+		if count != expected {
+			t.Logf("Expected %d notes in row %d, got %d\n", expected, i,
+				count)
+			t.FailNow()
+		}
+	}
+
+	// A single event with a huge time delta shouldn't cause a huge
+	// allocation.
+	smf.Tracks[0].TimeDeltas[6] = 0xffffff00
+	_, e = smf.ChannelPianoRoll(1)
+	if e == nil {
+		t.Logf("Didn't get an error for too many rows\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for too many rows: %s\n", e)
+	_, e = smf.PianoRoll(1)
+	if e == nil {
+		t.Logf("Didn't get an error for too many piano roll rows\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,217 @@
+package midi
+
+// This file contains a chainable API for selecting events from an SMF file.
+
+// Identifies a category of MIDI message, for use with Query.OfType.
+type EventKind int
+
+const (
+	KindNoteOff EventKind = iota
+	KindNoteOn
+	KindAftertouch
+	KindControlChange
+	KindProgramChange
+	KindChannelPressure
+	KindPitchBend
+	KindSystemExclusive
+	KindSequenceNumber
+	KindText
+	KindChannelPrefix
+	KindEndOfTrack
+	KindTempo
+	KindSMPTEOffset
+	KindTimeSignature
+	KindKeySignature
+	// Any meta-event not covered by one of the other kinds.
+	KindOtherMeta
+	// Any message not covered by one of the other kinds.
+	KindUnknown
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case KindNoteOff:
+		return "note off"
+	case KindNoteOn:
+		return "note on"
+	case KindAftertouch:
+		return "aftertouch"
+	case KindControlChange:
+		return "control change"
+	case KindProgramChange:
+		return "program change"
+	case KindChannelPressure:
+		return "channel pressure"
+	case KindPitchBend:
+		return "pitch bend"
+	case KindSystemExclusive:
+		return "system exclusive"
+	case KindSequenceNumber:
+		return "sequence number"
+	case KindText:
+		return "text"
+	case KindChannelPrefix:
+		return "channel prefix"
+	case KindEndOfTrack:
+		return "end of track"
+	case KindTempo:
+		return "tempo"
+	case KindSMPTEOffset:
+		return "SMPTE offset"
+	case KindTimeSignature:
+		return "time signature"
+	case KindKeySignature:
+		return "key signature"
+	case KindOtherMeta:
+		return "other meta-event"
+	}
+	return "unknown"
+}
+
+// Returns the kind of the given message. Note-on events with a velocity of 0
+// are considered to be note-offs, since that's how they're interpreted.
+func KindOf(m MIDIMessage) EventKind {
+	if isNoteOn(m) {
+		return KindNoteOn
+	}
+	_, _, isOff := noteOffInfo(m)
+	if isOff {
+		return KindNoteOff
+	}
+	switch m.(type) {
+	case *AftertouchEvent:
+		return KindAftertouch
+	case *ControlChangeEvent:
+		return KindControlChange
+	case *ProgramChangeEvent:
+		return KindProgramChange
+	case *ChannelPressureEvent:
+		return KindChannelPressure
+	case *PitchBendEvent:
+		return KindPitchBend
+	case *SystemExclusiveMessage:
+		return KindSystemExclusive
+	case SequenceNumberMetaEvent:
+		return KindSequenceNumber
+	case *TextMetaEvent:
+		return KindText
+	case ChannelPrefixMetaEvent:
+		return KindChannelPrefix
+	case EndOfTrackMetaEvent:
+		return KindEndOfTrack
+	case SetTempoMetaEvent:
+		return KindTempo
+	case *SMPTEOffsetMetaEvent:
+		return KindSMPTEOffset
+	case *TimeSignatureMetaEvent:
+		return KindTimeSignature
+	case *KeySignatureMetaEvent:
+		return KindKeySignature
+	case *GenericMetaEvent:
+		return KindOtherMeta
+	}
+	return KindUnknown
+}
+
+// Selects events from an SMF file, using a chain of filters. Obtain one using
+// SMFFile.Query(). Each filtering method returns a new Query, leaving the
+// original unchanged, so a partially-built query can be reused. No events are
+// examined until Collect or Count is called.
+type Query struct {
+	file    *SMFFile
+	filters []eventFilter
+}
+
+// Returns true if an event should be selected by a Query.
+type eventFilter func(e *TimedEvent) bool
+
+// Returns a Query that initially selects every event in the file.
+func (f *SMFFile) Query() *Query {
+	return &Query{
+		file: f,
+	}
+}
+
+// Returns a copy of the query with the given filter added.
+func (q *Query) with(filter eventFilter) *Query {
+	filters := make([]eventFilter, len(q.filters), len(q.filters)+1)
+	copy(filters, q.filters)
+	return &Query{
+		file:    q.file,
+		filters: append(filters, filter),
+	}
+}
+
+// Only selects events for which the given function returns true.
+func (q *Query) Where(filter func(e *TimedEvent) bool) *Query {
+	return q.with(filter)
+}
+
+// Only selects channel messages on the given channel.
+func (q *Query) OnChannel(channel uint8) *Query {
+	return q.with(func(e *TimedEvent) bool {
+		m, ok := e.Message.(ChannelMessage)
+		return ok && (m.GetChannel() == channel)
+	})
+}
+
+// Only selects events of one of the given kinds.
+func (q *Query) OfType(kinds ...EventKind) *Query {
+	return q.with(func(e *TimedEvent) bool {
+		kind := KindOf(e.Message)
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Only selects events occurring at or after the start tick, and before the
+// end tick.
+func (q *Query) InTickRange(start, end uint32) *Query {
+	return q.with(func(e *TimedEvent) bool {
+		return (e.Tick >= start) && (e.Tick < end)
+	})
+}
+
+// Only selects events in the track with the given index.
+func (q *Query) InTrack(track int) *Query {
+	return q.with(func(e *TimedEvent) bool {
+		return e.Track == track
+	})
+}
+
+// Returns true if the event passes all of the query's filters.
+func (q *Query) matches(e *TimedEvent) bool {
+	for _, filter := range q.filters {
+		if !filter(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns every event selected by the query, sorted by time in the same order
+// as SMFFile.TimedEvents.
+func (q *Query) Collect() []TimedEvent {
+	var toReturn []TimedEvent
+	for _, e := range q.file.TimedEvents() {
+		if q.matches(&e) {
+			toReturn = append(toReturn, e)
+		}
+	}
+	return toReturn
+}
+
+// Returns the number of events selected by the query.
+func (q *Query) Count() int {
+	toReturn := 0
+	for _, e := range q.file.TimedEvents() {
+		if q.matches(&e) {
+			toReturn++
+		}
+	}
+	return toReturn
+}
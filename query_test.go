@@ -0,0 +1,55 @@
+package midi
+
+import (
+	"os"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	f, e := os.Open("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed opening test file: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	smf, e := ParseSMFFile(f)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	base := smf.Query().OfType(KindNoteOn)
+	selected := base.OnChannel(4).InTickRange(0, 100).Collect()
+	for _, event := range selected {
+		if (event.Tick >= 100) || !isNoteOn(event.Message) ||
+			(event.Message.(*NoteOnEvent).Channel != 4) {
+			t.Logf("The query selected an incorrect event\n")
+			t.FailNow()
+		}
+	}
+	if len(selected) == 0 {
+		t.Logf("The query didn't select any events\n")
+		t.FailNow()
+	}
+	// Make sure adding filters didn't modify the base query.
+	expected := 0
+	for _, event := range smf.TimedEvents() {
+		if isNoteOn(event.Message) {
+			expected++
+		}
+	}
+	if base.Count() != expected {
+		t.Logf("Expected %d note-ons, got %d\n", expected, base.Count())
+		t.FailNow()
+	}
+}
+
+func TestEventKindString(t *testing.T) {
+	if KindOf(SetTempoMetaEvent(500000)).String() != "tempo" {
+		t.Logf("Got incorrect name for a tempo event's kind\n")
+		t.FailNow()
+	}
+	if EventKind(-1).String() != "unknown" {
+		t.Logf("Got incorrect name for an invalid kind\n")
+		t.FailNow()
+	}
+}
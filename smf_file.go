@@ -4,9 +4,12 @@ package midi
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 )
 
 // This corresponds to the division field of the MThd chunk.
@@ -78,29 +81,185 @@ type SMFTrack struct {
 	// The time deltas for each MIDI message. Has the same length as the
 	// Messages slice; TimeDeltas[i] is the time delta for Messages[i].
 	TimeDeltas []uint32
+	// If the track was parsed with the RecordOffsets option, this contains
+	// the offset in the file of the start of each event (i.e. its time
+	// delta). Otherwise this will be nil. The offsets aren't updated when
+	// the track is modified, and are ignored when writing the track.
+	EventOffsets []int64
 }
 
-// Writes the given track to the given output file.
-func (t *SMFTrack) WriteToFile(file io.Writer) error {
+// Holds the settings that can be changed using WriteOptions.
+type writeSettings struct {
+	disableRunningStatus bool
+	addEndOfTrack        bool
+	omitEmptyTracks      bool
+	keepConductorTrack   bool
+	noteOffsAsNoteOns    bool
+	// If negative, the format is chosen based on the number of tracks.
+	format int
+}
+
+// Changes a setting used when writing an SMF file or track. Pass these to
+// SMFFile.WriteToFile or SMFTrack.WriteToFile.
+type WriteOption func(s *writeSettings)
+
+// Causes every event to be written with its status byte, rather than omitting
+// repeated status bytes using running status.
+func DisableRunningStatus() WriteOption {
+	return func(s *writeSettings) {
+		s.disableRunningStatus = true
+	}
+}
+
+// Causes an end-of-track event to be written at the end of any track that
+// doesn't already end with one. The tracks themselves aren't modified.
+func AddEndOfTrack() WriteOption {
+	return func(s *writeSettings) {
+		s.addEndOfTrack = true
+	}
+}
+
+// Causes tracks that would be deleted by SMFFile.RemoveEmptyTracks to be left
+// out of the written file. The SMFFile itself isn't modified. Has no effect
+// when writing a single track.
+func OmitEmptyTracks(keepConductor bool) WriteOption {
+	return func(s *writeSettings) {
+		s.omitEmptyTracks = true
+		s.keepConductorTrack = keepConductor
+	}
+}
+
+// Causes every note-off event to be written as a note-on event with a
+// velocity of 0, which many files use since it allows running status to be
+// used for consecutive note-ons and note-offs. This discards the release
+// velocity of each note-off, which is otherwise preserved.
+func NoteOffsAsNoteOns() WriteOption {
+	return func(s *writeSettings) {
+		s.noteOffsAsNoteOns = true
+	}
+}
+
+// Causes the file to be written with the given format in its header, rather
+// than the file's Format, or format 0 or 1 if the Format doesn't fit the number
+// of tracks. Writing will fail if the format isn't 0, 1, or 2, or if it's 0
+// and the file doesn't contain exactly one track. Has no effect when writing a
+// single track.
+func ForceFormat(format uint16) WriteOption {
+	return func(s *writeSettings) {
+		s.format = int(format)
+	}
+}
+
+// Returns the settings resulting from applying the given options to the
+// defaults.
+func getWriteSettings(options []WriteOption) *writeSettings {
+	toReturn := &writeSettings{
+		format: -1,
+	}
+	for _, option := range options {
+		option(toReturn)
+	}
+	return toReturn
+}
+
+// Returned when writing a track fails due to a single event that can't be
+// encoded, such as a channel message with an invalid channel number.
+type EventError struct {
+	// The index of the track containing the event, or -1 if a single track
+	// was being written using SMFTrack.WriteToFile.
+	Track int
+	// The index of the event within its track.
+	Index   int
+	Message MIDIMessage
+	Err     error
+}
+
+func (e *EventError) Error() string {
+	if e.Track < 0 {
+		return fmt.Sprintf("Bad event %d (%s): %s", e.Index, e.Message, e.Err)
+	}
+	return fmt.Sprintf("Bad event %d in track %d (%s): %s", e.Index, e.Track,
+		e.Message, e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// Writes the given track to the given output file. Uses running status when
+// writing the output, unless the DisableRunningStatus option is given. If an
+// event can't be written, the returned error will be an *EventError.
+func (t *SMFTrack) WriteToFile(file io.Writer, options ...WriteOption) error {
+	return t.writeToFile(file, getWriteSettings(options), -1)
+}
+
+// Returns the message that should be written in place of m, which is m itself
+// unless the settings require it to be written differently.
+func (s *writeSettings) messageToWrite(m MIDIMessage) MIDIMessage {
+	if !s.noteOffsAsNoteOns {
+		return m
+	}
+	noteOff, ok := m.(*NoteOffEvent)
+	if !ok {
+		return m
+	}
+	return &NoteOnEvent{
+		Channel:  noteOff.Channel,
+		Note:     noteOff.Note,
+		Velocity: 0,
+	}
+}
+
+// Implements SMFTrack.WriteToFile. The track index is used in any returned
+// EventError.
+func (t *SMFTrack) writeToFile(file io.Writer, settings *writeSettings,
+	trackIndex int) error {
 	if len(t.Messages) != len(t.TimeDeltas) {
 		return fmt.Errorf("Bad track: has %d messages, but %d times",
 			len(t.Messages), len(t.TimeDeltas))
 	}
+	messages := t.Messages
+	timeDeltas := t.TimeDeltas
+	if settings.addEndOfTrack {
+		hasEnd := false
+		if len(messages) != 0 {
+			_, hasEnd = messages[len(messages)-1].(EndOfTrackMetaEvent)
+		}
+		if !hasEnd {
+			messages = append(messages[:len(messages):len(messages)],
+				EndOfTrackMetaEvent(0))
+			timeDeltas = append(timeDeltas[:len(timeDeltas):len(timeDeltas)],
+				0)
+		}
+	}
 	// The chunk size needs to go in the header, so we'll just dump the chunk's
 	// data into memory first.
 	chunkContent := &bytes.Buffer{}
 	var e error
 	var messageBytes []byte
 	runningStatus := byte(0)
-	for i := range t.TimeDeltas {
-		e = WriteVariableInt(chunkContent, t.TimeDeltas[i])
+	for i := range timeDeltas {
+		e = WriteVariableInt(chunkContent, timeDeltas[i])
 		if e != nil {
-			return fmt.Errorf("Couldn't write time delta for event %d: %s", i,
-				e)
+			return &EventError{
+				Track:   trackIndex,
+				Index:   i,
+				Message: messages[i],
+				Err:     fmt.Errorf("Couldn't write time delta: %w", e),
+			}
+		}
+		if settings.disableRunningStatus {
+			runningStatus = 0
 		}
-		messageBytes, e = t.Messages[i].SMFData(&runningStatus)
+		messageBytes, e = settings.messageToWrite(messages[i]).SMFData(
+			&runningStatus)
 		if e != nil {
-			return fmt.Errorf("Couldn't get bytes for event %d: %s", i, e)
+			return &EventError{
+				Track:   trackIndex,
+				Index:   i,
+				Message: messages[i],
+				Err:     e,
+			}
 		}
 		_, e = chunkContent.Write(messageBytes)
 		if e != nil {
@@ -124,27 +283,110 @@ func (t *SMFTrack) WriteToFile(file io.Writer) error {
 	return nil
 }
 
+// The number of events parsed between checks for context cancellation.
+const contextCheckInterval = 1024
+
+// The maximum number of events we'll allocate space for before parsing a
+// track.
+const maxInitialTrackCapacity = 4096
+
+// Wraps an io.Reader, keeping track of the number of bytes read from it.
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (c *countingReader) Read(data []byte) (int, error) {
+	n, e := c.r.Read(data)
+	c.count += int64(n)
+	return n, e
+}
+
+// Returns the number of bytes remaining to be read from r, and true, if this
+// can be determined. This is possible for readers with a Len() method (such
+// as *bytes.Reader), for *io.LimitedReader, and for readers implementing
+// io.Seeker (such as *os.File). Returns false if the remaining size is
+// unknown.
+func remainingBytes(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case *countingReader:
+		return remainingBytes(v.r)
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case *io.LimitedReader:
+		return v.N, true
+	case io.Seeker:
+		current, e := v.Seek(0, io.SeekCurrent)
+		if e != nil {
+			return 0, false
+		}
+		end, e := v.Seek(0, io.SeekEnd)
+		if e != nil {
+			return 0, false
+		}
+		_, e = v.Seek(current, io.SeekStart)
+		if e != nil {
+			return 0, false
+		}
+		return end - current, true
+	}
+	return 0, false
+}
+
+// The maximum number of stray bytes we'll skip while looking for the start of
+// a track chunk.
+const maxStrayBytes = 64
+
 // Parses and returns an SMF track, assuming the given reader is at the start
-// of a track.
-func parseSMFTrack(file io.Reader) (*SMFTrack, error) {
+// of a track. Returns an error if ctx is canceled before the track is fully
+// parsed. The reader's count is used to determine the file offsets of events.
+// Some software writes a few stray bytes between chunks (e.g. padding chunks
+// to an even length), or after a track's end-of-track event. These bytes are
+// skipped, and a message describing them is passed to the warn function.
+func parseSMFTrack(ctx context.Context, file *countingReader,
+	options *ParseOptions, warn func(format string,
+		args ...interface{})) (*SMFTrack, error) {
 	chunkType := make([]byte, 4)
-	e := binary.Read(file, binary.BigEndian, chunkType)
+	_, e := io.ReadFull(file, chunkType)
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading track's chunk type: %s", e)
 	}
-	if string(chunkType) != "MTrk" {
-		return nil, fmt.Errorf("Bad chunk type for track: %q",
-			string(chunkType))
+	skipped := 0
+	for string(chunkType) != "MTrk" {
+		if skipped >= maxStrayBytes {
+			return nil, fmt.Errorf("Bad chunk type for track: %q",
+				string(chunkType))
+		}
+		copy(chunkType, chunkType[1:])
+		chunkType[3], e = readByte(file)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading track's chunk type: %s", e)
+		}
+		skipped++
+	}
+	if skipped != 0 {
+		warn("Skipped %d stray bytes before the track chunk at offset 0x%X",
+			skipped, file.count-4)
 	}
 	var length uint32
 	e = binary.Read(file, binary.BigEndian, &length)
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading track's length: %s", e)
 	}
+	remaining, ok := remainingBytes(file)
+	if ok && (int64(length) > remaining) {
+		return nil, fmt.Errorf("Track length of %d bytes exceeds the %d "+
+			"bytes remaining in the input", length, remaining)
+	}
 	// We'll just guess for now that the track will require approximately 3
-	// bytes per event.
-	messages := make([]MIDIMessage, 0, length/3)
-	timeDeltas := make([]uint32, 0, length/3)
+	// bytes per event. Limit the initial capacity, though, since we can't
+	// trust the track's length until we've actually read the data.
+	capacity := length / 3
+	if capacity > maxInitialTrackCapacity {
+		capacity = maxInitialTrackCapacity
+	}
+	messages := make([]MIDIMessage, 0, capacity)
+	timeDeltas := make([]uint32, 0, capacity)
 	// We'll use a limitedReader to ensure that a track's data fits within its
 	// stated length.
 	limitedReader := &io.LimitedReader{
@@ -153,9 +395,19 @@ func parseSMFTrack(file io.Reader) (*SMFTrack, error) {
 	}
 	var timeDelta uint32
 	var message MIDIMessage
+	var offsets []int64
+	recordOffsets := (options != nil) && options.RecordOffsets
 	eventCount := 0
 	runningStatus := byte(0)
 	for {
+		offset := file.count
+		if (eventCount % contextCheckInterval) == 0 {
+			e = ctx.Err()
+			if e != nil {
+				return nil, fmt.Errorf("Stopped parsing at event %d: %w",
+					eventCount, e)
+			}
+		}
 		timeDelta, e = ReadVariableInt(limitedReader)
 		if e != nil {
 			// We know we've properly read the full track if we encounter EOF
@@ -164,19 +416,36 @@ func parseSMFTrack(file io.Reader) (*SMFTrack, error) {
 				break
 			}
 			return nil, fmt.Errorf("Failed reading time delta for event "+
-				"%d: %s", eventCount, e)
+				"%d at file offset 0x%X: %s", eventCount, offset, e)
 		}
 		timeDeltas = append(timeDeltas, timeDelta)
-		message, e = ReadSMFMessage(limitedReader, &runningStatus)
+		message, e = ReadSMFMessageWithOptions(limitedReader,
+			&runningStatus, options)
 		if e != nil {
 			return nil, fmt.Errorf("Failed reading MIDI message for event "+
-				"%d: %s", eventCount, e)
+				"%d at file offset 0x%X: %s", eventCount, offset, e)
 		}
 		messages = append(messages, message)
+		if recordOffsets {
+			offsets = append(offsets, offset)
+		}
+		eventCount++
+		_, isEnd := message.(EndOfTrackMetaEvent)
+		if isEnd && (limitedReader.N > 0) {
+			warn("Skipped %d stray bytes after the end-of-track event",
+				limitedReader.N)
+			_, e = io.Copy(io.Discard, limitedReader)
+			if e != nil {
+				return nil, fmt.Errorf("Failed skipping data after the "+
+					"end of the track: %s", e)
+			}
+			break
+		}
 	}
 	return &SMFTrack{
-		TimeDeltas: timeDeltas,
-		Messages:   messages,
+		TimeDeltas:   timeDeltas,
+		Messages:     messages,
+		EventOffsets: offsets,
 	}, nil
 }
 
@@ -187,40 +456,218 @@ type SMFFile struct {
 	// format it when writing the file.
 	Division TimeDivision
 	Tracks   []*SMFTrack
+	// The format from the file's header: 0 for a single track, 1 for
+	// simultaneous tracks, or 2 for independent sequences. WriteToFile uses
+	// this format, unless it's invalid for the number of tracks being
+	// written.
+	Format uint16
+	// Some sequencers write additional, non-standard, data following the 6
+	// standard bytes in the MThd chunk. This holds any such data; it will be
+	// written after the standard header fields by WriteToFile. May contain at
+	// most MaxExtraHeaderBytes bytes.
+	ExtraHeaderBytes []byte
+	// Describes any minor problems that were tolerated while parsing the
+	// file, such as stray bytes between chunks. Ignored when writing.
+	Warnings []string
+}
+
+// Reads the MThd chunk from the start of an SMF file. The chunk's type and
+// size are read first, followed by the number of bytes given by the size. The
+// format, track count, and division are parsed from the first 6 bytes of the
+// chunk's data. Any additional data must be read by readExtraHeaderBytes.
+func parseSMFHeader(file io.Reader) (*SMFHeader, error) {
+	var toReturn SMFHeader
+	e := binary.Read(file, binary.BigEndian, &(toReturn.ChunkType))
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading header chunk type: %s", e)
+	}
+	if string(toReturn.ChunkType[:]) != "MThd" {
+		return nil, fmt.Errorf("Bad chunk type for header: %q",
+			string(toReturn.ChunkType[:]))
+	}
+	e = binary.Read(file, binary.BigEndian, &(toReturn.ChunkSize))
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading header chunk size: %s", e)
+	}
+	if toReturn.ChunkSize < 6 {
+		return nil, fmt.Errorf("Header chunk is too small: %d bytes",
+			toReturn.ChunkSize)
+	}
+	data := make([]byte, 6)
+	_, e = io.ReadFull(file, data)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading header chunk data: %s", e)
+	}
+	toReturn.Format = binary.BigEndian.Uint16(data[0:2])
+	toReturn.TrackCount = binary.BigEndian.Uint16(data[2:4])
+	toReturn.Division = TimeDivision(binary.BigEndian.Uint16(data[4:6]))
+	return &toReturn, nil
+}
+
+// The maximum number of non-standard bytes we'll keep from, or write to, the
+// end of the MThd chunk.
+const MaxExtraHeaderBytes = 1024
+
+// Reads any data following the standard 6 bytes of the MThd chunk. Returns
+// the data if it's no larger than MaxExtraHeaderBytes, otherwise the data is
+// skipped and this returns nil.
+func readExtraHeaderBytes(file io.Reader, header *SMFHeader) ([]byte,
+	error) {
+	extra := int64(header.ChunkSize) - 6
+	if extra <= 0 {
+		return nil, nil
+	}
+	if extra > MaxExtraHeaderBytes {
+		_, e := io.CopyN(io.Discard, file, extra)
+		if e != nil {
+			return nil, fmt.Errorf("Failed skipping %d extra bytes in the "+
+				"header chunk: %s", extra, e)
+		}
+		return nil, nil
+	}
+	toReturn := make([]byte, extra)
+	_, e := io.ReadFull(file, toReturn)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading %d extra bytes in the header "+
+			"chunk: %s", extra, e)
+	}
+	return toReturn, nil
 }
 
 // Parses the given SMF file, returning an initialized SMFFile struct, or an
 // error if the file was invalid.
 func ParseSMFFile(file io.Reader) (*SMFFile, error) {
+	return ParseSMFFileContext(context.Background(), file)
+}
+
+// Parses an SMF file from the given bytes. This is a convenience wrapper
+// around ParseSMFFile.
+func ParseSMFBytes(b []byte) (*SMFFile, error) {
+	return ParseSMFFile(bytes.NewReader(b))
+}
+
+// Like ParseSMFFile, but stops parsing and returns an error if ctx is
+// canceled. The context is checked between tracks, and periodically while
+// parsing each track. The returned error wraps ctx.Err(). Note that this
+// can't interrupt a Read call that is blocked on the underlying reader; to
+// handle that case, the caller must arrange for the read to fail, e.g. by
+// closing the reader or setting a deadline on it.
+func ParseSMFFileContext(ctx context.Context, file io.Reader) (*SMFFile,
+	error) {
+	return parseSMFFile(ctx, file, nil)
+}
+
+// Like ParseSMFFile, but uses the given parsing options. The options may be
+// nil, in which case the defaults are used.
+func ParseSMFFileWithOptions(file io.Reader, options *ParseOptions) (*SMFFile,
+	error) {
+	return parseSMFFile(context.Background(), file, options)
+}
+
+// Like ParseSMFFile, but parses at most maxTracks tracks, and doesn't read
+// any further data from r once they've been parsed. If the file contains fewer
+// than maxTracks tracks, all of them are parsed. This is useful for quickly
+// previewing large files. Returns an error if maxTracks is less than 1.
+func ParseSMFFileLimited(r io.Reader, maxTracks int) (*SMFFile, error) {
+	if maxTracks < 1 {
+		return nil, fmt.Errorf("Invalid maximum number of tracks: %d",
+			maxTracks)
+	}
+	return parseSMFFile(context.Background(), r, &ParseOptions{
+		MaxTracks: maxTracks,
+	})
+}
+
+// Implements ParseSMFFile and its variants.
+func parseSMFFile(ctx context.Context, file io.Reader,
+	options *ParseOptions) (*SMFFile, error) {
 	var toReturn SMFFile
-	var header SMFHeader
-	e := binary.Read(file, binary.BigEndian, &header)
+	counter := &countingReader{
+		r: file,
+	}
+	header, e := parseSMFHeader(counter)
 	if e != nil {
 		return nil, fmt.Errorf("Failed parsing SMF header: %s", e)
 	}
 	toReturn.Division = header.Division
-	toReturn.Tracks = make([]*SMFTrack, header.TrackCount)
+	toReturn.Format = header.Format
+	toReturn.ExtraHeaderBytes, e = readExtraHeaderBytes(counter, header)
+	if e != nil {
+		return nil, e
+	}
+	trackCount := int(header.TrackCount)
+	if (options != nil) && (options.MaxTracks > 0) &&
+		(options.MaxTracks < trackCount) {
+		trackCount = options.MaxTracks
+	}
+	toReturn.Tracks = make([]*SMFTrack, trackCount)
 	for i := 0; i < len(toReturn.Tracks); i++ {
-		toReturn.Tracks[i], e = parseSMFTrack(file)
+		e = ctx.Err()
 		if e != nil {
-			return nil, fmt.Errorf("Failed parsing SMF track %d: %s", i, e)
+			return nil, fmt.Errorf("Stopped parsing before track %d: %w", i,
+				e)
+		}
+		trackIndex := i
+		warn := func(format string, args ...interface{}) {
+			toReturn.Warnings = append(toReturn.Warnings,
+				fmt.Sprintf("Track %d: ", trackIndex)+
+					fmt.Sprintf(format, args...))
+		}
+		toReturn.Tracks[i], e = parseSMFTrack(ctx, counter, options, warn)
+		if e != nil {
+			return nil, fmt.Errorf("Failed parsing SMF track %d: %w", i, e)
 		}
 	}
 	return &toReturn, nil
 }
 
 // Writes the given SMF file to an output file. Uses running status when
-// writing the output.
-func (f *SMFFile) WriteToFile(file io.Writer) error {
+// writing the output, unless the DisableRunningStatus option is given. See
+// the functions returning WriteOptions for the other available options. If an
+// event can't be written, the returned error will wrap an *EventError, which
+// can be obtained using errors.As. The header uses the file's Format if it's
+// valid for the number of tracks written, and otherwise uses format 0 for a
+// single track and format 1 for more.
+func (f *SMFFile) WriteToFile(file io.Writer, options ...WriteOption) error {
+	settings := getWriteSettings(options)
+	var trackIndices []int
+	if settings.omitEmptyTracks {
+		trackIndices = f.nonEmptyTrackIndices(settings.keepConductorTrack)
+	} else {
+		trackIndices = make([]int, len(f.Tracks))
+		for i := range trackIndices {
+			trackIndices[i] = i
+		}
+	}
+	// A file without tracks is valid, but is almost certainly a mistake, and
+	// some players refuse to load one.
+	if len(trackIndices) == 0 {
+		return fmt.Errorf("The file doesn't contain any tracks to write")
+	}
 	var header SMFHeader
 	header.ChunkType = [4]byte{'M', 'T', 'h', 'd'}
-	header.ChunkSize = 6
-	if len(f.Tracks) > 0xffff {
+	if len(f.ExtraHeaderBytes) > MaxExtraHeaderBytes {
+		return fmt.Errorf("Too many extra header bytes (%d), limited to %d",
+			len(f.ExtraHeaderBytes), MaxExtraHeaderBytes)
+	}
+	header.ChunkSize = uint32(6 + len(f.ExtraHeaderBytes))
+	if len(trackIndices) > 0xffff {
 		return fmt.Errorf("Have too many tracks (%d), limited to %d",
-			len(f.Tracks), 0xffff)
+			len(trackIndices), 0xffff)
 	}
-	header.TrackCount = uint16(len(f.Tracks))
-	if len(f.Tracks) == 1 {
+	header.TrackCount = uint16(len(trackIndices))
+	if settings.format >= 0 {
+		if settings.format > 2 {
+			return fmt.Errorf("Invalid SMF format: %d", settings.format)
+		}
+		if (settings.format == 0) && (len(trackIndices) != 1) {
+			return fmt.Errorf("Format 0 files must contain exactly one "+
+				"track, but got %d", len(trackIndices))
+		}
+		header.Format = uint16(settings.format)
+	} else if formatFits(f.Format, len(trackIndices)) {
+		header.Format = f.Format
+	} else if len(trackIndices) == 1 {
 		header.Format = 0
 	} else {
 		header.Format = 1
@@ -230,11 +677,355 @@ func (f *SMFFile) WriteToFile(file io.Writer) error {
 	if e != nil {
 		return fmt.Errorf("Failed writing SMF header: %s", e)
 	}
+	if len(f.ExtraHeaderBytes) != 0 {
+		_, e = file.Write(f.ExtraHeaderBytes)
+		if e != nil {
+			return fmt.Errorf("Failed writing extra header bytes: %s", e)
+		}
+	}
+	for _, i := range trackIndices {
+		e = f.Tracks[i].writeToFile(file, settings, i)
+		if e != nil {
+			return fmt.Errorf("Failed writing SMF track %d: %w", i, e)
+		}
+	}
+	return nil
+}
+
+// Returns the bytes of the SMF file, as they would be written by WriteToFile
+// with the same options.
+func (f *SMFFile) Bytes(options ...WriteOption) ([]byte, error) {
+	var buffer bytes.Buffer
+	e := f.WriteToFile(&buffer, options...)
+	if e != nil {
+		return nil, e
+	}
+	return buffer.Bytes(), nil
+}
+
+// Returns the SMF format that best fits the file's tracks: 0 if the file
+// contains a single track, 2 if it contains several tracks and its Format is
+// already 2, and 1 otherwise. Format 2 is never suggested for other files,
+// since tracks that look like independent sequences are usually just parts of
+// a single song, and players treat format 2 files very differently. This can
+// differ from the file's Format, for example if tracks were added to a format
+// 0 file, or a format 1 file contains only one track.
+func (f *SMFFile) SuggestedFormat() uint16 {
+	if len(f.Tracks) == 1 {
+		return 0
+	}
+	if f.Format == 2 {
+		return 2
+	}
+	return 1
+}
+
+// Returns true if the given SMF format can be used for a file with the given
+// number of tracks.
+func formatFits(format uint16, trackCount int) bool {
+	if format == 0 {
+		return trackCount == 1
+	}
+	return format <= 2
+}
+
+// Writes the SMF file to the file at the given path, using the given options,
+// replacing the file if it already exists. The data is written to a temporary
+// file in the same directory, which is renamed to the given path only if
+// writing succeeds, so an error won't leave a partially-written file at the
+// path. If the file already exists, its permissions are kept.
+func (f *SMFFile) Save(path string, options ...WriteOption) error {
+	mode := os.FileMode(0644)
+	info, e := os.Stat(path)
+	if e == nil {
+		mode = info.Mode().Perm()
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, e := os.CreateTemp(dir, "."+name+".tmp*")
+	if e != nil {
+		return fmt.Errorf("Failed creating temporary file: %w", e)
+	}
+	tmpPath := tmp.Name()
+	// This does nothing once the temporary file has been renamed.
+	defer os.Remove(tmpPath)
+	e = f.WriteToFile(tmp, options...)
+	if e != nil {
+		tmp.Close()
+		return e
+	}
+	e = tmp.Chmod(mode)
+	if e != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed setting permissions of %s: %w", tmpPath, e)
+	}
+	e = tmp.Close()
+	if e != nil {
+		return fmt.Errorf("Failed closing %s: %w", tmpPath, e)
+	}
+	e = os.Rename(tmpPath, path)
+	if e != nil {
+		return fmt.Errorf("Failed replacing %s: %w", path, e)
+	}
+	return nil
+}
+
+// Opens and parses the SMF file at the given path.
+func Load(path string) (*SMFFile, error) {
+	file, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+	toReturn, e := ParseSMFFile(file)
+	if e != nil {
+		return nil, fmt.Errorf("Failed parsing %s: %w", path, e)
+	}
+	return toReturn, nil
+}
+
+// Returns the indices of the tracks in the file that contain at least one
+// channel message. If keepConductor is true, the first track is also included
+// if it's a conductor track.
+func (f *SMFFile) nonEmptyTrackIndices(keepConductor bool) []int {
+	toReturn := make([]int, 0, len(f.Tracks))
 	for i, t := range f.Tracks {
-		e = t.WriteToFile(file)
+		if (i == 0) && keepConductor && t.IsConductorTrack() {
+			toReturn = append(toReturn, i)
+			continue
+		}
+		for _, m := range t.Messages {
+			_, ok := m.(ChannelMessage)
+			if ok {
+				toReturn = append(toReturn, i)
+				break
+			}
+		}
+	}
+	return toReturn
+}
+
+// Deletes every track that doesn't contain any channel messages. If
+// keepConductor is true, the first track is kept if it's a conductor track
+// (see SMFTrack.IsConductorTrack), even though it contains no channel
+// messages. Returns the number of tracks that were deleted.
+func (f *SMFFile) RemoveEmptyTracks(keepConductor bool) int {
+	indices := f.nonEmptyTrackIndices(keepConductor)
+	kept := make([]*SMFTrack, len(indices))
+	for i, index := range indices {
+		kept[i] = f.Tracks[index]
+	}
+	toReturn := len(f.Tracks) - len(kept)
+	f.Tracks = kept
+	return toReturn
+}
+
+// Combines the tracks from all of the given files into a single new multi-track
+// file, in the order the files are given. The new file contains copies of the
+// original tracks, including each file's tempo events. Returns an error if the
+// files don't all use the same time division; use Resample to convert them to
+// a common division first. Note that if the files contain conflicting tempo
+// events at the same time, the tempo from the later file takes effect.
+func CombineFiles(files ...*SMFFile) (*SMFFile, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No files to combine")
+	}
+	toReturn := &SMFFile{
+		Division: files[0].Division,
+	}
+	for i, f := range files {
+		if f.Division != toReturn.Division {
+			return nil, fmt.Errorf("File %d's time division (%s) doesn't "+
+				"match the first file's (%s)", i, f.Division,
+				toReturn.Division)
+		}
+		for _, t := range f.Tracks {
+			toReturn.Tracks = append(toReturn.Tracks, t.Copy())
+		}
+	}
+	if len(toReturn.Tracks) > 0xffff {
+		return nil, fmt.Errorf("The combined file has too many tracks (%d)",
+			len(toReturn.Tracks))
+	}
+	return toReturn, nil
+}
+
+// Appends the contents of other to the file, so that other's events play
+// after the end of the file's longest track. Track i of other is merged into
+// track i of the file, and any additional tracks in other are added as new
+// tracks. Each merged track keeps a single end-of-track event, at the end of
+// the merged events. If the files' time divisions differ, a copy of other is
+// resampled to the file's division first. Returns an error, leaving the file
+// unchanged, if other can't be resampled or if the combined file would be too
+// long or contain too many tracks. The other file isn't modified.
+func (f *SMFFile) Append(other *SMFFile) error {
+	appended := &SMFFile{
+		Division: other.Division,
+		Tracks:   make([]*SMFTrack, len(other.Tracks)),
+	}
+	for i, t := range other.Tracks {
+		appended.Tracks[i] = t.Copy()
+	}
+	if appended.Division != f.Division {
+		e := appended.Resample(f.Division)
 		if e != nil {
-			return fmt.Errorf("Failed writing SMF track %d: %s", i, e)
+			return fmt.Errorf("Failed resampling the appended file: %s", e)
+		}
+	}
+	offset := f.lastEventTick()
+	if (uint64(offset) + uint64(appended.lastEventTick())) > 0xffffffff {
+		return fmt.Errorf("The combined file is too long")
+	}
+	trackCount := len(f.Tracks)
+	if len(appended.Tracks) > trackCount {
+		trackCount = len(appended.Tracks)
+	}
+	if trackCount > 0xffff {
+		return fmt.Errorf("The combined file has too many tracks (%d)",
+			trackCount)
+	}
+	for i, t := range appended.Tracks {
+		events := t.timedMessages()
+		for j := range events {
+			events[j].tick += offset
+		}
+		if i < len(f.Tracks) {
+			events = append(f.Tracks[i].timedMessages(), events...)
+		}
+		merged := &SMFTrack{}
+		merged.setTimedMessages(events)
+		if i < len(f.Tracks) {
+			f.Tracks[i] = merged
+		} else {
+			f.Tracks = append(f.Tracks, merged)
+		}
+	}
+	return nil
+}
+
+// Converts every time delta in the file from the file's current time division
+// to the new one, and sets the file's division to newDivision. Both divisions
+// must specify ticks per quarter note; SMPTE-based divisions aren't supported.
+// Absolute event times are rounded to the nearest tick in the new division
+// before computing new time deltas, so rounding errors don't accumulate over
+// the course of a track.
+func (f *SMFFile) Resample(newDivision TimeDivision) error {
+	oldTicks := uint64(f.Division.TicksPerQuarterNote())
+	if oldTicks == 0 {
+		return fmt.Errorf("Can't resample from time division %s", f.Division)
+	}
+	newTicks := uint64(newDivision.TicksPerQuarterNote())
+	if newTicks == 0 {
+		return fmt.Errorf("Can't resample to time division %s", newDivision)
+	}
+	// Compute all of the new deltas before modifying any tracks, so the file
+	// is left unchanged if an error occurs.
+	allNewDeltas := make([][]uint32, len(f.Tracks))
+	for i, t := range f.Tracks {
+		oldTime := uint64(0)
+		previous := uint64(0)
+		newDeltas := make([]uint32, len(t.TimeDeltas))
+		for j, d := range t.TimeDeltas {
+			oldTime += uint64(d)
+			newTime := (oldTime*newTicks + oldTicks/2) / oldTicks
+			delta := newTime - previous
+			if delta > 0x0fffffff {
+				return fmt.Errorf("Resampled time delta for event %d in "+
+					"track %d is too large: %d", j, i, delta)
+			}
+			newDeltas[j] = uint32(delta)
+			previous = newTime
 		}
+		allNewDeltas[i] = newDeltas
+	}
+	for i, t := range f.Tracks {
+		t.TimeDeltas = allNewDeltas[i]
 	}
+	f.Division = newDivision
 	return nil
 }
+
+// Returns the number of bytes the track would occupy when written to an SMF
+// file using the given settings, including the 8-byte chunk header. Accounts
+// for running status and the lengths of the time deltas. Messages that can't
+// be encoded are counted as zero bytes.
+func (t *SMFTrack) estimateSize(settings *writeSettings) int {
+	toReturn := 8
+	runningStatus := byte(0)
+	for i, m := range t.Messages {
+		if i < len(t.TimeDeltas) {
+			toReturn += variableIntLength(t.TimeDeltas[i])
+		}
+		if settings.disableRunningStatus {
+			runningStatus = 0
+		}
+		data, e := settings.messageToWrite(m).SMFData(&runningStatus)
+		if e != nil {
+			continue
+		}
+		toReturn += len(data)
+	}
+	if settings.addEndOfTrack {
+		hasEnd := false
+		if len(t.Messages) != 0 {
+			_, hasEnd = t.Messages[len(t.Messages)-1].(EndOfTrackMetaEvent)
+		}
+		if !hasEnd {
+			// A time delta of 0, followed by the 3-byte meta-event.
+			toReturn += 4
+		}
+	}
+	return toReturn
+}
+
+// Returns the number of bytes that WriteToFile would write for this file when
+// given the same options, without actually formatting the file. Messages that
+// can't be encoded (which would cause WriteToFile to fail) are counted as zero
+// bytes.
+func (f *SMFFile) EstimateSize(options ...WriteOption) int {
+	settings := getWriteSettings(options)
+	toReturn := 14 + len(f.ExtraHeaderBytes)
+	if settings.omitEmptyTracks {
+		for _, i := range f.nonEmptyTrackIndices(settings.keepConductorTrack) {
+			toReturn += f.Tracks[i].estimateSize(settings)
+		}
+		return toReturn
+	}
+	for _, t := range f.Tracks {
+		toReturn += t.estimateSize(settings)
+	}
+	return toReturn
+}
+
+// Returns the greatest common divisor of a and b.
+func gcd(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Returns the smallest ticks-per-quarter-note time division that can represent
+// every event time in the file exactly. This is the file's current division
+// divided by the greatest common divisor of the division and every time delta
+// in the file. Passing the result to Resample reduces the file's resolution
+// without changing the timing of any events. Returns an error if the file
+// uses an SMPTE-based time division.
+func (f *SMFFile) MinimalDivision() (TimeDivision, error) {
+	ticks := uint32(f.Division.TicksPerQuarterNote())
+	if ticks == 0 {
+		return 0, fmt.Errorf("Can't reduce time division %s", f.Division)
+	}
+	divisor := ticks
+	for _, t := range f.Tracks {
+		for _, d := range t.TimeDeltas {
+			divisor = gcd(divisor, d)
+			if divisor == 1 {
+				return f.Division, nil
+			}
+		}
+	}
+	return TimeDivision(ticks / divisor), nil
+}
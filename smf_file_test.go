@@ -2,6 +2,11 @@ package midi
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -112,3 +117,880 @@ func TestParseSMFFile(t *testing.T) {
 	}
 	t.Logf("The written output file matches the input SMF data!\n")
 }
+
+func TestEstimateSize(t *testing.T) {
+	f, e := os.Open("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed opening test file: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	smf, e := ParseSMFFile(f)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	var output bytes.Buffer
+	e = smf.WriteToFile(&output)
+	if e != nil {
+		t.Logf("Failed writing SMF file: %s\n", e)
+		t.FailNow()
+	}
+	estimate := smf.EstimateSize()
+	if estimate != output.Len() {
+		t.Logf("Estimated size of %d bytes, but wrote %d bytes\n", estimate,
+			output.Len())
+		t.FailNow()
+	}
+	t.Logf("Correctly estimated the file's size: %d bytes\n", estimate)
+	// Remove an end-of-track event so that AddEndOfTrack has an effect.
+	last := smf.Tracks[1]
+	last.Messages = last.Messages[:len(last.Messages)-1]
+	last.TimeDeltas = last.TimeDeltas[:len(last.TimeDeltas)-1]
+	optionSets := [][]WriteOption{
+		{DisableRunningStatus()},
+		{NoteOffsAsNoteOns()},
+		{AddEndOfTrack(), OmitEmptyTracks(false)},
+	}
+	for i, options := range optionSets {
+		output.Reset()
+		e = smf.WriteToFile(&output, options...)
+		if e != nil {
+			t.Logf("Failed writing SMF file with option set %d: %s\n", i, e)
+			t.FailNow()
+		}
+		estimate = smf.EstimateSize(options...)
+		if estimate != output.Len() {
+			t.Logf("Estimated size of %d bytes with option set %d, but "+
+				"wrote %d bytes\n", estimate, i, output.Len())
+			t.FailNow()
+		}
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+				},
+				TimeDeltas: []uint32{0, 96},
+			},
+		},
+	}
+	var output bytes.Buffer
+	e := smf.WriteToFile(&output, DisableRunningStatus(), AddEndOfTrack(),
+		ForceFormat(1))
+	if e != nil {
+		t.Logf("Failed writing SMF file: %s\n", e)
+		t.FailNow()
+	}
+	data := output.Bytes()
+	// Header: 14 bytes. Track: 8-byte chunk header, followed by three events,
+	// each taking 4 bytes including its time delta. Without running status,
+	// the second note-on must include its status byte.
+	if len(data) != 14+8+4+4+4 {
+		t.Logf("Got incorrect output length: %d\n", len(data))
+		t.FailNow()
+	}
+	if data[9] != 1 {
+		t.Logf("Expected format 1 in the header, got %d\n", data[9])
+		t.FailNow()
+	}
+	parsed, e := ParseSMFFile(bytes.NewReader(data))
+	if e != nil {
+		t.Logf("Failed parsing written file: %s\n", e)
+		t.FailNow()
+	}
+	if len(parsed.Tracks[0].Messages) != 3 {
+		t.Logf("Expected an end-of-track event to be added\n")
+		t.FailNow()
+	}
+	if len(smf.Tracks[0].Messages) != 2 {
+		t.Logf("Writing the file modified the original track\n")
+		t.FailNow()
+	}
+	e = smf.WriteToFile(&output, ForceFormat(0))
+	if e != nil {
+		t.Logf("Failed writing single-track file as format 0: %s\n", e)
+		t.FailNow()
+	}
+	smf.Tracks = append(smf.Tracks, smf.Tracks[0])
+	e = smf.WriteToFile(&output, ForceFormat(0))
+	if e == nil {
+		t.Logf("Didn't get an error writing two tracks as format 0\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error writing two tracks as format 0: %s\n", e)
+	// Writing a file without any tracks is almost certainly a mistake.
+	smf.Tracks = nil
+	e = smf.WriteToFile(&output)
+	if e == nil {
+		t.Logf("Didn't get an error writing a file without tracks\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error writing a file without tracks: %s\n", e)
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	original, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed reading test file: %s\n", e)
+		t.FailNow()
+	}
+	smf, e := ParseSMFBytes(original)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	data, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed getting SMF bytes: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(data, original) {
+		t.Logf("The SMF bytes don't match the original file\n")
+		t.FailNow()
+	}
+}
+
+func TestRemoveEmptyTracks(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+			{
+				Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&ProgramChangeEvent{Channel: 0, Value: 1},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+		},
+	}
+	// Keep a copy of the track list, to test keeping the conductor track.
+	withConductor := &SMFFile{
+		Division: smf.Division,
+		Tracks:   append([]*SMFTrack(nil), smf.Tracks...),
+	}
+	data, e := smf.Bytes(OmitEmptyTracks(true))
+	if e != nil {
+		t.Logf("Failed writing file without empty tracks: %s\n", e)
+		t.FailNow()
+	}
+	if len(smf.Tracks) != 3 {
+		t.Logf("Writing the file modified its tracks\n")
+		t.FailNow()
+	}
+	parsed, e := ParseSMFBytes(data)
+	if e != nil {
+		t.Logf("Failed parsing written file: %s\n", e)
+		t.FailNow()
+	}
+	if len(parsed.Tracks) != 2 {
+		t.Logf("Expected 2 tracks in the written file, got %d\n",
+			len(parsed.Tracks))
+		t.FailNow()
+	}
+	removed := smf.RemoveEmptyTracks(false)
+	if (removed != 2) || (len(smf.Tracks) != 1) {
+		t.Logf("Expected to remove 2 tracks, removed %d\n", removed)
+		t.FailNow()
+	}
+	removed = withConductor.RemoveEmptyTracks(true)
+	if (removed != 1) || !withConductor.Tracks[0].IsConductorTrack() {
+		t.Logf("Didn't keep the conductor track\n")
+		t.FailNow()
+	}
+}
+
+func TestNoteOffVelocity(t *testing.T) {
+	// A format 0 file with one track, containing a note-on and a genuine
+	// note-off with a release velocity of 0x55.
+	data := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+		'M', 'T', 'r', 'k', 0, 0, 0, 12,
+		0x00, 0x90, 0x3c, 0x64,
+		0x60, 0x80, 0x3c, 0x55,
+		0x00, 0xff, 0x2f, 0x00,
+	}
+	smf, e := ParseSMFBytes(data)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	noteOff, ok := smf.Tracks[0].Messages[1].(*NoteOffEvent)
+	if !ok || (noteOff.Velocity != 0x55) {
+		t.Logf("Didn't parse the note-off's release velocity: %s\n",
+			smf.Tracks[0].Messages[1])
+		t.FailNow()
+	}
+	output, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing file: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(output, data) {
+		t.Logf("The written file doesn't match the original\n")
+		t.FailNow()
+	}
+	output, e = smf.Bytes(NoteOffsAsNoteOns())
+	if e != nil {
+		t.Logf("Failed writing file with note-ons for note-offs: %s\n", e)
+		t.FailNow()
+	}
+	// The note-off should now use running status from the note-on.
+	expected := append([]byte{}, data[:22]...)
+	expected[21] = 11
+	expected = append(expected, 0x00, 0x90, 0x3c, 0x64, 0x60, 0x3c, 0x00,
+		0x00, 0xff, 0x2f, 0x00)
+	if !bytes.Equal(output, expected) {
+		t.Logf("Got incorrect output with note-ons for note-offs: % x\n",
+			output)
+		t.FailNow()
+	}
+}
+
+func TestMinimalDivision(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(960),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{480, 240, 0},
+			},
+		},
+	}
+	division, e := smf.MinimalDivision()
+	if e != nil {
+		t.Logf("Failed getting minimal division: %s\n", e)
+		t.FailNow()
+	}
+	if division != TimeDivision(4) {
+		t.Logf("Expected a minimal division of 4, got %s\n", division)
+		t.FailNow()
+	}
+	e = smf.Resample(division)
+	if e != nil {
+		t.Logf("Failed resampling: %s\n", e)
+		t.FailNow()
+	}
+	if (smf.Tracks[0].TimeDeltas[0] != 2) ||
+		(smf.Tracks[0].TimeDeltas[1] != 1) {
+		t.Logf("Got incorrect resampled deltas: %v\n",
+			smf.Tracks[0].TimeDeltas)
+		t.FailNow()
+	}
+	smf.Division = TimeDivision(0xe728)
+	_, e = smf.MinimalDivision()
+	if e == nil {
+		t.Logf("Didn't get an error for an SMPTE division\n")
+		t.FailNow()
+	}
+}
+
+func TestParseSMFFileLimited(t *testing.T) {
+	data, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed reading test file: %s\n", e)
+		t.FailNow()
+	}
+	full, e := ParseSMFBytes(data)
+	if e != nil {
+		t.Logf("Failed parsing test file: %s\n", e)
+		t.FailNow()
+	}
+	// Only provide the data up to the end of the second track, to make sure
+	// nothing after it is read.
+	var buffer bytes.Buffer
+	buffer.Write(data[:14])
+	for _, track := range full.Tracks[:2] {
+		track.WriteToFile(&buffer)
+	}
+	limited, e := ParseSMFFileLimited(bytes.NewReader(buffer.Bytes()), 2)
+	if e != nil {
+		t.Logf("Failed parsing the first 2 tracks: %s\n", e)
+		t.FailNow()
+	}
+	if len(limited.Tracks) != 2 {
+		t.Logf("Expected 2 tracks, got %d\n", len(limited.Tracks))
+		t.FailNow()
+	}
+	limited, e = ParseSMFFileLimited(bytes.NewReader(data), 1000)
+	if e != nil {
+		t.Logf("Failed parsing with a high track limit: %s\n", e)
+		t.FailNow()
+	}
+	if len(limited.Tracks) != len(full.Tracks) {
+		t.Logf("Expected %d tracks, got %d\n", len(full.Tracks),
+			len(limited.Tracks))
+		t.FailNow()
+	}
+}
+
+func TestWriteEventError(t *testing.T) {
+	badEvent := &NoteOnEvent{Channel: 16, Note: 60, Velocity: 100}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{0},
+			},
+			{
+				Messages:   []MIDIMessage{badEvent, EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{0, 0},
+			},
+		},
+	}
+	_, e := smf.Bytes()
+	if e == nil {
+		t.Logf("Didn't get an error writing an invalid channel\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	var eventError *EventError
+	if !errors.As(e, &eventError) {
+		t.Logf("The error didn't contain an EventError\n")
+		t.FailNow()
+	}
+	if (eventError.Track != 1) || (eventError.Index != 0) ||
+		(eventError.Message != badEvent) {
+		t.Logf("The EventError has incorrect fields: %+v\n", eventError)
+		t.FailNow()
+	}
+}
+
+func FuzzParseSMFFile(f *testing.F) {
+	testFile, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		f.Logf("Failed reading test file: %s\n", e)
+		f.FailNow()
+	}
+	f.Add(testFile)
+	f.Add([]byte{0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 6, 0, 0, 0, 1, 0, 0x60})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		smf, e := ParseSMFFile(bytes.NewReader(data))
+		if e != nil {
+			return
+		}
+		// Files that parse successfully shouldn't cause a panic when written
+		// back out, either. (Writing may still return an error, though.)
+		var output bytes.Buffer
+		smf.WriteToFile(&output)
+	})
+}
+
+func TestParsePaddedTracks(t *testing.T) {
+	smfData := []byte{
+		// MThd, with 2 tracks and 96 ticks per quarter note.
+		0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 6, 0, 1, 0, 2, 0, 0x60,
+		// The first track, with a stray 0 byte after the end of track.
+		0x4d, 0x54, 0x72, 0x6b, 0, 0, 0, 5,
+		0, 0xff, 0x2f, 0, 0,
+		// A padding byte between the tracks.
+		0,
+		// The second track, which contains only an end-of-track event.
+		0x4d, 0x54, 0x72, 0x6b, 0, 0, 0, 4,
+		0, 0xff, 0x2f, 0,
+	}
+	smf, e := ParseSMFFile(bytes.NewReader(smfData))
+	if e != nil {
+		t.Logf("Failed parsing padded SMF data: %s\n", e)
+		t.FailNow()
+	}
+	if len(smf.Tracks) != 2 {
+		t.Logf("Expected 2 tracks, got %d\n", len(smf.Tracks))
+		t.FailNow()
+	}
+	for i, track := range smf.Tracks {
+		if len(track.Messages) != 1 {
+			t.Logf("Expected 1 message in track %d, got %d\n", i,
+				len(track.Messages))
+			t.FailNow()
+		}
+	}
+	if len(smf.Warnings) != 2 {
+		t.Logf("Expected 2 warnings, got %d\n", len(smf.Warnings))
+		t.FailNow()
+	}
+	for _, w := range smf.Warnings {
+		t.Logf("Got expected warning: %s\n", w)
+	}
+}
+
+func TestCombineFiles(t *testing.T) {
+	first := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+		},
+	}
+	second := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0},
+			},
+		},
+	}
+	combined, e := CombineFiles(first, second)
+	if e != nil {
+		t.Logf("Failed combining files: %s\n", e)
+		t.FailNow()
+	}
+	if (combined.Division != 96) || (len(combined.Tracks) != 2) {
+		t.Logf("Expected 2 tracks with a division of 96, got %d tracks "+
+			"with a division of %d\n", len(combined.Tracks), combined.Division)
+		t.FailNow()
+	}
+	if _, ok := combined.Tracks[0].Messages[0].(SetTempoMetaEvent); !ok {
+		t.Logf("The first file's track wasn't first in the combined file\n")
+		t.FailNow()
+	}
+	if len(combined.Tracks[1].Messages) != 3 {
+		t.Logf("The second file's track wasn't second in the combined file\n")
+		t.FailNow()
+	}
+	// The combined file's tracks must be copies.
+	combined.Tracks[1].Messages[0].(*NoteOnEvent).Note = 61
+	combined.Tracks[1].TimeDeltas[1] = 1
+	if (second.Tracks[0].Messages[0].(*NoteOnEvent).Note != 60) ||
+		(second.Tracks[0].TimeDeltas[1] != 96) {
+		t.Logf("Modifying the combined file changed the original\n")
+		t.FailNow()
+	}
+	second.Division = TimeDivision(192)
+	_, e = CombineFiles(first, second)
+	if e == nil {
+		t.Logf("Didn't get an error combining mismatched divisions\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error combining mismatched divisions: %s\n", e)
+	_, e = CombineFiles()
+	if e == nil {
+		t.Logf("Didn't get an error when combining no files\n")
+		t.FailNow()
+	}
+}
+
+func TestAppend(t *testing.T) {
+	first := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 192, 0},
+			},
+		},
+	}
+	// The second file uses twice the resolution, so its events should be
+	// resampled.
+	second := &SMFFile{
+		Division: TimeDivision(192),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 192, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 1, Note: 64, Velocity: 100},
+					&NoteOnEvent{Channel: 1, Note: 64, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{192, 192, 0},
+			},
+		},
+	}
+	e := first.Append(second)
+	if e != nil {
+		t.Logf("Failed appending file: %s\n", e)
+		t.FailNow()
+	}
+	if second.Division != 192 {
+		t.Logf("The appended file was modified\n")
+		t.FailNow()
+	}
+	if len(first.Tracks) != 2 {
+		t.Logf("Expected 2 tracks, got %d\n", len(first.Tracks))
+		t.FailNow()
+	}
+	expected := []Note{
+		{Channel: 0, Pitch: 60, Velocity: 100, Tick: 0, Duration: 192},
+		{Channel: 0, Pitch: 62, Velocity: 100, Tick: 192, Duration: 96},
+		{Channel: 1, Pitch: 64, Velocity: 100, Tick: 288, Duration: 96},
+	}
+	notes := first.Notes()
+	if len(notes) != len(expected) {
+		t.Logf("Expected %d notes, got %d\n", len(expected), len(notes))
+		t.FailNow()
+	}
+	for i := range expected {
+		if notes[i] != expected[i] {
+			t.Logf("Expected note %+v, got %+v\n", expected[i], notes[i])
+			t.FailNow()
+		}
+	}
+	endCount := 0
+	for _, m := range first.Tracks[0].Messages {
+		if _, ok := m.(EndOfTrackMetaEvent); ok {
+			endCount++
+		}
+	}
+	if endCount != 1 {
+		t.Logf("Expected 1 end-of-track event, got %d\n", endCount)
+		t.FailNow()
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.mid")
+	smf, e := Load("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed loading test file: %s\n", e)
+		t.FailNow()
+	}
+	e = smf.Save(path)
+	if e != nil {
+		t.Logf("Failed saving file: %s\n", e)
+		t.FailNow()
+	}
+	original, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed reading test file: %s\n", e)
+		t.FailNow()
+	}
+	saved, e := os.ReadFile(path)
+	if e != nil {
+		t.Logf("Failed reading saved file: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(original, saved) {
+		t.Logf("The saved file doesn't match the original\n")
+		t.FailNow()
+	}
+	// An invalid event should cause an error, leaving the existing file
+	// unchanged and no temporary files behind.
+	smf.Tracks[0].Messages[0] = &NoteOnEvent{Channel: 16, Note: 60}
+	e = smf.Save(path)
+	if e == nil {
+		t.Logf("Didn't get an error saving an invalid file\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error saving an invalid file: %s\n", e)
+	saved, e = os.ReadFile(path)
+	if (e != nil) || !bytes.Equal(original, saved) {
+		t.Logf("The existing file was modified by a failed save\n")
+		t.FailNow()
+	}
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		t.Logf("Failed reading temporary directory: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 1 {
+		t.Logf("Expected 1 file in the directory, got %d\n", len(entries))
+		t.FailNow()
+	}
+	_, e = Load(filepath.Join(dir, "missing.mid"))
+	if e == nil {
+		t.Logf("Didn't get an error loading a missing file\n")
+		t.FailNow()
+	}
+}
+
+func TestSuggestedFormat(t *testing.T) {
+	sequence := &SMFTrack{
+		Messages: []MIDIMessage{
+			SetTempoMetaEvent(500000),
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 96, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{sequence},
+	}
+	if smf.SuggestedFormat() != 0 {
+		t.Logf("Expected format 0 for a single track\n")
+		t.FailNow()
+	}
+	// Tracks that each set the tempo and contain notes may still be parts of
+	// the same song, so format 2 shouldn't be suggested for them.
+	smf.Tracks = append(smf.Tracks, sequence.Copy())
+	if smf.SuggestedFormat() != 1 {
+		t.Logf("Expected format 1 for multiple tracks\n")
+		t.FailNow()
+	}
+	// Mismatched time deltas must not cause a panic.
+	smf.Tracks[1].TimeDeltas = smf.Tracks[1].TimeDeltas[:1]
+	if smf.SuggestedFormat() != 1 {
+		t.Logf("Expected format 1 for a track with missing time deltas\n")
+		t.FailNow()
+	}
+	smf.Tracks[1] = sequence.Copy()
+	smf.Format = 2
+	if smf.SuggestedFormat() != 2 {
+		t.Logf("Expected format 2 to be kept for a format 2 file\n")
+		t.FailNow()
+	}
+
+	// A format 1 file with a single track keeps its format when written, but
+	// format 0 is suggested for it.
+	smf.Tracks = smf.Tracks[:1]
+	smf.Format = 1
+	if smf.SuggestedFormat() != 0 {
+		t.Logf("Expected format 0 for a format 1 file with one track\n")
+		t.FailNow()
+	}
+	data, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing the format 1 file: %s\n", e)
+		t.FailNow()
+	}
+	parsed, e := ParseSMFFile(bytes.NewReader(data))
+	if e != nil {
+		t.Logf("Failed parsing the format 1 file: %s\n", e)
+		t.FailNow()
+	}
+	if parsed.Format != 1 {
+		t.Logf("Expected the parsed file to be format 1, got %d\n",
+			parsed.Format)
+		t.FailNow()
+	}
+	data, e = parsed.Bytes(ForceFormat(parsed.SuggestedFormat()))
+	if e != nil {
+		t.Logf("Failed writing the file with its suggested format: %s\n", e)
+		t.FailNow()
+	}
+	if data[9] != 0 {
+		t.Logf("Expected the suggested format to be written, got %d\n",
+			data[9])
+		t.FailNow()
+	}
+
+	// A format 0 file with more than one track falls back to format 1.
+	smf.Tracks = append(smf.Tracks, sequence.Copy())
+	smf.Format = 0
+	data, e = smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing the format 0 file with two tracks: %s\n", e)
+		t.FailNow()
+	}
+	if data[9] != 1 {
+		t.Logf("Expected a two-track file to be written as format 1, got "+
+			"%d\n", data[9])
+		t.FailNow()
+	}
+}
+
+func TestParseSMFFileContext(t *testing.T) {
+	data, e := os.ReadFile("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed reading test file: %s\n", e)
+		t.FailNow()
+	}
+	smf, e := ParseSMFFileContext(context.Background(), bytes.NewReader(data))
+	if e != nil {
+		t.Logf("Failed parsing with a background context: %s\n", e)
+		t.FailNow()
+	}
+	if len(smf.Tracks) == 0 {
+		t.Logf("Didn't parse any tracks with a background context\n")
+		t.FailNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e = ParseSMFFileContext(ctx, bytes.NewReader(data))
+	if e == nil {
+		t.Logf("Didn't get an error parsing with a canceled context\n")
+		t.FailNow()
+	}
+	if !errors.Is(e, context.Canceled) {
+		t.Logf("The error didn't wrap context.Canceled: %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error with a canceled context: %s\n", e)
+}
+
+func TestExtraHeaderBytes(t *testing.T) {
+	smfData := []byte{
+		// MThd, with a chunk size of 8 rather than 6.
+		0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 8,
+		// Format 0, 1 track, 96 ticks per quarter note, and 2 extra bytes.
+		0, 0, 0, 1, 0, 0x60, 0xab, 0xcd,
+		// A track containing only an end-of-track event.
+		0x4d, 0x54, 0x72, 0x6b, 0, 0, 0, 4,
+		0, 0xff, 0x2f, 0,
+	}
+	smf, e := ParseSMFBytes(smfData)
+	if e != nil {
+		t.Logf("Failed parsing file with extra header bytes: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(smf.ExtraHeaderBytes, []byte{0xab, 0xcd}) {
+		t.Logf("Got incorrect extra header bytes: % x\n",
+			smf.ExtraHeaderBytes)
+		t.FailNow()
+	}
+	var output bytes.Buffer
+	e = smf.WriteToFile(&output)
+	if e != nil {
+		t.Logf("Failed writing file with extra header bytes: %s\n", e)
+		t.FailNow()
+	}
+	if !bytes.Equal(output.Bytes(), smfData) {
+		t.Logf("The written file didn't match the original: % x\n",
+			output.Bytes())
+		t.FailNow()
+	}
+	smf.ExtraHeaderBytes = make([]byte, MaxExtraHeaderBytes+1)
+	e = smf.WriteToFile(&output)
+	if e == nil {
+		t.Logf("Didn't get an error writing too many extra header bytes\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error writing too many header bytes: %s\n", e)
+}
+
+func TestRemainingBytes(t *testing.T) {
+	data := make([]byte, 100)
+	r := bytes.NewReader(data)
+	r.Seek(30, io.SeekStart)
+	n, ok := remainingBytes(&countingReader{r: r})
+	if !ok || (n != 70) {
+		t.Logf("Expected 70 bytes remaining in a bytes.Reader, got %d (%v)\n",
+			n, ok)
+		t.FailNow()
+	}
+	n, ok = remainingBytes(&io.LimitedReader{R: r, N: 5})
+	if !ok || (n != 5) {
+		t.Logf("Expected 5 bytes remaining in a LimitedReader, got %d (%v)\n",
+			n, ok)
+		t.FailNow()
+	}
+	f, e := os.Open("test_midi.mid")
+	if e != nil {
+		t.Logf("Failed opening test file: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		t.Logf("Failed getting test file's size: %s\n", e)
+		t.FailNow()
+	}
+	f.Seek(10, io.SeekStart)
+	n, ok = remainingBytes(f)
+	if !ok || (n != (info.Size() - 10)) {
+		t.Logf("Expected %d bytes remaining in the file, got %d (%v)\n",
+			info.Size()-10, n, ok)
+		t.FailNow()
+	}
+	// The seek position must be restored.
+	position, _ := f.Seek(0, io.SeekCurrent)
+	if position != 10 {
+		t.Logf("Checking the remaining size moved the file to %d\n", position)
+		t.FailNow()
+	}
+	_, ok = remainingBytes(io.MultiReader(r))
+	if ok {
+		t.Logf("Got a remaining size for a reader without one\n")
+		t.FailNow()
+	}
+
+	// A track claiming to be longer than the rest of the input should be
+	// rejected.
+	smfData := []byte{
+		0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 6, 0, 0, 0, 1, 0, 0x60,
+		0x4d, 0x54, 0x72, 0x6b, 0x7f, 0xff, 0xff, 0xff,
+		0, 0xff, 0x2f, 0,
+	}
+	_, e = ParseSMFBytes(smfData)
+	if e == nil {
+		t.Logf("Didn't get an error for an overly long track\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an overly long track: %s\n", e)
+}
+
+func TestRecordOffsets(t *testing.T) {
+	smfData := []byte{
+		0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 6, 0, 0, 0, 1, 0, 0x60,
+		0x4d, 0x54, 0x72, 0x6b, 0, 0, 0, 12,
+		// Offset 22: A note on, setting running status.
+		0, 0x90, 0x3c, 0x40,
+		// Offset 26: A note off using running status, with a 2-byte delta.
+		0x81, 0x00, 0x3c, 0,
+		// Offset 30: End of track.
+		0, 0xff, 0x2f, 0,
+	}
+	smf, e := ParseSMFFileWithOptions(bytes.NewReader(smfData),
+		&ParseOptions{RecordOffsets: true})
+	if e != nil {
+		t.Logf("Failed parsing file: %s\n", e)
+		t.FailNow()
+	}
+	expected := []int64{22, 26, 30}
+	offsets := smf.Tracks[0].EventOffsets
+	if len(offsets) != len(expected) {
+		t.Logf("Expected %d offsets, got %d\n", len(expected), len(offsets))
+		t.FailNow()
+	}
+	for i, v := range expected {
+		if offsets[i] != v {
+			t.Logf("Expected offset %d for event %d, got %d\n", v, i,
+				offsets[i])
+			t.FailNow()
+		}
+	}
+	smf, e = ParseSMFBytes(smfData)
+	if e != nil {
+		t.Logf("Failed parsing file without recording offsets: %s\n", e)
+		t.FailNow()
+	}
+	if smf.Tracks[0].EventOffsets != nil {
+		t.Logf("Got offsets without the RecordOffsets option\n")
+		t.FailNow()
+	}
+}
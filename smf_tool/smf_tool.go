@@ -4,15 +4,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/yalue/midi"
+	"github.com/yalue/midi/gm"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// Where messages other than the event dump are printed. This is changed to
+// stderr when the events are printed as JSON, so that stdout stays valid JSON.
+var statusOutput io.Writer = os.Stdout
+
 // Returns the value of a lower-case hex char
 func hexCharToValue(b byte) byte {
 	if (b >= '0') && (b <= '9') {
@@ -83,13 +91,13 @@ func insertNewEvent(hexData string, track, position int,
 	if e != nil {
 		return fmt.Errorf("Couldn't read new event's delta time: %s", e)
 	}
-	fmt.Printf("New event delta time: %d\n", deltaTime)
+	fmt.Fprintf(statusOutput, "New event delta time: %d\n", deltaTime)
 	runningStatus := byte(0)
 	event, e := midi.ReadSMFMessage(r, &runningStatus)
 	if e != nil {
 		return fmt.Errorf("Couldn't parse new event: %s", e)
 	}
-	fmt.Printf("Inserting new event: %s\n", event)
+	fmt.Fprintf(statusOutput, "Inserting new event: %s\n", event)
 	newTimes := make([]uint32, len(t.TimeDeltas)+1)
 	newMessages := make([]midi.MIDIMessage, len(t.Messages)+1)
 	// Copy the events and times before the new event.
@@ -123,14 +131,6 @@ func stringToChannelNumber(s string) (uint8, error) {
 	return uint8(v), nil
 }
 
-// We'll use this interface to identify and modify events that are associated
-// with a channel.
-type ChannelMessage interface {
-	midi.MIDIMessage
-	GetChannel() uint8
-	SetChannel(c uint8) error
-}
-
 // Modifies the SMFFile struct to reassign every event in one channel to happen
 // in a different channel instead. I used this to fix a broken MIDI file that
 // incorrectly put some non-percussion in channel 10. We'll use channel numbers
@@ -153,7 +153,7 @@ func reassignChannels(args string, smf *midi.SMFFile) error {
 	for _, t := range smf.Tracks {
 		for _, m := range t.Messages {
 			totalCount++
-			channelMessage, ok := m.(ChannelMessage)
+			channelMessage, ok := m.(midi.ChannelMessage)
 			if !ok {
 				continue
 			}
@@ -169,11 +169,78 @@ func reassignChannels(args string, smf *midi.SMFFile) error {
 			modifiedCount++
 		}
 	}
-	fmt.Printf("Reassigned %d/%d events from channel %d to %d.\n", modifiedCount,
-		totalCount, originalChannel, newChannel)
+	fmt.Fprintf(statusOutput, "Reassigned %d/%d events from channel %d to "+
+		"%d.\n", modifiedCount, totalCount, originalChannel, newChannel)
 	return nil
 }
 
+// Parses a semicolon-separated list of channel pairs, such as "0:1;9:10",
+// and remaps all of the channels in a single pass. Since the pairs are
+// applied simultaneously, they can be used to swap or permute channels.
+func remapChannels(args string, smf *midi.SMFFile) error {
+	mapping := make(map[uint8]uint8)
+	for _, pair := range strings.Split(args, ";") {
+		channelStrings := strings.Split(pair, ":")
+		if len(channelStrings) != 2 {
+			return fmt.Errorf("%s doesn't contain two channel numbers", pair)
+		}
+		originalChannel, e := stringToChannelNumber(channelStrings[0])
+		if e != nil {
+			return fmt.Errorf("Bad original channel number: %s", e)
+		}
+		newChannel, e := stringToChannelNumber(channelStrings[1])
+		if e != nil {
+			return fmt.Errorf("Bad new channel number: %s", e)
+		}
+		_, exists := mapping[originalChannel]
+		if exists {
+			return fmt.Errorf("Channel %d is mapped more than once",
+				originalChannel)
+		}
+		mapping[originalChannel] = newChannel
+	}
+	modifiedCount, e := smf.RemapChannels(mapping)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintf(statusOutput, "Remapped the channels of %d events.\n",
+		modifiedCount)
+	return nil
+}
+
+// Prints any structural problems in the file. Returns true if the file is
+// valid.
+func printValidationIssues(filename string, smf *midi.SMFFile) bool {
+	issues := smf.Validate()
+	if len(issues) == 0 {
+		fmt.Fprintf(statusOutput, "%s: No problems found.\n", filename)
+		return true
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(statusOutput, "%s: %s\n", filename, issue.String())
+	}
+	return false
+}
+
+// Repairs common problems in the file, and prints a summary of the changes.
+func repairFile(smf *midi.SMFFile) {
+	summary := smf.Repair()
+	if summary.IsEmpty() {
+		fmt.Fprintf(statusOutput, "No repairs were needed.\n")
+		return
+	}
+	fmt.Fprintf(statusOutput, "Repaired the file:\n")
+	fmt.Fprintf(statusOutput, "  Fixed the time deltas in %d tracks.\n",
+		summary.FixedTimeDeltas)
+	fmt.Fprintf(statusOutput, "  Dropped %d invalid events.\n",
+		summary.DroppedEvents)
+	fmt.Fprintf(statusOutput, "  Sorted the events in %d tracks.\n",
+		summary.SortedTracks)
+	fmt.Fprintf(statusOutput, "  Ended %d hanging notes.\n", summary.EndedNotes)
+	fmt.Fprintf(statusOutput, "  Added %d end-of-track events.\n",
+		summary.AddedEndOfTrack)
+}
+
 // Scales the velocity of every event in the indicated track.
 func rescaleVelocity(scale float64, track int, smf *midi.SMFFile) error {
 	if (scale < 0) || (scale >= 1) {
@@ -197,8 +264,8 @@ func rescaleVelocity(scale float64, track int, smf *midi.SMFFile) error {
 		noteOn.Velocity = newVelocity
 		modifiedCount++
 	}
-	fmt.Printf("Updated the velocity of %d note-on events in track %d\n",
-		modifiedCount, track)
+	fmt.Fprintf(statusOutput, "Updated the velocity of %d note-on events in "+
+		"track %d\n", modifiedCount, track)
 	return nil
 }
 
@@ -259,7 +326,26 @@ func getLongestTrackTicks(smf *midi.SMFFile) uint32 {
 
 // Adds an additional track with some more percussion to the SMF file. Attempts
 // to make the new track's tempo match the tempo specified in the file header.
-func addExtraBeats(smf *midi.SMFFile) error {
+// The notes are played on the given channel, or on the lowest of the file's
+// percussion channels, as reported by PercussionChannels, if it's negative.
+func addExtraBeats(smf *midi.SMFFile, channel int) error {
+	percussion := smf.PercussionChannels()
+	if channel < 0 {
+		for i, isPercussion := range percussion {
+			if isPercussion {
+				channel = i
+				break
+			}
+		}
+	}
+	if channel > 15 {
+		return fmt.Errorf("Invalid percussion channel: %d", channel)
+	}
+	if !percussion[channel] {
+		fmt.Fprintf(statusOutput, "Warning: channel %d isn't used for "+
+			"percussion in this file.\n", channel)
+	}
+	percussionChannel := uint8(channel)
 	ticksToGenerate := getLongestTrackTicks(smf)
 	// We'll make this twice as fast as the MIDI itself.
 	ticksPerBeat := uint32(smf.Division.TicksPerQuarterNote()) / 2
@@ -271,34 +357,36 @@ func addExtraBeats(smf *midi.SMFFile) error {
 	// For each beat we'll generate 1 note on event and one note-off event,
 	// plus one end-of-track event.
 	eventCount := beatsToGenerate*2 + 1
-	messages := make([]midi.MIDIMessage, 0, eventCount)
-	timeDeltas := make([]uint32, 0, eventCount)
+	newTrack := &midi.SMFTrack{
+		Messages:   make([]midi.MIDIMessage, 0, eventCount),
+		TimeDeltas: make([]uint32, 0, eventCount),
+	}
 	// This specifies the pattern of notes to play, apart from delta times.
 	onEvents := []midi.MIDIMessage{
 		&midi.NoteOnEvent{
-			// We'll rely on channel 9 being reserved for percussion, as is the
-			// case for general MIDI.
-			Channel: 9,
+			// The notes are General MIDI drum sounds, so they must be played
+			// on a percussion channel.
+			Channel: percussionChannel,
 			// This is the bass drum "note" for general MIDI percussion
 			Note: 36,
 			// Make this pretty loud
 			Velocity: 120,
 		},
 		&midi.NoteOnEvent{
-			Channel: 9,
+			Channel: percussionChannel,
 			// Closed hi-hat
 			Note: 42,
 			// Slightly quieter
 			Velocity: 80,
 		},
 		&midi.NoteOnEvent{
-			Channel: 9,
+			Channel: percussionChannel,
 			// Electric snare
 			Note:     40,
 			Velocity: 100,
 		},
 		&midi.NoteOnEvent{
-			Channel:  9,
+			Channel:  percussionChannel,
 			Note:     42,
 			Velocity: 80,
 		},
@@ -316,31 +404,205 @@ func addExtraBeats(smf *midi.SMFFile) error {
 	for i := 0; i < int(beatsToGenerate); i++ {
 		// Note-on events will always have a time delta of 0--they'll happen at
 		// the same time as the preceding note-off event.
-		timeDeltas = append(timeDeltas, 0)
-		messages = append(messages, onEvents[i%len(onEvents)])
-		timeDeltas = append(timeDeltas, ticksPerBeat)
-		messages = append(messages, offEvents[i%len(offEvents)])
+		newTrack.Append(0, onEvents[i%len(onEvents)])
+		newTrack.Append(ticksPerBeat, offEvents[i%len(offEvents)])
 	}
 	// Don't forget the end-of-track messages
-	timeDeltas = append(timeDeltas, 0)
-	messages = append(messages, midi.EndOfTrackMetaEvent(0))
+	newTrack.Append(0, midi.EndOfTrackMetaEvent(0))
 
-	// Finally, create the new track and append it to the SMF's tracks.
-	newTrack := &midi.SMFTrack{
-		Messages:   messages,
-		TimeDeltas: timeDeltas,
-	}
+	// Finally, append the new track to the SMF's tracks.
 	smf.Tracks = append(smf.Tracks, newTrack)
-	fmt.Printf("Appended track %d, with %d events.\n", len(smf.Tracks),
-		len(messages))
+	fmt.Fprintf(statusOutput, "Appended track %d, with %d events.\n",
+		len(smf.Tracks), len(newTrack.Messages))
+	return nil
+}
+
+// Removes any silence before the first note in the file, and prints how much
+// time was removed.
+func trimSilence(smf *midi.SMFFile) {
+	// Get the tempo map first, so we can convert the trimmed ticks using the
+	// file's original timing.
+	tempoMap := smf.TempoMap()
+	trimmed := smf.TrimLeadingSilence()
+	fmt.Fprintf(statusOutput, "Removed %d ticks (%f seconds) of leading "+
+		"silence.\n", trimmed, tempoMap.Seconds(trimmed))
+}
+
+// Holds a single event for the JSON output of printEvents.
+type jsonEvent struct {
+	// The track and event numbers start from 1, as in the -track and
+	// -position flags.
+	Track       int    `json:"track"`
+	Position    int    `json:"position"`
+	Tick        uint32 `json:"tick"`
+	Delta       uint32 `json:"delta"`
+	Description string `json:"description"`
+	// Always contains a "type" key, along with the fields for that type of
+	// message, e.g. "channel", "note", and "velocity" for a note-on event.
+	Message map[string]interface{} `json:"message"`
+}
+
+// Returns the fields of the given message for the JSON output. Text is
+// included as a string, and any other raw data as a hex string.
+func jsonMessageFields(m midi.MIDIMessage) map[string]interface{} {
+	f := map[string]interface{}{
+		"type": midi.KindOf(m).String(),
+	}
+	if c, ok := m.(midi.ChannelMessage); ok {
+		f["channel"] = c.GetChannel()
+	}
+	switch v := m.(type) {
+	case *midi.NoteOnEvent:
+		f["note"] = uint8(v.Note)
+		f["velocity"] = v.Velocity
+	case *midi.NoteOffEvent:
+		f["note"] = uint8(v.Note)
+		f["velocity"] = v.Velocity
+	case *midi.AftertouchEvent:
+		f["note"] = uint8(v.Note)
+		f["pressure"] = v.Pressure
+	case *midi.ControlChangeEvent:
+		f["controller"] = v.ControllerNumber
+		f["value"] = v.Value
+	case *midi.ProgramChangeEvent:
+		f["program"] = v.Value
+	case *midi.ChannelPressureEvent:
+		f["pressure"] = v.Value
+	case *midi.PitchBendEvent:
+		f["value"] = v.Value
+	case *midi.SystemExclusiveMessage:
+		f["data"] = hex.EncodeToString(v.DataBytes)
+	case midi.SequenceNumberMetaEvent:
+		f["number"] = uint16(v)
+	case *midi.TextMetaEvent:
+		f["text_type"] = v.TextEventType
+		f["text"] = v.Text()
+	case midi.ChannelPrefixMetaEvent:
+		f["channel"] = uint8(v)
+	case midi.SetTempoMetaEvent:
+		f["microseconds_per_quarter_note"] = uint32(v)
+		if v != 0 {
+			f["bpm"] = 60000000.0 / float64(v)
+		}
+	case *midi.SMPTEOffsetMetaEvent:
+		f["frame_rate"] = v.FrameRate.String()
+		f["hours"] = v.Hours
+		f["minutes"] = v.Minutes
+		f["seconds"] = v.Seconds
+		f["frames"] = v.Frames
+		f["fractional_frames"] = v.FractionalFrames
+	case *midi.TimeSignatureMetaEvent:
+		f["numerator"] = v.Numerator
+		f["denominator"] = uint32(1) << uint32(v.Denominator)
+		f["clocks_per_metronome_tick"] = v.ClocksPerMetronomeTick
+		f["notated_32nd_notes_per_quarter_note"] =
+			v.Notated32ndNotesPerQuarterNote
+	case *midi.KeySignatureMetaEvent:
+		f["sharps_or_flats"] = v.SharpOrFlatCount
+		f["minor"] = v.IsMinor
+	case *midi.GenericMetaEvent:
+		f["meta_event_type"] = v.EventType
+		f["data"] = hex.EncodeToString(v.Data)
+	case midi.UndefinedSystemEvent:
+		f["status"] = uint8(v)
+	}
+	return f
+}
+
+// Prints every event in the file to stdout as a JSON array.
+func printEventsJSON(smf *midi.SMFFile) error {
+	events := []jsonEvent{}
+	for i, t := range smf.Tracks {
+		ticks := t.AbsoluteTicks()
+		for j, m := range t.Messages {
+			events = append(events, jsonEvent{
+				Track:       i + 1,
+				Position:    j + 1,
+				Tick:        ticks[j],
+				Delta:       t.TimeDeltas[j],
+				Description: m.String(),
+				Message:     jsonMessageFields(m),
+			})
+		}
+	}
+	data, e := json.MarshalIndent(events, "", "  ")
+	if e != nil {
+		return fmt.Errorf("Failed converting events to JSON: %s", e)
+	}
+	fmt.Printf("%s\n", data)
 	return nil
 }
 
+// Prints every event in the file to stdout.
+func printEvents(smf *midi.SMFFile) {
+	for i, t := range smf.Tracks {
+		fmt.Printf("Track %d (%d events):\n", i+1, len(t.Messages))
+		for j, m := range t.Messages {
+			fmt.Printf("  %d. Time %d: %s\n", j+1, t.TimeDeltas[j], m)
+		}
+	}
+}
+
+// Prints a summary of the file's notes, channels, and timing to stdout.
+func printStats(smf *midi.SMFFile) {
+	notes := smf.Notes()
+	fmt.Fprintf(statusOutput, "Notes: %d\n", len(notes))
+	if len(notes) != 0 {
+		lowest, highest := notes[0].Pitch, notes[0].Pitch
+		for _, n := range notes {
+			if n.Pitch < lowest {
+				lowest = n.Pitch
+			}
+			if n.Pitch > highest {
+				highest = n.Pitch
+			}
+		}
+		fmt.Fprintf(statusOutput, "Pitch range: %s to %s\n", lowest, highest)
+		velocity := smf.VelocityStats()
+		fmt.Fprintf(statusOutput, "Velocity: min %d, max %d, mean %.1f\n",
+			velocity.Min, velocity.Max, velocity.Mean)
+	}
+	fmt.Fprintf(statusOutput, "Peak polyphony: %d\n", smf.MaxPolyphony())
+	events := smf.TimedEvents()
+	lastTick := uint32(0)
+	if len(events) != 0 {
+		lastTick = events[len(events)-1].Tick
+	}
+	fmt.Fprintf(statusOutput, "Duration: %d ticks (%.3f seconds)\n", lastTick,
+		smf.TempoMap().Seconds(lastTick))
+	var channelNotes [16]int
+	for _, n := range notes {
+		channelNotes[n.Channel&0xf]++
+	}
+	percussion := smf.PercussionChannels()
+	selections := smf.SoundSelections()
+	fmt.Fprintf(statusOutput, "Channels:\n")
+	for c, count := range channelNotes {
+		if count == 0 {
+			continue
+		}
+		var instruments []string
+		if percussion[c] {
+			instruments = append(instruments, "Percussion")
+		} else {
+			for _, s := range selections[uint8(c)] {
+				instruments = append(instruments,
+					gm.InstrumentName(s.Program))
+			}
+		}
+		if len(instruments) == 0 {
+			instruments = append(instruments, gm.InstrumentName(0))
+		}
+		fmt.Fprintf(statusOutput, "  %d: %d notes, %s\n", c, count,
+			strings.Join(instruments, ", "))
+	}
+}
+
 // Prints a bunch of extra per-track info to stdout.
 func printExtraInfo(smf *midi.SMFFile) error {
 	for i, t := range smf.Tracks {
-		fmt.Printf("  Track %d/%d: %d messages\n", i+1, len(smf.Tracks),
-			len(t.Messages))
+		fmt.Fprintf(statusOutput, "  Track %d/%d: %d messages\n", i+1,
+			len(smf.Tracks), len(t.Messages))
 	}
 	return nil
 }
@@ -351,11 +613,19 @@ func run() int {
 	var extraInfo bool
 	var track, position int
 	var reassignChannel string
+	var channelMap string
 	var newEventHex string
 	var deleteEvent bool
 	var newTimeDelta int
 	var scaleVelocity float64
 	var bootsAndCats bool
+	var trimLeadingSilence bool
+	var repair bool
+	var validate bool
+	var jsonOutput bool
+	var stats bool
+	var fixFormat bool
+	var percussionChannel int
 	flag.StringVar(&filename, "input_file", "", "The .mid file to open.")
 	flag.StringVar(&outputFilename, "output_file", "", "The name of the .mid "+
 		"file to create.")
@@ -379,11 +649,42 @@ func run() int {
 		"channel numbers. Any events in the channel indicated by the first "+
 		"number will be modified to happen in the second channel's number "+
 		"instead. Uses channel numbers starting from 0.")
+	flag.StringVar(&channelMap, "channel_map", "", "If provided, this must "+
+		"be a semicolon-separated list of channel pairs, such as "+
+		"\"0:1;9:10\". Events in the first channel of each pair will be "+
+		"moved to the second. The pairs are applied simultaneously, as a "+
+		"single permutation, so \"0:1;1:0\" swaps channels 0 and 1. Uses "+
+		"channel numbers starting from 0.")
 	flag.Float64Var(&scaleVelocity, "scale_velocity", -1, "If provided, "+
 		"this must be a value between 0.0 and 1.0. The velocity of every "+
 		"note-on event in the selected track will be scaled by this amount.")
 	flag.BoolVar(&bootsAndCats, "boots_and_cats", false, "If set, this adds "+
 		"an extra track to the MIDI file, for added rhythmic emphasis!")
+	flag.BoolVar(&trimLeadingSilence, "trim_silence", false, "If set, "+
+		"remove any silence before the first note in the file.")
+	flag.BoolVar(&repair, "repair", false, "If set, fix common problems in "+
+		"the file: remove events that can't be written, sort events by "+
+		"time, end notes that are never ended, and add missing end-of-track "+
+		"events. Also allows undefined status bytes when parsing the file, "+
+		"and keeps them in the output.")
+	flag.BoolVar(&validate, "validate", false, "If set, print any "+
+		"structural problems in the file, and exit with an error if any are "+
+		"found. This is done before any modifications are made.")
+	flag.BoolVar(&jsonOutput, "json", false, "If set, -dump_events prints "+
+		"the events as a JSON array, including each event's track, "+
+		"position, absolute tick, time delta, and message fields. All other "+
+		"output is printed to stderr instead of stdout.")
+	flag.BoolVar(&stats, "stats", false, "If set, print a summary of the "+
+		"file: note count, pitch and velocity ranges, peak polyphony, "+
+		"duration, and the instruments used on each channel.")
+	flag.BoolVar(&fixFormat, "fix_format", false, "If set, write the "+
+		"output file using the SMF format that best fits its tracks: 0 for "+
+		"a single track, 2 if the input was format 2, or 1 otherwise. If not "+
+		"set, the input file's format is kept when it's valid.")
+	flag.IntVar(&percussionChannel, "percussion_channel", -1, "The channel "+
+		"used for drums by -boots_and_cats. Uses channel numbers starting "+
+		"from 0. Defaults to the lowest channel that the file uses for "+
+		"percussion, which is usually channel 9.")
 	flag.BoolVar(&deleteEvent, "delete_event", false, "If set, delete the "+
 		"event at the specified track and position. No other modifications"+
 		"can be made if this is specified.")
@@ -397,28 +698,51 @@ func run() int {
 		fmt.Printf("Couldn't open %s: %s\n", filename, e)
 		return 1
 	}
-	smf, e := midi.ParseSMFFile(inputFile)
+	smf, e := midi.ParseSMFFileWithOptions(inputFile, &midi.ParseOptions{
+		AllowUndefinedSystemEvents: repair,
+	})
 	// We'll close the input file here in case the output file overwrites it.
 	inputFile.Close()
 	if e != nil {
 		fmt.Printf("Couldn't parse %s: %s\n", filename, e)
 		return 1
 	}
-	fmt.Printf("Parsed %s OK. Contains %d tracks. Time division: %s.\n",
-		filename, len(smf.Tracks), smf.Division)
+	// Keep stdout valid JSON if the events are being printed as JSON.
+	if dumpEvents && jsonOutput {
+		statusOutput = os.Stderr
+	}
+	fmt.Fprintf(statusOutput, "Parsed %s OK. Contains %d tracks. Time "+
+		"division: %s.\n", filename, len(smf.Tracks), smf.Division)
+	for _, w := range smf.Warnings {
+		fmt.Fprintf(statusOutput, "Warning: %s\n", w)
+	}
+
+	if validate {
+		if !printValidationIssues(filename, smf) {
+			return 1
+		}
+	}
+
+	if repair {
+		repairFile(smf)
+	}
 
 	if extraInfo {
 		e = printExtraInfo(smf)
 		if e != nil {
-			fmt.Printf("Failed getting extra info: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed getting extra info: %s\n", e)
 			return 1
 		}
 	}
 
+	if stats {
+		printStats(smf)
+	}
+
 	if deleteEvent {
 		e = deleteSMFEvent(track, position, smf)
 		if e != nil {
-			fmt.Printf("Failed deleting event: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed deleting event: %s\n", e)
 			return 1
 		}
 	}
@@ -426,12 +750,13 @@ func run() int {
 	// Adjust time deltas first, if requested.
 	if newTimeDelta >= 0 {
 		if deleteEvent {
-			fmt.Printf("Can't adjust time delta after deleting an event.\n")
+			fmt.Fprintf(statusOutput, "Can't adjust time delta after deleting "+
+				"an event.\n")
 			return 1
 		}
 		e = adjustTimeDelta(newTimeDelta, track, position, smf)
 		if e != nil {
-			fmt.Printf("Failed adjusting time delta: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed adjusting time delta: %s\n", e)
 			return 1
 		}
 	}
@@ -439,11 +764,12 @@ func run() int {
 	// Insert a new message if one was specified.
 	if newEventHex != "" {
 		if deleteEvent {
-			fmt.Printf("Can't add new event after deleting an event.\n")
+			fmt.Fprintf(statusOutput, "Can't add new event after deleting an "+
+				"event.\n")
 		}
 		e = insertNewEvent(newEventHex, track, position, smf)
 		if e != nil {
-			fmt.Printf("Failed inserting new event: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed inserting new event: %s\n", e)
 			return 1
 		}
 	}
@@ -452,7 +778,16 @@ func run() int {
 	if reassignChannel != "" {
 		e = reassignChannels(reassignChannel, smf)
 		if e != nil {
-			fmt.Printf("Failed reassigning channel numbers: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed reassigning channel numbers: "+
+				"%s\n", e)
+			return 1
+		}
+	}
+
+	if channelMap != "" {
+		e = remapChannels(channelMap, smf)
+		if e != nil {
+			fmt.Fprintf(statusOutput, "Failed remapping channels: %s\n", e)
 			return 1
 		}
 	}
@@ -460,48 +795,60 @@ func run() int {
 	if (scaleVelocity >= 0) && (scaleVelocity <= 1.0) {
 		e = rescaleVelocity(scaleVelocity, track, smf)
 		if e != nil {
-			fmt.Printf("Failed scaling track velocity: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed scaling track velocity: %s\n", e)
 			return 1
 		}
 	}
 
 	if bootsAndCats {
-		e = addExtraBeats(smf)
+		e = addExtraBeats(smf, percussionChannel)
 		if e != nil {
-			fmt.Printf("Failed adding extra track: %s\n", e)
+			fmt.Fprintf(statusOutput, "Failed adding extra track: %s\n", e)
 			return 1
 		}
 	}
 
+	if trimLeadingSilence {
+		trimSilence(smf)
+	}
+
 	// Dump the events after any modifications.
 	if dumpEvents {
-		for i, t := range smf.Tracks {
-			fmt.Printf("Track %d (%d events):\n", i+1, len(t.Messages))
-			for j, m := range t.Messages {
-				fmt.Printf("  %d. Time %d: %s\n", j+1, t.TimeDeltas[j], m)
+		if jsonOutput {
+			e = printEventsJSON(smf)
+			if e != nil {
+				fmt.Fprintf(statusOutput, "%s\n", e)
+				return 1
 			}
+		} else {
+			printEvents(smf)
 		}
 	}
 
 	// Finally, save the output file if one was specified.
 	if outputFilename != "" {
-		f, e := os.Create(outputFilename)
-		if e != nil {
-			fmt.Printf("Error creating output file %s: %s\n", outputFilename,
-				e)
-			return 1
+		var options []midi.WriteOption
+		if fixFormat {
+			format := smf.SuggestedFormat()
+			if format != smf.Format {
+				fmt.Fprintf(statusOutput, "Changing the file's format from "+
+					"%d to %d.\n", smf.Format, format)
+			}
+			options = append(options, midi.ForceFormat(format))
 		}
-		defer f.Close()
-		e = smf.WriteToFile(f)
+		e = writeSMFFile(smf, outputFilename, options...)
 		if e != nil {
-			fmt.Printf("Error writing SMF file: %s\n", e)
+			fmt.Fprintf(statusOutput, "%s\n", e)
 			return 1
 		}
-		fmt.Printf("%s saved OK.\n", outputFilename)
 	}
 	return 0
 }
 
 func main() {
+	// Use the subcommand interface unless the first argument is a flag.
+	if (len(os.Args) > 1) && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+	}
 	os.Exit(run())
 }
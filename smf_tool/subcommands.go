@@ -0,0 +1,331 @@
+package main
+
+// This file contains the subcommand-style interface to smf_tool, e.g.
+// "smf_tool dump in.mid". Each subcommand has its own set of flags, so
+// conflicting options can't be combined.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/yalue/midi"
+	"os"
+	"strings"
+)
+
+// Describes a single smf_tool subcommand.
+type subcommand struct {
+	name string
+	// Describes the positional arguments, e.g. "<input.mid> <output.mid>".
+	arguments   string
+	description string
+	// Runs the subcommand with the arguments following its name. Returns the
+	// process's exit code.
+	run func(args []string) int
+}
+
+// The list of subcommands, in the order they're listed in the usage message.
+// This is populated in init() since the help subcommand refers to it.
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"dump", "<input.mid>", "Print every event in the file.", runDump},
+		{"info", "<input.mid>", "Print some extra stats about each track.",
+			runInfo},
+		{"stats", "<input.mid>", "Print a summary of the file's contents.",
+			runStats},
+		{"transpose", "-n <semitones> <input.mid> <output.mid>",
+			"Transpose every note in the file.", runTranspose},
+		{"remap", "-map <pairs> <input.mid> <output.mid>",
+			"Move events between channels.", runRemap},
+		{"merge", "<input1.mid> <input2.mid> ... <output.mid>",
+			"Combine the tracks from several files into one file.", runMerge},
+		{"trim", "<input.mid> <output.mid>",
+			"Remove any silence before the first note.", runTrim},
+		{"repair", "<input.mid> <output.mid>",
+			"Fix common problems in the file.", runRepair},
+		{"validate", "<input1.mid> [<input2.mid> ...]",
+			"Print any structural problems in the files.", runValidate},
+		{"help", "", "Print this message.", runHelp},
+	}
+}
+
+// Prints the list of subcommands to stdout.
+func printSubcommandUsage() {
+	fmt.Printf("Usage: %s <subcommand> [flags] [files]\n", os.Args[0])
+	fmt.Printf("Available subcommands:\n")
+	for _, c := range subcommands {
+		fmt.Printf("  %s\n      %s\n", strings.TrimSpace(c.name+" "+
+			c.arguments), c.description)
+	}
+	fmt.Printf("Run a subcommand with -help for its flags. The older, " +
+		"flag-based interface\nis used if the first argument starts with " +
+		"'-'; run with -help for its flags.\n")
+}
+
+// Runs the subcommand with the given name, passing it the remaining
+// arguments. Returns the process's exit code.
+func runSubcommand(name string, args []string) int {
+	for _, c := range subcommands {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	fmt.Printf("Unknown subcommand: %s\n", name)
+	printSubcommandUsage()
+	return 1
+}
+
+// Parses a subcommand's flags, and checks that the expected number of
+// positional arguments remain. If maxArgs is negative, there's no upper limit
+// on the number of arguments. Returns the positional arguments, or an error
+// if the arguments are invalid.
+func parseSubcommandArgs(fs *flag.FlagSet, args []string, minArgs,
+	maxArgs int) ([]string, error) {
+	e := fs.Parse(args)
+	if e != nil {
+		return nil, e
+	}
+	remaining := fs.Args()
+	if (len(remaining) < minArgs) ||
+		((maxArgs >= 0) && (len(remaining) > maxArgs)) {
+		return nil, fmt.Errorf("Incorrect number of arguments to %s",
+			fs.Name())
+	}
+	return remaining, nil
+}
+
+// Prints an error returned by parseSubcommandArgs, and returns the exit code
+// to use. Returns 0 if the error is flag.ErrHelp, since asking for a
+// subcommand's usage with -help isn't a failure; the flag package has already
+// printed the usage in that case.
+func argumentErrorCode(e error) int {
+	if errors.Is(e, flag.ErrHelp) {
+		return 0
+	}
+	fmt.Printf("%s\n", e)
+	return 1
+}
+
+// Opens and parses the SMF file with the given name, using the given parsing
+// options, which may be nil.
+func readSMFFile(filename string, options *midi.ParseOptions) (*midi.SMFFile,
+	error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't open %s: %s", filename, e)
+	}
+	defer f.Close()
+	smf, e := midi.ParseSMFFileWithOptions(f, options)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %s", filename, e)
+	}
+	for _, w := range smf.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", filename, w)
+	}
+	return smf, nil
+}
+
+// Writes the SMF file to a file with the given name using the given options,
+// replacing the file if it already exists.
+func writeSMFFile(smf *midi.SMFFile, filename string,
+	options ...midi.WriteOption) error {
+	e := smf.Save(filename, options...)
+	if e != nil {
+		return fmt.Errorf("Error writing %s: %s", filename, e)
+	}
+	fmt.Fprintf(statusOutput, "%s saved OK.\n", filename)
+	return nil
+}
+
+// Implements subcommands that read a single input file, modify it using the
+// given function, and write it to an output file. The fs must have already
+// been configured with any flags used by modify. The parsing options may be
+// nil.
+func runModification(fs *flag.FlagSet, args []string,
+	options *midi.ParseOptions, modify func(smf *midi.SMFFile) error) int {
+	files, e := parseSubcommandArgs(fs, args, 2, 2)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	smf, e := readSMFFile(files[0], options)
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	e = modify(smf)
+	if e != nil {
+		fmt.Printf("Failed running %s: %s\n", fs.Name(), e)
+		return 1
+	}
+	e = writeSMFFile(smf, files[1])
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	return 0
+}
+
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "If set, print the events as a "+
+		"JSON array.")
+	files, e := parseSubcommandArgs(fs, args, 1, 1)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	smf, e := readSMFFile(files[0], nil)
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	if *jsonOutput {
+		e = printEventsJSON(smf)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return 1
+		}
+		return 0
+	}
+	printEvents(smf)
+	return 0
+}
+
+func runInfo(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	files, e := parseSubcommandArgs(fs, args, 1, 1)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	smf, e := readSMFFile(files[0], nil)
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	e = printExtraInfo(smf)
+	if e != nil {
+		fmt.Printf("Failed getting extra info: %s\n", e)
+		return 1
+	}
+	return 0
+}
+
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	files, e := parseSubcommandArgs(fs, args, 1, 1)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	smf, e := readSMFFile(files[0], nil)
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	printStats(smf)
+	return 0
+}
+
+func runTranspose(args []string) int {
+	fs := flag.NewFlagSet("transpose", flag.ContinueOnError)
+	semitones := fs.Int("n", 0, "The number of semitones to move each "+
+		"note. May be negative.")
+	includePercussion := fs.Bool("include_percussion", false, "If set, "+
+		"also transpose notes on percussion channels.")
+	return runModification(fs, args, nil, func(smf *midi.SMFFile) error {
+		count, e := smf.Transpose(*semitones, *includePercussion)
+		if e != nil {
+			return e
+		}
+		fmt.Printf("Transposed %d events by %d semitones.\n", count,
+			*semitones)
+		return nil
+	})
+}
+
+func runRemap(args []string) int {
+	fs := flag.NewFlagSet("remap", flag.ContinueOnError)
+	mapping := fs.String("map", "", "A semicolon-separated list of channel "+
+		"pairs, such as \"0:1;9:10\". The pairs are applied simultaneously, "+
+		"so \"0:1;1:0\" swaps channels 0 and 1.")
+	return runModification(fs, args, nil, func(smf *midi.SMFFile) error {
+		if *mapping == "" {
+			return fmt.Errorf("The -map flag is required")
+		}
+		return remapChannels(*mapping, smf)
+	})
+}
+
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	files, e := parseSubcommandArgs(fs, args, 3, -1)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	inputs := make([]*midi.SMFFile, len(files)-1)
+	for i := range inputs {
+		inputs[i], e = readSMFFile(files[i], nil)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return 1
+		}
+	}
+	smf, e := midi.CombineFiles(inputs...)
+	if e != nil {
+		fmt.Printf("Failed combining files: %s\n", e)
+		return 1
+	}
+	e = writeSMFFile(smf, files[len(files)-1])
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	return 0
+}
+
+func runTrim(args []string) int {
+	fs := flag.NewFlagSet("trim", flag.ContinueOnError)
+	return runModification(fs, args, nil, func(smf *midi.SMFFile) error {
+		trimSilence(smf)
+		return nil
+	})
+}
+
+func runRepair(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	options := &midi.ParseOptions{
+		AllowUndefinedSystemEvents: true,
+	}
+	return runModification(fs, args, options, func(smf *midi.SMFFile) error {
+		repairFile(smf)
+		return nil
+	})
+}
+
+// Validates each of the given files, and returns an error exit code if any
+// of them can't be parsed or contain problems.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	files, e := parseSubcommandArgs(fs, args, 1, -1)
+	if e != nil {
+		return argumentErrorCode(e)
+	}
+	toReturn := 0
+	for _, filename := range files {
+		smf, e := readSMFFile(filename, nil)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			toReturn = 1
+			continue
+		}
+		if !printValidationIssues(filename, smf) {
+			toReturn = 1
+		}
+	}
+	return toReturn
+}
+
+func runHelp(args []string) int {
+	printSubcommandUsage()
+	return 0
+}
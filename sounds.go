@@ -0,0 +1,172 @@
+package midi
+
+// This file contains code for working with the sounds (instruments) selected
+// on each channel using bank-select control changes and program changes.
+
+// Identifies a sound selected by a program change, along with the bank that
+// was selected when the program change occurred.
+type SoundSelection struct {
+	// The 14-bit bank number, combining the most significant 7 bits from
+	// controller 0 with the least significant 7 bits from controller 32.
+	Bank    uint16
+	Program uint8
+}
+
+// Holds a SoundSelection, along with the time and location of the program
+// change that made it.
+type TimedSoundSelection struct {
+	SoundSelection
+	// The absolute time of the program change, in ticks.
+	Tick uint32
+	// The index of the track containing the program change.
+	Track int
+}
+
+// Returns every sound selected by a program change in the file, grouped by
+// channel and sorted by time. Each program change is combined with the most
+// recent bank-select control changes (controllers 0 and 32) on its channel,
+// from any track. Channels start out with bank 0 selected. Channels without
+// any program changes aren't included in the returned map.
+func (f *SMFFile) SoundSelections() map[uint8][]TimedSoundSelection {
+	toReturn := make(map[uint8][]TimedSoundSelection)
+	var bankMSB, bankLSB [16]uint8
+	for _, event := range f.TimedEvents() {
+		switch v := event.Message.(type) {
+		case *ControlChangeEvent:
+			if v.ControllerNumber == 0 {
+				bankMSB[v.Channel&0xf] = v.Value & 0x7f
+			} else if v.ControllerNumber == 32 {
+				bankLSB[v.Channel&0xf] = v.Value & 0x7f
+			}
+		case *ProgramChangeEvent:
+			channel := v.Channel & 0xf
+			toReturn[channel] = append(toReturn[channel],
+				TimedSoundSelection{
+					SoundSelection: SoundSelection{
+						Bank: (uint16(bankMSB[channel]) << 7) |
+							uint16(bankLSB[channel]),
+						Program: v.Value,
+					},
+					Tick:  event.Tick,
+					Track: event.Track,
+				})
+		}
+	}
+	return toReturn
+}
+
+// Returns true if m is a bank-select control change or program change on the
+// given channel.
+func isSoundSelectionEvent(m MIDIMessage, channel uint8) bool {
+	switch v := m.(type) {
+	case *ControlChangeEvent:
+		return (v.Channel == channel) &&
+			((v.ControllerNumber == 0) || (v.ControllerNumber == 32))
+	case *ProgramChangeEvent:
+		return v.Channel == channel
+	}
+	return false
+}
+
+// Selects the given bank and program on the given channel at the start of the
+// track, by inserting bank-select control changes (controllers 0 and 32)
+// followed by a program change. Any existing bank-select or program change
+// events for the channel at tick 0 are removed. The new events are inserted
+// after any meta-events or sysex messages at the start of the track, but
+// before any other channel messages. The channel is masked to 4 bits, the
+// bank to 14 bits, and the program to 7 bits.
+func (t *SMFTrack) SetBankAndProgram(channel uint8, bank uint16,
+	program uint8) {
+	channel &= 0xf
+	events := t.timedMessages()
+	removed := make(map[int]bool)
+	position := len(events)
+	for i, v := range events {
+		if v.tick != 0 {
+			if i < position {
+				position = i
+			}
+			break
+		}
+		if isSoundSelectionEvent(v.message, channel) {
+			removed[i] = true
+			continue
+		}
+		_, isChannelMessage := v.message.(ChannelMessage)
+		_, isEnd := v.message.(EndOfTrackMetaEvent)
+		if (isChannelMessage || isEnd) && (i < position) {
+			position = i
+		}
+	}
+	msb, lsb := bankSelectEvents(channel, bank)
+	newEvents := []MIDIMessage{msb, lsb, &ProgramChangeEvent{
+		Channel: channel,
+		Value:   program & 0x7f,
+	}}
+	inserted := make([]insertedEvent, len(newEvents))
+	for i, m := range newEvents {
+		inserted[i] = insertedEvent{
+			index: position,
+			event: timedMessage{
+				tick:    0,
+				message: m,
+			},
+		}
+	}
+	t.insertAndRemove(inserted, removed)
+}
+
+// Returns true if the event is a control change for the given controller at
+// tick 0.
+func isControllerAtStart(event timedMessage, controller uint8) bool {
+	if event.tick != 0 {
+		return false
+	}
+	v, ok := event.message.(*ControlChangeEvent)
+	return ok && (v.ControllerNumber == controller)
+}
+
+// Inserts "reset all controllers" (controller 121) and "all notes off"
+// (controller 123) control changes at the start of each track, before any
+// other events, for every channel used by a channel message in the track.
+// Any existing reset-all-controllers or all-notes-off events at tick 0 in a
+// track are removed first, so calling this more than once has the same effect
+// as calling it once.
+func (f *SMFFile) PrependControllerReset() {
+	for _, t := range f.Tracks {
+		var used [16]bool
+		for _, m := range t.Messages {
+			v, ok := m.(ChannelMessage)
+			if ok {
+				used[v.GetChannel()&0xf] = true
+			}
+		}
+		events := t.timedMessages()
+		newEvents := make([]timedMessage, 0, len(events)+32)
+		for channel, isUsed := range used {
+			if !isUsed {
+				continue
+			}
+			for _, controller := range []uint8{121, 123} {
+				newEvents = append(newEvents, timedMessage{
+					tick: 0,
+					message: &ControlChangeEvent{
+						Channel:          uint8(channel),
+						ControllerNumber: controller,
+						Value:            0,
+					},
+				})
+			}
+		}
+		if len(newEvents) == 0 {
+			continue
+		}
+		for _, v := range events {
+			if isControllerAtStart(v, 121) || isControllerAtStart(v, 123) {
+				continue
+			}
+			newEvents = append(newEvents, v)
+		}
+		t.setTimedMessages(newEvents)
+	}
+}
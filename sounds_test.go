@@ -0,0 +1,130 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestSoundSelections(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&ControlChangeEvent{Channel: 3, ControllerNumber: 0,
+						Value: 1},
+					&ControlChangeEvent{Channel: 3, ControllerNumber: 32,
+						Value: 2},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&ProgramChangeEvent{Channel: 3, Value: 10},
+					&ProgramChangeEvent{Channel: 4, Value: 11},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{5, 5, 0},
+			},
+		},
+	}
+	selections := smf.SoundSelections()
+	if len(selections) != 2 {
+		t.Logf("Expected selections for 2 channels, got %d\n",
+			len(selections))
+		t.FailNow()
+	}
+	s := selections[3]
+	if (len(s) != 1) || (s[0].Bank != 130) || (s[0].Program != 10) ||
+		(s[0].Tick != 5) || (s[0].Track != 1) {
+		t.Logf("Got incorrect selection for channel 3\n")
+		t.FailNow()
+	}
+	s = selections[4]
+	if (len(s) != 1) || (s[0].Bank != 0) || (s[0].Program != 11) ||
+		(s[0].Tick != 10) {
+		t.Logf("Got incorrect selection for channel 4\n")
+		t.FailNow()
+	}
+}
+
+func TestSetBankAndProgram(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&TextMetaEvent{TextEventType: 3, Data: []byte("Piano")},
+			&ProgramChangeEvent{Channel: 2, Value: 7},
+			&ProgramChangeEvent{Channel: 5, Value: 7},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 0, 10, 0},
+	}
+	track.SetBankAndProgram(2, 0x85, 9)
+	if len(track.Messages) != 8 {
+		t.Logf("Expected 8 events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	if _, ok := track.Messages[0].(*TextMetaEvent); !ok {
+		t.Logf("The track name wasn't kept at the start of the track\n")
+		t.FailNow()
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	selections := smf.SoundSelections()
+	s := selections[2]
+	if (len(s) != 1) || (s[0].Bank != 0x85) || (s[0].Program != 9) {
+		t.Logf("Got incorrect sound selection for channel 2: %+v\n", s)
+		t.FailNow()
+	}
+	if len(selections[5]) != 1 {
+		t.Logf("The program change on channel 5 wasn't preserved\n")
+		t.FailNow()
+	}
+}
+
+func TestPrependControllerReset(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&TextMetaEvent{TextEventType: 3, Data: []byte("Piano")},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 0},
+			&ProgramChangeEvent{Channel: 5, Value: 7},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 0, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	smf.PrependControllerReset()
+	if len(track.Messages) != 9 {
+		t.Logf("Expected 9 events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	expected := []struct {
+		channel    uint8
+		controller uint8
+	}{{2, 121}, {2, 123}, {5, 121}, {5, 123}}
+	for i, e := range expected {
+		v, ok := track.Messages[i].(*ControlChangeEvent)
+		if !ok || (v.Channel != e.channel) ||
+			(v.ControllerNumber != e.controller) || (track.TimeDeltas[i] != 0) {
+			t.Logf("Got incorrect event %d: %s\n", i, track.Messages[i])
+			t.FailNow()
+		}
+	}
+	if track.TimeDeltas[5] != 10 {
+		t.Logf("The first note's time was changed\n")
+		t.FailNow()
+	}
+	smf.PrependControllerReset()
+	if len(track.Messages) != 9 {
+		t.Logf("Expected 9 events after a second reset, got %d\n",
+			len(track.Messages))
+		t.FailNow()
+	}
+}
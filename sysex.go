@@ -0,0 +1,159 @@
+package midi
+
+// This file contains code for identifying and describing common
+// system-exclusive messages.
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Maps single-byte sysex manufacturer IDs to manufacturer names.
+var sysexManufacturerNames = map[uint8]string{
+	0x01: "Sequential",
+	0x04: "Moog",
+	0x06: "Lexicon",
+	0x07: "Kurzweil",
+	0x0f: "Ensoniq",
+	0x10: "Oberheim",
+	0x18: "E-mu",
+	0x40: "Kawai",
+	0x41: "Roland",
+	0x42: "Korg",
+	0x43: "Yamaha",
+	0x44: "Casio",
+	0x47: "Akai",
+	0x7d: "non-commercial",
+	0x7e: "universal non-real-time",
+	0x7f: "universal real-time",
+}
+
+// Used as a key when looking up the names of universal sysex messages.
+type universalSysexID struct {
+	// Either 0x7e (non-real-time) or 0x7f (real-time).
+	manufacturer uint8
+	subID1       uint8
+	subID2       uint8
+}
+
+// Maps the IDs of common universal sysex messages to their names.
+var universalSysexNames = map[universalSysexID]string{
+	{0x7e, 0x06, 0x01}: "identity request",
+	{0x7e, 0x06, 0x02}: "identity reply",
+	{0x7e, 0x09, 0x01}: "GM system on",
+	{0x7e, 0x09, 0x02}: "GM system off",
+	{0x7e, 0x09, 0x03}: "GM2 system on",
+	{0x7f, 0x01, 0x01}: "MTC full message",
+	{0x7f, 0x04, 0x01}: "master volume",
+	{0x7f, 0x04, 0x02}: "master balance",
+	{0x7f, 0x04, 0x03}: "master fine tuning",
+	{0x7f, 0x04, 0x04}: "master coarse tuning",
+}
+
+// The data for common reset messages, not including the leading 0xf0 or
+// trailing 0xf7.
+var (
+	gsResetData = []byte{0x41, 0x10, 0x42, 0x12, 0x40, 0x00, 0x7f, 0x00,
+		0x41}
+	xgSystemOnData  = []byte{0x43, 0x10, 0x4c, 0x00, 0x00, 0x7e, 0x00}
+	gmSystemOnData  = []byte{0x7e, 0x7f, 0x09, 0x01}
+	gmSystemOffData = []byte{0x7e, 0x7f, 0x09, 0x02}
+)
+
+// Returns a new sysex message containing a copy of the given data.
+func newSysex(data []byte) *SystemExclusiveMessage {
+	return &SystemExclusiveMessage{
+		DataBytes: append([]byte{}, data...),
+	}
+}
+
+// Returns a new General MIDI System On message, which resets a GM-compatible
+// device to its default GM settings. Files often contain this at the start.
+func GMSystemOn() *SystemExclusiveMessage {
+	return newSysex(gmSystemOnData)
+}
+
+// Returns a new General MIDI System Off message.
+func GMSystemOff() *SystemExclusiveMessage {
+	return newSysex(gmSystemOffData)
+}
+
+// Returns a new Roland GS Reset message, which resets a GS-compatible device
+// to its default GS settings.
+func GSReset() *SystemExclusiveMessage {
+	return newSysex(gsResetData)
+}
+
+// Returns a new Yamaha XG System On message, which resets an XG-compatible
+// device to its default XG settings.
+func XGSystemOn() *SystemExclusiveMessage {
+	return newSysex(xgSystemOnData)
+}
+
+// The maximum value of a 14-bit number, such as the master volume.
+const max14BitValue = 0x3fff
+
+// Returns a new universal real-time Master Volume message, addressed to all
+// devices. The volume is a 14-bit value, so volumes over 0x3fff are treated as
+// 0x3fff.
+func MasterVolumeSysEx(volume uint16) *SystemExclusiveMessage {
+	if volume > max14BitValue {
+		volume = max14BitValue
+	}
+	return &SystemExclusiveMessage{
+		DataBytes: []byte{0x7f, 0x7f, 0x04, 0x01, uint8(volume & 0x7f),
+			uint8(volume >> 7)},
+	}
+}
+
+// If the message is a universal real-time Master Volume message (addressed to
+// any device), this returns the 14-bit volume it sets, and true. Returns 0 and
+// false otherwise.
+func (m *SystemExclusiveMessage) MasterVolume() (uint16, bool) {
+	data := m.DataBytes
+	if (len(data) != 6) || (data[0] != 0x7f) || (data[2] != 0x04) ||
+		(data[3] != 0x01) {
+		return 0, false
+	}
+	if (data[4] > 0x7f) || (data[5] > 0x7f) {
+		return 0, false
+	}
+	return uint16(data[4]) | (uint16(data[5]) << 7), true
+}
+
+// Returns a short description of the sysex message's contents, based on its
+// manufacturer ID and, for universal messages, its sub-IDs. Returns an empty
+// string if the manufacturer isn't recognized.
+func (m *SystemExclusiveMessage) describe() string {
+	data := m.DataBytes
+	if len(data) == 0 {
+		return ""
+	}
+	if bytes.Equal(data, gsResetData) {
+		return "Roland: GS reset"
+	}
+	if bytes.Equal(data, xgSystemOnData) {
+		return "Yamaha: XG system on"
+	}
+	if data[0] == 0 {
+		// Three-byte manufacturer IDs start with a 0.
+		if len(data) < 3 {
+			return ""
+		}
+		return fmt.Sprintf("manufacturer 00 %02x %02x", data[1], data[2])
+	}
+	name, ok := sysexManufacturerNames[data[0]]
+	if !ok {
+		return ""
+	}
+	if ((data[0] != 0x7e) && (data[0] != 0x7f)) || (len(data) < 4) {
+		return name
+	}
+	// Universal messages contain a device ID followed by two sub-IDs.
+	messageName, ok := universalSysexNames[universalSysexID{data[0],
+		data[2], data[3]}]
+	if !ok {
+		return fmt.Sprintf("%s, sub-IDs %02x %02x", name, data[2], data[3])
+	}
+	return fmt.Sprintf("%s: %s", name, messageName)
+}
@@ -0,0 +1,86 @@
+package midi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSysexDescriptions(t *testing.T) {
+	tests := map[string][]byte{
+		"universal non-real-time: GM system on": {0x7e, 0x7f, 0x09, 0x01},
+		"universal real-time: master volume": {0x7f, 0x7f, 0x04, 0x01,
+			0x00, 0x40},
+		"Roland":                             {0x41, 0x10, 0x42, 0x12},
+		"manufacturer 00 20 33":              {0x00, 0x20, 0x33, 0x01},
+		"universal real-time, sub-IDs 06 01": {0x7f, 0x7f, 0x06, 0x01},
+	}
+	for expected, data := range tests {
+		m := &SystemExclusiveMessage{DataBytes: data}
+		s := m.String()
+		t.Logf("%s\n", s)
+		if !strings.Contains(s, "("+expected+")") {
+			t.Logf("Expected the description to contain %q\n", expected)
+			t.FailNow()
+		}
+	}
+	m := &SystemExclusiveMessage{DataBytes: []byte{0x55, 0x01}}
+	if strings.Contains(m.String(), "(") {
+		t.Logf("Got a description for an unknown manufacturer: %s\n", m)
+		t.FailNow()
+	}
+}
+
+func TestResetSysexConstructors(t *testing.T) {
+	expected := map[string]*SystemExclusiveMessage{
+		"f0 7e 7f 09 01 f7":                GMSystemOn(),
+		"f0 7e 7f 09 02 f7":                GMSystemOff(),
+		"f0 41 10 42 12 40 00 7f 00 41 f7": GSReset(),
+		"f0 43 10 4c 00 00 7e 00 f7":       XGSystemOn(),
+	}
+	for hexData, m := range expected {
+		runningStatus := byte(0)
+		data, e := m.SMFData(&runningStatus)
+		if e != nil {
+			t.Logf("Failed encoding %s: %s\n", m, e)
+			t.FailNow()
+		}
+		// Remove the length byte following the 0xf0.
+		data = append(data[:1:1], data[2:]...)
+		if fmt.Sprintf("% x", data) != hexData {
+			t.Logf("Expected %s for %s, got % x\n", hexData, m, data)
+			t.FailNow()
+		}
+		t.Logf("%s\n", m)
+	}
+	// Make sure the constructors return independent copies.
+	GSReset().DataBytes[0] = 0
+	if GSReset().DataBytes[0] != 0x41 {
+		t.Logf("Modifying a GS reset message changed later ones\n")
+		t.FailNow()
+	}
+}
+
+func TestMasterVolume(t *testing.T) {
+	m := MasterVolumeSysEx(0x1234)
+	t.Logf("%s\n", m)
+	volume, ok := m.MasterVolume()
+	if !ok || (volume != 0x1234) {
+		t.Logf("Failed decoding master volume: got 0x%x, %v\n", volume, ok)
+		t.FailNow()
+	}
+	if (m.DataBytes[4] != 0x34) || (m.DataBytes[5] != 0x24) {
+		t.Logf("Got incorrect master volume bytes: % x\n", m.DataBytes)
+		t.FailNow()
+	}
+	volume, _ = MasterVolumeSysEx(0xffff).MasterVolume()
+	if volume != 0x3fff {
+		t.Logf("The master volume wasn't limited to 14 bits: 0x%x\n", volume)
+		t.FailNow()
+	}
+	_, ok = GMSystemOn().MasterVolume()
+	if ok {
+		t.Logf("Decoded a master volume from a GM system on message\n")
+		t.FailNow()
+	}
+}
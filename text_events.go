@@ -0,0 +1,224 @@
+package midi
+
+// This file contains functions for extracting text meta-events, such as lyrics,
+// from SMF files.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Holds the text from any text meta-event, along with its type and the time at
+// which it occurs.
+type TimedText struct {
+	// The absolute time of the event, in ticks.
+	Tick uint32
+	// The time of the event, in seconds from the start of the file.
+	Seconds float64
+	// The index of the track containing the event.
+	Track int
+	// The text event type, between 0x01 and 0x0f, e.g. 0x05 for lyrics or
+	// 0x03 for a track name.
+	TextEventType uint8
+	Text          string
+}
+
+// Holds a lyric returned by Lyrics or LyricsWithText. This is the same as
+// TimedText; its TextEventType will be 0x05 for lyrics, or 0x01 for generic
+// text.
+type TimedLyric = TimedText
+
+// Returns all text meta-events in the file with one of the given types, sorted
+// by time. Returns text meta-events of every type if no types are given.
+func (f *SMFFile) timedText(eventTypes ...uint8) []TimedText {
+	var toReturn []TimedText
+	var tempoMap *TempoMap
+	for _, event := range f.TimedEvents() {
+		text, ok := event.Message.(*TextMetaEvent)
+		if !ok {
+			continue
+		}
+		matches := len(eventTypes) == 0
+		for _, eventType := range eventTypes {
+			if text.TextEventType == eventType {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		// Only build the tempo map if we actually find some text.
+		if tempoMap == nil {
+			tempoMap = f.TempoMap()
+		}
+		toReturn = append(toReturn, TimedText{
+			Tick:          event.Tick,
+			Seconds:       tempoMap.Seconds(event.Tick),
+			Track:         event.Track,
+			TextEventType: text.TextEventType,
+			Text:          string(text.Data),
+		})
+	}
+	return toReturn
+}
+
+// Returns the lyric meta-events (text type 0x05) from all tracks in the file,
+// sorted by time.
+func (f *SMFFile) Lyrics() []TimedLyric {
+	return f.timedText(0x05)
+}
+
+// Like Lyrics(), but also includes generic text meta-events (type 0x01), which
+// some karaoke files use to hold lyrics instead.
+func (f *SMFFile) LyricsWithText() []TimedLyric {
+	return f.timedText(0x01, 0x05)
+}
+
+// Formats a time in seconds as an .lrc timestamp: [mm:ss.xx]
+func formatLRCTimestamp(seconds float64) string {
+	hundredths := int64(seconds*100.0 + 0.5)
+	minutes := hundredths / 6000
+	hundredths -= minutes * 6000
+	return fmt.Sprintf("[%02d:%02d.%02d]", minutes, hundredths/100,
+		hundredths%100)
+}
+
+// Writes the file's lyrics to w in the .lrc format, with one timestamped line
+// of text per line of lyrics. Uses the lyric meta-events if there are any, or
+// generic text events otherwise (as in .kar files). Following the usual
+// karaoke conventions, a syllable starting with '/' or '\' starts a new line,
+// as does a syllable following one that ends with a newline. Text events
+// starting with '@' are considered karaoke file information rather than
+// lyrics, and are skipped.
+func (f *SMFFile) WriteLRC(w io.Writer) error {
+	lyrics := f.Lyrics()
+	if len(lyrics) == 0 {
+		lyrics = f.timedText(0x01)
+	}
+	var line strings.Builder
+	lineStart := 0.0
+	flushLine := func() error {
+		text := strings.TrimSpace(line.String())
+		line.Reset()
+		if text == "" {
+			return nil
+		}
+		_, e := fmt.Fprintf(w, "%s%s\n", formatLRCTimestamp(lineStart), text)
+		return e
+	}
+	for _, lyric := range lyrics {
+		text := lyric.Text
+		if strings.HasPrefix(text, "@") {
+			continue
+		}
+		if strings.HasPrefix(text, "/") || strings.HasPrefix(text, "\\") {
+			e := flushLine()
+			if e != nil {
+				return fmt.Errorf("Failed writing lyric line: %s", e)
+			}
+			text = text[1:]
+		}
+		endsLine := strings.HasSuffix(text, "\n") ||
+			strings.HasSuffix(text, "\r")
+		text = strings.TrimRight(text, "\r\n")
+		if line.Len() == 0 {
+			lineStart = lyric.Seconds
+		}
+		line.WriteString(text)
+		if endsLine {
+			e := flushLine()
+			if e != nil {
+				return fmt.Errorf("Failed writing lyric line: %s", e)
+			}
+		}
+	}
+	e := flushLine()
+	if e != nil {
+		return fmt.Errorf("Failed writing lyric line: %s", e)
+	}
+	return nil
+}
+
+// Holds the text from a marker or cue-point meta-event, along with the time at
+// which it occurs.
+type TimedMarker struct {
+	// The absolute time of the event, in ticks.
+	Tick uint32
+	// The time of the event, in seconds from the start of the file.
+	Seconds float64
+	// The index of the track containing the event.
+	Track int
+	Text  string
+}
+
+// Returns all text meta-events of the given type as TimedMarkers, sorted by
+// time.
+func (f *SMFFile) timedMarkers(eventType uint8) []TimedMarker {
+	text := f.timedText(eventType)
+	toReturn := make([]TimedMarker, len(text))
+	for i, v := range text {
+		toReturn[i] = TimedMarker{
+			Tick:    v.Tick,
+			Seconds: v.Seconds,
+			Track:   v.Track,
+			Text:    v.Text,
+		}
+	}
+	return toReturn
+}
+
+// Returns the marker meta-events (text type 0x06) from all tracks in the file,
+// sorted by time. Markers are typically used to name sections of a song.
+func (f *SMFFile) Markers() []TimedMarker {
+	return f.timedMarkers(0x06)
+}
+
+// Returns the cue-point meta-events (text type 0x07) from all tracks in the
+// file, sorted by time. Cue points are typically used to synchronize with
+// events in a film or video.
+func (f *SMFFile) CuePoints() []TimedMarker {
+	return f.timedMarkers(0x07)
+}
+
+// Returns the text of the track's first sequence/track name meta-event (text
+// type 0x03), or an empty string if the track doesn't have one.
+func (t *SMFTrack) Name() string {
+	for _, m := range t.Messages {
+		text, ok := m.(*TextMetaEvent)
+		if ok && (text.TextEventType == 0x03) {
+			return string(text.Data)
+		}
+	}
+	return ""
+}
+
+// Returns the name of each track in the file, as returned by SMFTrack.Name().
+// The returned slice contains one entry per track, which is an empty string if
+// the track doesn't have a name.
+func (f *SMFFile) TrackNames() []string {
+	toReturn := make([]string, len(f.Tracks))
+	for i, t := range f.Tracks {
+		toReturn[i] = t.Name()
+	}
+	return toReturn
+}
+
+// Returns every text meta-event in the file, of any type, sorted by time.
+// Simultaneous events are ordered as in TimedEvents.
+func (f *SMFFile) AllText() []TimedText {
+	return f.timedText()
+}
+
+// Returns the text of the file's first copyright notice meta-event (text type
+// 0x02), or an empty string if the file doesn't contain one.
+func (f *SMFFile) Copyright() string {
+	for _, event := range f.TimedEvents() {
+		text, ok := event.Message.(*TextMetaEvent)
+		if ok && (text.TextEventType == 0x02) {
+			return string(text.Data)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package midi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrackNames(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x01, Data: []byte("x")},
+					&TextMetaEvent{TextEventType: 0x03, Data: []byte("Piano")},
+					&TextMetaEvent{TextEventType: 0x03, Data: []byte("Other")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 0, 0},
+			},
+			{
+				Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{0},
+			},
+		},
+	}
+	names := smf.TrackNames()
+	if (len(names) != 2) || (names[0] != "Piano") || (names[1] != "") {
+		t.Logf("Got incorrect track names: %q\n", names)
+		t.FailNow()
+	}
+}
+
+func TestAllText(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x03, Data: []byte("Song")},
+					&TextMetaEvent{TextEventType: 0x06, Data: []byte("Verse")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x02, Data: []byte("(c) Me")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{48, 0},
+			},
+		},
+	}
+	if smf.Copyright() != "(c) Me" {
+		t.Logf("Got incorrect copyright: %s\n", smf.Copyright())
+		t.FailNow()
+	}
+	text := smf.AllText()
+	if len(text) != 3 {
+		t.Logf("Expected 3 text events, got %d\n", len(text))
+		t.FailNow()
+	}
+	if (text[1].Track != 1) || (text[1].TextEventType != 0x02) ||
+		(text[2].Text != "Verse") || (text[2].Seconds != 0.5) {
+		t.Logf("Got incorrect text events\n")
+		t.FailNow()
+	}
+	smf.Tracks = smf.Tracks[:1]
+	if smf.Copyright() != "" {
+		t.Logf("Expected an empty copyright when there isn't one\n")
+		t.FailNow()
+	}
+}
+
+func TestLyrics(t *testing.T) {
+	// The tempo switches to 60 BPM at tick 96, so the lyric at tick 192 is at
+	// 1.5 seconds.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x05, Data: []byte("Hel")},
+					&TextMetaEvent{TextEventType: 0x01, Data: []byte("text")},
+					&TextMetaEvent{TextEventType: 0x05, Data: []byte("lo")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{48, 0, 144, 0},
+			},
+		},
+	}
+	lyrics := smf.Lyrics()
+	if len(lyrics) != 2 {
+		t.Logf("Expected 2 lyrics, got %d\n", len(lyrics))
+		t.FailNow()
+	}
+	if (lyrics[0].Text != "Hel") || (lyrics[0].Tick != 48) ||
+		(lyrics[0].Seconds != 0.25) || (lyrics[0].Track != 1) {
+		t.Logf("Got incorrect first lyric: %+v\n", lyrics[0])
+		t.FailNow()
+	}
+	if (lyrics[1].Text != "lo") || (lyrics[1].Tick != 192) ||
+		(lyrics[1].Seconds != 1.5) {
+		t.Logf("Got incorrect second lyric: %+v\n", lyrics[1])
+		t.FailNow()
+	}
+	lyrics = smf.LyricsWithText()
+	if (len(lyrics) != 3) || (lyrics[1].TextEventType != 0x01) {
+		t.Logf("LyricsWithText didn't include the generic text event\n")
+		t.FailNow()
+	}
+}
+
+func TestWriteLRC(t *testing.T) {
+	// At the default 120 BPM with 96 ticks per quarter note, each tick is
+	// 1/192 of a second.
+	track := &SMFTrack{}
+	track.Append(0, &TextMetaEvent{TextEventType: 0x01,
+		Data: []byte("@TTitle")})
+	track.Append(96, &TextMetaEvent{TextEventType: 0x01, Data: []byte("Twin")})
+	track.Append(48, &TextMetaEvent{TextEventType: 0x01, Data: []byte("kle ")})
+	track.Append(48, &TextMetaEvent{TextEventType: 0x01,
+		Data: []byte("twinkle\n")})
+	track.Append(96, &TextMetaEvent{TextEventType: 0x01, Data: []byte("Lit")})
+	track.Append(96, &TextMetaEvent{TextEventType: 0x01, Data: []byte("tle ")})
+	track.Append(11520, &TextMetaEvent{TextEventType: 0x01,
+		Data: []byte("/star")})
+	track.Append(0, EndOfTrackMetaEvent(0))
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	var output strings.Builder
+	e := smf.WriteLRC(&output)
+	if e != nil {
+		t.Logf("Failed writing .lrc: %s\n", e)
+		t.FailNow()
+	}
+	expected := "[00:00.50]Twinkle twinkle\n[00:01.50]Little\n" +
+		"[01:02.00]star\n"
+	if output.String() != expected {
+		t.Logf("Got incorrect .lrc output:\n%s\nExpected:\n%s\n",
+			output.String(), expected)
+		t.FailNow()
+	}
+
+	// Lyric events take priority over generic text events.
+	track.Messages[1] = &TextMetaEvent{TextEventType: 0x05,
+		Data: []byte("Only")}
+	output.Reset()
+	e = smf.WriteLRC(&output)
+	if e != nil {
+		t.Logf("Failed writing .lrc: %s\n", e)
+		t.FailNow()
+	}
+	if output.String() != "[00:00.50]Only\n" {
+		t.Logf("Got incorrect .lrc output with lyrics: %q\n",
+			output.String())
+		t.FailNow()
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x06, Data: []byte("Intro")},
+					&TextMetaEvent{TextEventType: 0x07, Data: []byte("Cue")},
+					&TextMetaEvent{TextEventType: 0x06, Data: []byte("Verse")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 48, 144, 0},
+			},
+		},
+	}
+	markers := smf.Markers()
+	if len(markers) != 2 {
+		t.Logf("Expected 2 markers, got %d\n", len(markers))
+		t.FailNow()
+	}
+	if (markers[0].Text != "Intro") || (markers[0].Tick != 0) ||
+		(markers[1].Text != "Verse") || (markers[1].Tick != 192) ||
+		(markers[1].Seconds != 1.0) || (markers[1].Track != 0) {
+		t.Logf("Got incorrect markers: %+v\n", markers)
+		t.FailNow()
+	}
+}
+
+func TestCuePoints(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x06, Data: []byte("Intro")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&TextMetaEvent{TextEventType: 0x07, Data: []byte("Door")},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 0},
+			},
+		},
+	}
+	cues := smf.CuePoints()
+	if len(cues) != 1 {
+		t.Logf("Expected 1 cue point, got %d\n", len(cues))
+		t.FailNow()
+	}
+	if (cues[0].Text != "Door") || (cues[0].Tick != 96) ||
+		(cues[0].Seconds != 0.5) || (cues[0].Track != 1) {
+		t.Logf("Got incorrect cue point: %+v\n", cues[0])
+		t.FailNow()
+	}
+}
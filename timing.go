@@ -0,0 +1,215 @@
+package midi
+
+// This file contains code for working with the absolute timing of events
+// across all tracks in an SMF file, including converting ticks to seconds.
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Holds a single event from an SMF file, along with its absolute time and its
+// location in the file.
+type TimedEvent struct {
+	// The index of the track containing the event.
+	Track int
+	// The index of the event within its track.
+	Index int
+	// The absolute time of the event, in ticks.
+	Tick    uint32
+	Message MIDIMessage
+}
+
+// Returns every event in the file, from all tracks, sorted by absolute time.
+// Simultaneous events are ordered by track, and then by their order within
+// the track, except that an event ending a note that started earlier is moved
+// before any simultaneous note-on with the same channel and pitch, including
+// one from an earlier track, as MustPrecedeInMerge requires. This ensures that
+// a note re-articulated in one track at the same time as it ends in another
+// isn't cut off immediately.
+func (f *SMFFile) TimedEvents() []TimedEvent {
+	count := 0
+	for _, t := range f.Tracks {
+		count += len(t.Messages)
+	}
+	toReturn := make([]TimedEvent, 0, count)
+	for i, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		for j, m := range t.Messages {
+			toReturn = append(toReturn, TimedEvent{
+				Track:   i,
+				Index:   j,
+				Tick:    ticks[j],
+				Message: m,
+			})
+		}
+	}
+	// The events are already ordered by track and index, so a stable sort
+	// keeps that order for simultaneous events.
+	sort.SliceStable(toReturn, func(a, b int) bool {
+		return toReturn[a].Tick < toReturn[b].Tick
+	})
+	ticks := make([]uint32, len(toReturn))
+	messages := make([]MIDIMessage, len(toReturn))
+	for i, event := range toReturn {
+		ticks[i] = event.Tick
+		messages[i] = event.Message
+	}
+	order := noteEndsFirstOrder(ticks, messages)
+	if order == nil {
+		return toReturn
+	}
+	reordered := make([]TimedEvent, len(toReturn))
+	for i, index := range order {
+		reordered[i] = toReturn[index]
+	}
+	return reordered
+}
+
+// The tempo used if a file doesn't contain any set-tempo events: 120 BPM.
+const defaultMicrosecondsPerQuarterNote = 500000
+
+// Tracks a single tempo change, along with the time in seconds at which it
+// occurs.
+type tempoChange struct {
+	tick                   uint32
+	microsecondsPerQuarter uint32
+	seconds                float64
+}
+
+// Maps absolute times in ticks to times in seconds, using the set-tempo events
+// in a file. Obtain one using SMFFile.TempoMap().
+type TempoMap struct {
+	division TimeDivision
+	// The tempo changes, sorted by time. This always contains at least one
+	// entry at tick 0.
+	changes []tempoChange
+}
+
+// Builds and returns a TempoMap using the set-tempo events from all tracks in
+// the file. Files without a tempo event at tick 0 use the default of 120 BPM
+// until the first tempo change.
+func (f *SMFFile) TempoMap() *TempoMap {
+	toReturn := &TempoMap{
+		division: f.Division,
+		changes: []tempoChange{
+			tempoChange{
+				tick:                   0,
+				microsecondsPerQuarter: defaultMicrosecondsPerQuarterNote,
+				seconds:                0,
+			},
+		},
+	}
+	for _, event := range f.TimedEvents() {
+		tempo, ok := event.Message.(SetTempoMetaEvent)
+		if !ok {
+			continue
+		}
+		previous := &(toReturn.changes[len(toReturn.changes)-1])
+		if previous.tick == event.Tick {
+			// A later tempo event at the same tick overrides the earlier one.
+			previous.microsecondsPerQuarter = uint32(tempo)
+			continue
+		}
+		toReturn.changes = append(toReturn.changes, tempoChange{
+			tick:                   event.Tick,
+			microsecondsPerQuarter: uint32(tempo),
+			seconds: previous.seconds + toReturn.tickSeconds(previous,
+				event.Tick-previous.tick),
+		})
+	}
+	return toReturn
+}
+
+// Returns the number of seconds taken by the given number of ticks, using the
+// tempo from the given tempo change.
+func (m *TempoMap) tickSeconds(tempo *tempoChange, ticks uint32) float64 {
+	fps, ticksPerFrame := m.division.SMPTETimeCode()
+	if ticksPerFrame != 0 {
+		// SMPTE-based divisions don't depend on the tempo.
+		rate := float64(fps)
+		if fps == 29 {
+			rate = 29.97
+		}
+		return float64(ticks) / (rate * float64(ticksPerFrame))
+	}
+	ticksPerQuarter := m.division.TicksPerQuarterNote()
+	if ticksPerQuarter == 0 {
+		return 0
+	}
+	quarterNotes := float64(ticks) / float64(ticksPerQuarter)
+	return quarterNotes * float64(tempo.microsecondsPerQuarter) / 1000000.0
+}
+
+// Returns the index of the tempo change in effect at the given tick.
+func (m *TempoMap) changeIndex(tick uint32) int {
+	// Find the first change after the tick; the one before it is in effect.
+	i := sort.Search(len(m.changes), func(i int) bool {
+		return m.changes[i].tick > tick
+	})
+	return i - 1
+}
+
+// Converts the given absolute time in ticks to a time in seconds.
+func (m *TempoMap) Seconds(tick uint32) float64 {
+	tempo := &(m.changes[m.changeIndex(tick)])
+	return tempo.seconds + m.tickSeconds(tempo, tick-tempo.tick)
+}
+
+// Returns the tempo, in microseconds per quarter note, in effect at the given
+// absolute time in ticks.
+func (m *TempoMap) MicrosecondsPerQuarterNote(tick uint32) uint32 {
+	return m.changes[m.changeIndex(tick)].microsecondsPerQuarter
+}
+
+// Returns the tempo in effect at the given absolute time in ticks, in beats
+// (quarter notes) per minute, and true. Returns false if the file's time
+// division is SMPTE-based (or invalid), since tick durations in such files
+// don't depend on the tempo, so BPM isn't meaningful. Also returns false if
+// the tempo in effect is 0. Builds a new TempoMap on each call, so use
+// TempoMap.MicrosecondsPerQuarterNote if many lookups are needed.
+func (f *SMFFile) EffectiveBPM(atTick uint32) (float64, bool) {
+	if f.Division.TicksPerQuarterNote() == 0 {
+		return 0, false
+	}
+	microseconds := f.TempoMap().MicrosecondsPerQuarterNote(atTick)
+	if microseconds == 0 {
+		return 0, false
+	}
+	return 60000000.0 / float64(microseconds), true
+}
+
+// Holds a single event along with the time at which it should be played,
+// relative to the start of the file.
+type ScheduledMessage struct {
+	// The time of the event, in milliseconds.
+	OffsetMs float64
+	// The index of the track containing the event.
+	Track   int
+	Message MIDIMessage
+}
+
+// Returns every event in the file, from all tracks, along with its time in
+// milliseconds, computed using the file's tempo map. The events are sorted in
+// the same order as TimedEvents. Returns an error if the file's time division
+// is invalid.
+func (f *SMFFile) FlattenToMilliseconds() ([]ScheduledMessage, error) {
+	if (f.Division & 0x7fff) == 0 {
+		return nil, fmt.Errorf("Invalid time division: %s", f.Division)
+	}
+	fps, ticksPerFrame := f.Division.SMPTETimeCode()
+	if (ticksPerFrame == 0) && (fps != 0) {
+		return nil, fmt.Errorf("Invalid time division: %s", f.Division)
+	}
+	tempoMap := f.TempoMap()
+	events := f.TimedEvents()
+	toReturn := make([]ScheduledMessage, len(events))
+	for i, event := range events {
+		toReturn[i] = ScheduledMessage{
+			OffsetMs: tempoMap.Seconds(event.Tick) * 1000.0,
+			Track:    event.Track,
+			Message:  event.Message,
+		}
+	}
+	return toReturn, nil
+}
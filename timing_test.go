@@ -0,0 +1,198 @@
+package midi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFlattenToMilliseconds(t *testing.T) {
+	// Starts at the default 120 BPM, then switches to 60 BPM after one
+	// quarter note.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{48, 96, 0},
+			},
+		},
+	}
+	scheduled, e := smf.FlattenToMilliseconds()
+	if e != nil {
+		t.Logf("Failed flattening file: %s\n", e)
+		t.FailNow()
+	}
+	expected := []float64{250, 500, 500, 1000, 1000}
+	for i, v := range expected {
+		if math.Abs(scheduled[i].OffsetMs-v) > 0.001 {
+			t.Logf("Expected event %d at %f ms\n", i, v)
+			t.FailNow()
+		}
+	}
+	smf.Division = 0
+	_, e = smf.FlattenToMilliseconds()
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid time division\n")
+		t.FailNow()
+	}
+}
+
+func TestEffectiveBPM(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 0},
+			},
+		},
+	}
+	bpm, ok := smf.EffectiveBPM(0)
+	if !ok || (math.Abs(bpm-120) > 0.001) {
+		t.Logf("Expected the default 120 BPM at tick 0, got %f (%v)\n", bpm,
+			ok)
+		t.FailNow()
+	}
+	bpm, ok = smf.EffectiveBPM(100)
+	if !ok || (math.Abs(bpm-60) > 0.001) {
+		t.Logf("Expected 60 BPM at tick 100, got %f (%v)\n", bpm, ok)
+		t.FailNow()
+	}
+	// -25 FPS, 40 ticks per frame.
+	smf.Division = TimeDivision(0xe728)
+	_, ok = smf.EffectiveBPM(100)
+	if ok {
+		t.Logf("Didn't get false for a SMPTE time division\n")
+		t.FailNow()
+	}
+}
+
+func TestTempoMap(t *testing.T) {
+	// Starts at the default 120 BPM, switches to 60 BPM at tick 192, then to
+	// 240 BPM at tick 288. The second tempo event at tick 288 overrides the
+	// first.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(1000000),
+					SetTempoMetaEvent(500000),
+					SetTempoMetaEvent(250000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{192, 96, 0, 0},
+			},
+		},
+	}
+	tempoMap := smf.TempoMap()
+	expected := []struct {
+		tick         uint32
+		seconds      float64
+		microseconds uint32
+	}{
+		{0, 0.0, 500000},
+		{96, 0.5, 500000},
+		{192, 1.0, 1000000},
+		{240, 1.5, 1000000},
+		{288, 2.0, 250000},
+		{384, 2.25, 250000},
+	}
+	for _, v := range expected {
+		seconds := tempoMap.Seconds(v.tick)
+		if math.Abs(seconds-v.seconds) > 1e-9 {
+			t.Logf("Expected tick %d to be at %f seconds, got %f\n", v.tick,
+				v.seconds, seconds)
+			t.FailNow()
+		}
+		microseconds := tempoMap.MicrosecondsPerQuarterNote(v.tick)
+		if microseconds != v.microseconds {
+			t.Logf("Expected tempo %d at tick %d, got %d\n", v.microseconds,
+				v.tick, microseconds)
+			t.FailNow()
+		}
+	}
+
+	// Tick durations in SMPTE-based files don't depend on the tempo: 25 FPS
+	// with 40 ticks per frame is 1000 ticks per second.
+	smf.Division = TimeDivision(0xe728)
+	tempoMap = smf.TempoMap()
+	if math.Abs(tempoMap.Seconds(1500)-1.5) > 1e-9 {
+		t.Logf("Expected 1.5 seconds at tick 1500 with an SMPTE division, "+
+			"got %f\n", tempoMap.Seconds(1500))
+		t.FailNow()
+	}
+}
+
+func TestTimedEventsReArticulation(t *testing.T) {
+	// The first track starts a note at tick 96, and the second ends the same
+	// note there. The note-off must come first, even though its track is
+	// later in the file.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOffEvent{Channel: 0, Note: 60},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{96, 96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 90},
+					&ControlChangeEvent{Channel: 0, ControllerNumber: 1},
+					&NoteOffEvent{Channel: 0, Note: 60},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0, 0},
+			},
+		},
+	}
+	events := smf.TimedEvents()
+	expected := []struct {
+		track int
+		index int
+	}{
+		{1, 0},
+		{1, 2},
+		{0, 0},
+		{1, 1},
+		{1, 3},
+		{0, 1},
+		{0, 2},
+	}
+	if len(events) != len(expected) {
+		t.Logf("Expected %d events, got %d\n", len(expected), len(events))
+		t.FailNow()
+	}
+	for i, v := range expected {
+		if (events[i].Track != v.track) || (events[i].Index != v.index) {
+			t.Logf("Expected event %d to be track %d's event %d, got track "+
+				"%d's event %d\n", i, v.track, v.index, events[i].Track,
+				events[i].Index)
+			t.FailNow()
+		}
+	}
+	notes := smf.Notes()
+	if (len(notes) != 2) || (notes[0].Duration != 96) ||
+		(notes[1].Duration != 96) {
+		t.Logf("Got incorrect notes: %+v\n", notes)
+		t.FailNow()
+	}
+}
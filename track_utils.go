@@ -0,0 +1,614 @@
+package midi
+
+// This file contains helper functions for inspecting the events in an SMFTrack,
+// or for working with them in terms of absolute time rather than time deltas.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Holds a MIDI message along with its absolute time in a track, in ticks.
+type timedMessage struct {
+	tick    uint32
+	message MIDIMessage
+}
+
+// Returns the absolute time, in ticks, of each event in the track. The
+// returned slice has the same length as t.TimeDeltas.
+func (t *SMFTrack) AbsoluteTicks() []uint32 {
+	toReturn := make([]uint32, len(t.TimeDeltas))
+	current := uint32(0)
+	for i, d := range t.TimeDeltas {
+		current += d
+		toReturn[i] = current
+	}
+	return toReturn
+}
+
+// Returns a copy of the track's messages, paired with their absolute times.
+func (t *SMFTrack) timedMessages() []timedMessage {
+	ticks := t.AbsoluteTicks()
+	toReturn := make([]timedMessage, len(t.Messages))
+	for i, m := range t.Messages {
+		toReturn[i] = timedMessage{
+			tick:    ticks[i],
+			message: m,
+		}
+	}
+	return toReturn
+}
+
+// Replaces the track's contents with the given events, which may be in any
+// order. The events are stable-sorted by time before recomputing the time
+// deltas, and simultaneous events are ordered as described by
+// orderNoteEndsFirst. If any end-of-track events are present, a single
+// end-of-track event is kept, and moved so that it's the final event in the
+// track.
+func (t *SMFTrack) setTimedMessages(events []timedMessage) {
+	sorted := make([]timedMessage, 0, len(events))
+	hasEndOfTrack := false
+	endOfTrackTick := uint32(0)
+	for _, v := range events {
+		_, isEnd := v.message.(EndOfTrackMetaEvent)
+		if !isEnd {
+			sorted = append(sorted, v)
+			continue
+		}
+		hasEndOfTrack = true
+		if v.tick > endOfTrackTick {
+			endOfTrackTick = v.tick
+		}
+	}
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].tick < sorted[b].tick
+	})
+	orderNoteEndsFirst(sorted)
+	if hasEndOfTrack {
+		if (len(sorted) > 0) && (sorted[len(sorted)-1].tick > endOfTrackTick) {
+			endOfTrackTick = sorted[len(sorted)-1].tick
+		}
+		sorted = append(sorted, timedMessage{
+			tick:    endOfTrackTick,
+			message: EndOfTrackMetaEvent(0),
+		})
+	}
+	t.Messages = make([]MIDIMessage, len(sorted))
+	t.TimeDeltas = make([]uint32, len(sorted))
+	t.EventOffsets = nil
+	previous := uint32(0)
+	for i, v := range sorted {
+		t.Messages[i] = v.message
+		t.TimeDeltas[i] = v.tick - previous
+		previous = v.tick
+	}
+}
+
+// Returns true if the track's events are in time order. Since events are
+// stored with time deltas, their absolute times can only decrease if the sum
+// of the deltas overflows a uint32. This also returns false if any events
+// follow an end-of-track event, since they'd be interpreted as occurring
+// after the end of the track.
+func (t *SMFTrack) IsTimeOrdered() bool {
+	total := uint64(0)
+	for _, d := range t.TimeDeltas {
+		total += uint64(d)
+	}
+	if total > 0xffffffff {
+		return false
+	}
+	for i, m := range t.Messages {
+		_, isEnd := m.(EndOfTrackMetaEvent)
+		if isEnd && (i != (len(t.Messages) - 1)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorts the track's events by their absolute times, and recomputes the time
+// deltas. Simultaneous events keep their relative order, except that note-offs
+// are moved before simultaneous note-ons as described by MustPrecedeInMerge.
+// If the track contains any end-of-track events, a single one is kept at the
+// end of the track. This can be used to repair a track after inserting events
+// or changing time deltas, e.g. if events were accidentally added after the
+// end-of-track event. This doesn't repair tracks in which the sum of the time
+// deltas overflows a uint32.
+func (t *SMFTrack) SortByTime() {
+	t.setTimedMessages(t.timedMessages())
+}
+
+// Returns true if m is a note-on event with a nonzero velocity.
+func isNoteOn(m MIDIMessage) bool {
+	v, ok := m.(*NoteOnEvent)
+	return ok && (v.Velocity != 0)
+}
+
+// If m ends a note, either by being a note-off event or a note-on event with
+// zero velocity, this returns the channel and note that it ends, and true.
+// Returns false if m doesn't end a note.
+func noteOffInfo(m MIDIMessage) (uint8, MIDINote, bool) {
+	switch v := m.(type) {
+	case *NoteOffEvent:
+		return v.Channel, v.Note, true
+	case *NoteOnEvent:
+		if v.Velocity == 0 {
+			return v.Channel, v.Note, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Returns true if a must come before b when both occur at the same time in a
+// merged track: a ends a note, and b starts a note with the same channel and
+// pitch. Putting the note-off first ensures that a note re-articulated at the
+// same time an earlier note ends isn't cut off immediately. This is intended
+// for ordering simultaneous events from different tracks or files, where a
+// note-off never belongs to a note-on it's being compared with. This isn't a
+// strict weak ordering (it only relates note-offs to note-ons), so it mustn't
+// be used as the comparison function for a sort. Instead, move each note-off
+// for which this returns true before the first simultaneous note-on it must
+// precede, as SortByTime and TimedEvents do.
+func MustPrecedeInMerge(a, b MIDIMessage) bool {
+	if !isNoteOn(b) {
+		return false
+	}
+	channel, note, ok := noteOffInfo(a)
+	if !ok {
+		return false
+	}
+	v := b.(*NoteOnEvent)
+	return ((channel & 0xf) == (v.Channel & 0xf)) && (note == v.Note)
+}
+
+// Reorders simultaneous events in the given time-sorted list so that any
+// event ending a note that started at an earlier time comes before any
+// simultaneous note-on with the same channel and pitch, as MustPrecedeInMerge
+// requires. See noteEndsFirstOrder for details.
+func orderNoteEndsFirst(events []timedMessage) {
+	ticks := make([]uint32, len(events))
+	messages := make([]MIDIMessage, len(events))
+	for i, v := range events {
+		ticks[i] = v.tick
+		messages[i] = v.message
+	}
+	order := noteEndsFirstOrder(ticks, messages)
+	if order == nil {
+		return
+	}
+	reordered := make([]timedMessage, len(events))
+	for i, index := range order {
+		reordered[i] = events[index]
+	}
+	copy(events, reordered)
+}
+
+// Takes the times and messages of a time-sorted list of events, and returns
+// the order in which to place them (as indices into the list) so that any
+// event ending a note that started at an earlier time comes before any
+// simultaneous note-on with the same channel and pitch. Each such note-off is
+// moved to immediately before the first matching note-on at its time; other
+// events keep their relative order. Note-offs for notes that start at the same
+// time (zero-length notes) aren't moved, so they still end the note they were
+// paired with. Returns nil if no events need to be moved.
+func noteEndsFirstOrder(ticks []uint32, messages []MIDIMessage) []int {
+	var toReturn []int
+	sounding := make(map[channelNote]int)
+	groupStart := 0
+	for groupStart < len(messages) {
+		groupEnd := groupStart + 1
+		for (groupEnd < len(messages)) &&
+			(ticks[groupEnd] == ticks[groupStart]) {
+			groupEnd++
+		}
+		group := messages[groupStart:groupEnd]
+		firstNoteOn := make(map[channelNote]int)
+		for i, m := range group {
+			if !isNoteOn(m) {
+				continue
+			}
+			n := m.(*NoteOnEvent)
+			key := channelNote{n.Channel & 0xf, n.Note}
+			if _, ok := firstNoteOn[key]; !ok {
+				firstNoteOn[key] = i
+			}
+		}
+		// Positions are doubled, so a moved note-off can be placed between
+		// two existing events.
+		positions := make([]int, len(group))
+		// The number of notes from before this time that have been ended.
+		available := make(map[channelNote]int)
+		moved := false
+		for i, m := range group {
+			positions[i] = 2 * i
+			channel, note, ok := noteOffInfo(m)
+			if !ok {
+				continue
+			}
+			key := channelNote{channel & 0xf, note}
+			if available[key] >= sounding[key] {
+				// This ends a note that started at the same time.
+				continue
+			}
+			available[key]++
+			first, hasNoteOn := firstNoteOn[key]
+			if hasNoteOn && (first < i) {
+				positions[i] = 2*first - 1
+				moved = true
+			}
+		}
+		if moved {
+			if toReturn == nil {
+				toReturn = make([]int, len(messages))
+				for i := range toReturn {
+					toReturn[i] = i
+				}
+			}
+			indices := toReturn[groupStart:groupEnd]
+			sort.SliceStable(indices, func(a, b int) bool {
+				return positions[indices[a]-groupStart] <
+					positions[indices[b]-groupStart]
+			})
+		}
+		for _, m := range group {
+			if isNoteOn(m) {
+				n := m.(*NoteOnEvent)
+				sounding[channelNote{n.Channel & 0xf, n.Note}]++
+				continue
+			}
+			channel, note, ok := noteOffInfo(m)
+			if !ok {
+				continue
+			}
+			key := channelNote{channel & 0xf, note}
+			if sounding[key] > 0 {
+				sounding[key]--
+			}
+		}
+		groupStart = groupEnd
+	}
+	return toReturn
+}
+
+// Tracks the positions of a note-on event and the event that ends it within a
+// single track.
+type notePair struct {
+	onIndex int
+	// This will be -1 if the note is never turned off.
+	offIndex int
+}
+
+// Used as a map key when pairing notes.
+type channelNote struct {
+	channel uint8
+	note    MIDINote
+}
+
+// Matches each note-on event in the track with the note-off (or zero-velocity
+// note-on) event that ends it. If the same note is started multiple times
+// before being ended, the earliest note-on is paired with the first note-off.
+// The returned pairs are in the order of their note-on events.
+func (t *SMFTrack) pairNotes() []notePair {
+	var toReturn []notePair
+	// Maps each channel and note to a list of indices into toReturn for notes
+	// that haven't been ended yet.
+	active := make(map[channelNote][]int)
+	for i, m := range t.Messages {
+		if isNoteOn(m) {
+			v := m.(*NoteOnEvent)
+			key := channelNote{v.Channel, v.Note}
+			active[key] = append(active[key], len(toReturn))
+			toReturn = append(toReturn, notePair{
+				onIndex:  i,
+				offIndex: -1,
+			})
+			continue
+		}
+		channel, note, ok := noteOffInfo(m)
+		if !ok {
+			continue
+		}
+		key := channelNote{channel, note}
+		pending := active[key]
+		if len(pending) == 0 {
+			continue
+		}
+		toReturn[pending[0]].offIndex = i
+		active[key] = pending[1:]
+	}
+	return toReturn
+}
+
+// Describes a note-on event for a note that was already sounding on the same
+// channel, without an intervening note-off.
+type NoteOverlap struct {
+	// The index of the earlier note-on event. If several notes with the same
+	// channel and pitch were sounding, this is the most recent one.
+	FirstIndex int
+	// The index of the note-on event that retriggered the note.
+	SecondIndex int
+}
+
+// Returns a list of every note-on event in the track that starts a note that
+// was already sounding on the same channel, in the order they occur. Such
+// retriggers are ambiguous, and may be handled inconsistently by different
+// synthesizers.
+func (t *SMFTrack) OverlappingNotes() []NoteOverlap {
+	var toReturn []NoteOverlap
+	// Maps each channel and note to the indices of its sounding note-ons.
+	active := make(map[channelNote][]int)
+	for i, m := range t.Messages {
+		if isNoteOn(m) {
+			v := m.(*NoteOnEvent)
+			key := channelNote{v.Channel, v.Note}
+			sounding := active[key]
+			if len(sounding) != 0 {
+				toReturn = append(toReturn, NoteOverlap{
+					FirstIndex:  sounding[len(sounding)-1],
+					SecondIndex: i,
+				})
+			}
+			active[key] = append(sounding, i)
+			continue
+		}
+		channel, note, ok := noteOffInfo(m)
+		if !ok {
+			continue
+		}
+		key := channelNote{channel, note}
+		if len(active[key]) != 0 {
+			active[key] = active[key][1:]
+		}
+	}
+	return toReturn
+}
+
+// Determines which channel applies to each meta-event or system-exclusive
+// message in the track, based on MIDI channel prefix meta-events. Per the SMF
+// spec, a channel prefix applies to all subsequent meta and sysex events until
+// the next channel prefix or the next channel message. The returned map's
+// keys are indices of non-channel events (including the channel prefix events
+// themselves) for which a channel prefix is in effect, and its values are the
+// corresponding channels. Events with no channel prefix in effect are not
+// included in the map.
+func (t *SMFTrack) ResolveChannelPrefixes() map[int]uint8 {
+	toReturn := make(map[int]uint8)
+	inEffect := false
+	channel := uint8(0)
+	for i, m := range t.Messages {
+		_, isChannelMessage := m.(ChannelMessage)
+		if isChannelMessage {
+			inEffect = false
+			continue
+		}
+		prefix, isPrefix := m.(ChannelPrefixMetaEvent)
+		if isPrefix {
+			inEffect = true
+			channel = uint8(prefix)
+		}
+		if inEffect {
+			toReturn[i] = channel
+		}
+	}
+	return toReturn
+}
+
+// Returns a copy of the given message, which can be modified without
+// affecting the original.
+func copyMessage(m MIDIMessage) MIDIMessage {
+	switch v := m.(type) {
+	case *SystemExclusiveMessage:
+		return &SystemExclusiveMessage{
+			DataBytes: append([]byte(nil), v.DataBytes...),
+		}
+	case *GenericMetaEvent:
+		return &GenericMetaEvent{
+			EventType: v.EventType,
+			Data:      append([]byte(nil), v.Data...),
+		}
+	case *TextMetaEvent:
+		return &TextMetaEvent{
+			TextEventType: v.TextEventType,
+			Data:          append([]byte(nil), v.Data...),
+		}
+	case *SMPTEOffsetMetaEvent:
+		tmp := *v
+		return &tmp
+	case *TimeSignatureMetaEvent:
+		tmp := *v
+		return &tmp
+	case *KeySignatureMetaEvent:
+		tmp := *v
+		return &tmp
+	case *NoteOffEvent:
+		tmp := *v
+		return &tmp
+	case *NoteOnEvent:
+		tmp := *v
+		return &tmp
+	case *AftertouchEvent:
+		tmp := *v
+		return &tmp
+	case *ControlChangeEvent:
+		tmp := *v
+		return &tmp
+	case *ProgramChangeEvent:
+		tmp := *v
+		return &tmp
+	case *ChannelPressureEvent:
+		tmp := *v
+		return &tmp
+	case *PitchBendEvent:
+		tmp := *v
+		return &tmp
+	}
+	// The remaining message types aren't pointers, so they're already copied.
+	return m
+}
+
+// Returns a deep copy of the track. Modifying the copy's messages won't affect
+// the original track.
+func (t *SMFTrack) Copy() *SMFTrack {
+	toReturn := &SMFTrack{
+		Messages:   make([]MIDIMessage, len(t.Messages)),
+		TimeDeltas: make([]uint32, len(t.TimeDeltas)),
+	}
+	for i, m := range t.Messages {
+		toReturn.Messages[i] = copyMessage(m)
+	}
+	copy(toReturn.TimeDeltas, t.TimeDeltas)
+	return toReturn
+}
+
+// Appends the message to the end of the track, occurring the given number of
+// ticks after the previous event. This keeps the Messages and TimeDeltas
+// slices the same length, and should be preferred over appending to them
+// separately.
+func (t *SMFTrack) Append(delta uint32, m MIDIMessage) {
+	t.Messages = append(t.Messages, m)
+	t.TimeDeltas = append(t.TimeDeltas, delta)
+}
+
+// Adds a note to the track, lasting for the given number of ticks. The
+// note-on occurs startDelta ticks after the last event in the track that isn't
+// a note-off or end-of-track event, so calling AddNote several times with a
+// startDelta of 0 produces a chord, and passing the previous note's duration
+// produces a sequence of notes. The note-off is inserted at the correct time,
+// after any events occurring during the note, and the end-of-track event, if
+// any, is moved after the new note-off if necessary. The channel and note are
+// masked to their valid ranges. Returns an error, without modifying the track,
+// if the note would end after the largest possible tick. Each call re-sorts
+// the entire track, so building a long track one note at a time takes time
+// quadratic in its length; appending its events in order with Append is much
+// faster.
+func (t *SMFTrack) AddNote(startDelta uint32, channel uint8, note MIDINote,
+	velocity uint8, durationTicks uint32) error {
+	channel &= 0xf
+	note &= 0x7f
+	events := t.timedMessages()
+	start := uint32(0)
+	for i := len(events) - 1; i >= 0; i-- {
+		m := events[i].message
+		if _, isEnd := m.(EndOfTrackMetaEvent); isEnd {
+			continue
+		}
+		if _, _, isNoteOff := noteOffInfo(m); isNoteOff {
+			continue
+		}
+		start = events[i].tick
+		break
+	}
+	if (startDelta > (math.MaxUint32 - start)) ||
+		(durationTicks > (math.MaxUint32 - start - startDelta)) {
+		return fmt.Errorf("A note starting %d ticks after tick %d and "+
+			"lasting %d ticks would end after the last possible tick",
+			startDelta, start, durationTicks)
+	}
+	start += startDelta
+	events = append(events, timedMessage{
+		tick: start,
+		message: &NoteOnEvent{
+			Channel:  channel,
+			Note:     note,
+			Velocity: velocity & 0x7f,
+		},
+	}, timedMessage{
+		tick: start + durationTicks,
+		message: &NoteOffEvent{
+			Channel: channel,
+			Note:    note,
+		},
+	})
+	t.setTimedMessages(events)
+	return nil
+}
+
+// Returns the indices of the track's messages for which match returns true,
+// in increasing order. Returns nil if no messages match.
+func (t *SMFTrack) FindEvents(match func(m MIDIMessage) bool) []int {
+	var toReturn []int
+	for i, m := range t.Messages {
+		if match(m) {
+			toReturn = append(toReturn, i)
+		}
+	}
+	return toReturn
+}
+
+// Returns true if the track contains at least one note-on event with a
+// nonzero velocity.
+func (t *SMFTrack) HasNotes() bool {
+	for _, m := range t.Messages {
+		if isNoteOn(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the track appears to be a "conductor" track, as is often
+// the first track in a format 1 file: it contains no channel messages, and
+// contains at least one meta-event other than end-of-track (e.g. tempo or
+// time-signature events).
+func (t *SMFTrack) IsConductorTrack() bool {
+	hasMetaEvents := false
+	for _, m := range t.Messages {
+		switch m.(type) {
+		case ChannelMessage:
+			return false
+		case *SystemExclusiveMessage, EndOfTrackMetaEvent:
+			continue
+		}
+		hasMetaEvents = true
+	}
+	return hasMetaEvents
+}
+
+// Returns the position of m in the conductor-event ordering used by
+// EnsureConductorOrdering, or -1 if m isn't one of the ordered event types.
+func conductorRank(m MIDIMessage) int {
+	switch m.(type) {
+	case *TimeSignatureMetaEvent:
+		return 0
+	case SetTempoMetaEvent:
+		return 1
+	case *KeySignatureMetaEvent:
+		return 2
+	}
+	return -1
+}
+
+// Reorders simultaneous time-signature, set-tempo, and key-signature events in
+// the track so that, at any given tick, the time signature comes first,
+// followed by the tempo, and then the key signature. Some software expects
+// this order, especially for the events at the start of a conductor track.
+// Only the positions already occupied by these events are changed; other
+// events (such as a track name) keep their positions, and since no events
+// change time, the time deltas are unaffected.
+func (t *SMFTrack) EnsureConductorOrdering() {
+	ticks := t.AbsoluteTicks()
+	groupStart := 0
+	for groupStart < len(t.Messages) {
+		groupEnd := groupStart + 1
+		for (groupEnd < len(t.Messages)) &&
+			(ticks[groupEnd] == ticks[groupStart]) {
+			groupEnd++
+		}
+		var slots []int
+		var events []MIDIMessage
+		for i := groupStart; i < groupEnd; i++ {
+			if conductorRank(t.Messages[i]) >= 0 {
+				slots = append(slots, i)
+				events = append(events, t.Messages[i])
+			}
+		}
+		sort.SliceStable(events, func(a, b int) bool {
+			return conductorRank(events[a]) < conductorRank(events[b])
+		})
+		for i, slot := range slots {
+			t.Messages[slot] = events[i]
+		}
+		groupStart = groupEnd
+	}
+}
@@ -0,0 +1,342 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestMustPrecedeInMerge(t *testing.T) {
+	noteOff := &NoteOffEvent{Channel: 2, Note: 60, Velocity: 64}
+	noteOn := &NoteOnEvent{Channel: 2, Note: 60, Velocity: 100}
+	if !MustPrecedeInMerge(noteOff, noteOn) {
+		t.Logf("A note-off wasn't ordered before a matching note-on\n")
+		t.FailNow()
+	}
+	if MustPrecedeInMerge(noteOn, noteOff) {
+		t.Logf("A note-on was ordered before a matching note-off\n")
+		t.FailNow()
+	}
+	zeroVelocity := &NoteOnEvent{Channel: 2, Note: 60, Velocity: 0}
+	if !MustPrecedeInMerge(zeroVelocity, noteOn) {
+		t.Logf("A zero-velocity note-on wasn't treated as a note-off\n")
+		t.FailNow()
+	}
+	otherNote := &NoteOnEvent{Channel: 2, Note: 61, Velocity: 100}
+	if MustPrecedeInMerge(noteOff, otherNote) {
+		t.Logf("A note-off was ordered before a different note\n")
+		t.FailNow()
+	}
+}
+
+func TestMergeReArticulation(t *testing.T) {
+	// Merges two tracks: in the first, a note ends at tick 96, and in the
+	// second, the same note is started again at tick 96. The second track's
+	// events come first in the input, so a plain stable sort would end the
+	// new note immediately.
+	second := []timedMessage{
+		{96, &NoteOnEvent{Channel: 0, Note: 60, Velocity: 100}},
+		{192, &NoteOnEvent{Channel: 0, Note: 60, Velocity: 0}},
+	}
+	first := []timedMessage{
+		{0, &NoteOnEvent{Channel: 0, Note: 60, Velocity: 90}},
+		{96, &NoteOffEvent{Channel: 0, Note: 60, Velocity: 64}},
+		{96, &NoteOnEvent{Channel: 0, Note: 62, Velocity: 80}},
+		{96, &NoteOnEvent{Channel: 0, Note: 62, Velocity: 0}},
+	}
+	track := &SMFTrack{}
+	track.setTimedMessages(append(second, first...))
+	if _, ok := track.Messages[1].(*NoteOffEvent); !ok {
+		t.Logf("The note-off wasn't moved before the re-articulation\n")
+		t.FailNow()
+	}
+	if !isNoteOn(track.Messages[2]) {
+		t.Logf("The re-articulated note isn't after the note-off\n")
+		t.FailNow()
+	}
+	// The zero-length note on pitch 62 must keep its original order, since
+	// its note-off doesn't end an earlier note.
+	v, ok := track.Messages[4].(*NoteOnEvent)
+	if !ok || (v.Note != 62) || (v.Velocity != 0) {
+		t.Logf("The zero-length note was reordered\n")
+		t.FailNow()
+	}
+	notes := (&SMFFile{Tracks: []*SMFTrack{track}}).Notes()
+	if (len(notes) != 3) || (notes[1].Duration != 96) {
+		t.Logf("Got incorrect notes after merging: %+v\n", notes)
+		t.FailNow()
+	}
+}
+
+func TestSortByTime(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			EndOfTrackMetaEvent(0),
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+		},
+		TimeDeltas: []uint32{0, 10, 10},
+	}
+	if track.IsTimeOrdered() {
+		t.Logf("An event after the end of the track wasn't detected\n")
+		t.FailNow()
+	}
+	track.SortByTime()
+	if !track.IsTimeOrdered() {
+		t.Logf("The track isn't time-ordered after sorting\n")
+		t.FailNow()
+	}
+	if (len(track.Messages) != 3) || (track.TimeDeltas[1] != 20) ||
+		(track.TimeDeltas[2] != 0) {
+		t.Logf("Got incorrect time deltas after sorting\n")
+		t.FailNow()
+	}
+	overflow := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+		},
+		TimeDeltas: []uint32{0xffffff00, 0x200},
+	}
+	if overflow.IsTimeOrdered() {
+		t.Logf("An overflowing time delta wasn't detected\n")
+		t.FailNow()
+	}
+}
+
+func TestFindEvents(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ProgramChangeEvent{Channel: 3, Value: 1},
+			&ProgramChangeEvent{Channel: 4, Value: 2},
+			&NoteOnEvent{Channel: 3, Note: 60, Velocity: 100},
+			&ProgramChangeEvent{Channel: 3, Value: 5},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 10, 0},
+	}
+	indices := track.FindEvents(func(m MIDIMessage) bool {
+		v, ok := m.(*ProgramChangeEvent)
+		return ok && (v.Channel == 3)
+	})
+	if (len(indices) != 2) || (indices[0] != 0) || (indices[1] != 3) {
+		t.Logf("Got incorrect indices: %v\n", indices)
+		t.FailNow()
+	}
+	indices = track.FindEvents(func(m MIDIMessage) bool {
+		return false
+	})
+	if indices != nil {
+		t.Logf("Expected nil when no events match, got %v\n", indices)
+		t.FailNow()
+	}
+}
+
+func TestTrackAppend(t *testing.T) {
+	var track SMFTrack
+	track.Append(0, &NoteOnEvent{Channel: 0, Note: 60, Velocity: 100})
+	track.Append(96, &NoteOffEvent{Channel: 0, Note: 60})
+	track.Append(0, EndOfTrackMetaEvent(0))
+	if (len(track.Messages) != 3) || (len(track.TimeDeltas) != 3) {
+		t.Logf("Expected 3 messages and time deltas, got %d and %d\n",
+			len(track.Messages), len(track.TimeDeltas))
+		t.FailNow()
+	}
+	if track.TimeDeltas[1] != 96 {
+		t.Logf("Expected a delta of 96, got %d\n", track.TimeDeltas[1])
+		t.FailNow()
+	}
+	if _, ok := track.Messages[2].(EndOfTrackMetaEvent); !ok {
+		t.Logf("Expected the last message to be the end of the track\n")
+		t.FailNow()
+	}
+}
+
+func TestAddNote(t *testing.T) {
+	track := &SMFTrack{
+		Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+		TimeDeltas: []uint32{0},
+	}
+	// A chord, followed by a shorter note starting when the chord ends.
+	track.AddNote(0, 0, 60, 100, 96)
+	track.AddNote(0, 0, 64, 100, 96)
+	track.AddNote(96, 0, 62, 90, 48)
+	expected := []struct {
+		tick uint32
+		note MIDINote
+		on   bool
+	}{
+		{0, 60, true},
+		{0, 64, true},
+		{96, 60, false},
+		{96, 64, false},
+		{96, 62, true},
+		{144, 62, false},
+	}
+	if len(track.Messages) != (len(expected) + 1) {
+		t.Logf("Expected %d messages, got %d\n", len(expected)+1,
+			len(track.Messages))
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	for i, x := range expected {
+		m := track.Messages[i]
+		var note MIDINote
+		switch v := m.(type) {
+		case *NoteOnEvent:
+			if !x.on {
+				t.Logf("Expected a note-off at index %d\n", i)
+				t.FailNow()
+			}
+			note = v.Note
+		case *NoteOffEvent:
+			if x.on {
+				t.Logf("Expected a note-on at index %d\n", i)
+				t.FailNow()
+			}
+			note = v.Note
+		default:
+			t.Logf("Got unexpected message at index %d: %s\n", i, m)
+			t.FailNow()
+		}
+		if (note != x.note) || (ticks[i] != x.tick) {
+			t.Logf("Expected note %d at tick %d for index %d\n", x.note,
+				x.tick, i)
+			t.FailNow()
+		}
+	}
+	last := len(track.Messages) - 1
+	if _, ok := track.Messages[last].(EndOfTrackMetaEvent); !ok {
+		t.Logf("The end-of-track event wasn't kept at the end\n")
+		t.FailNow()
+	}
+	if ticks[last] != 144 {
+		t.Logf("Expected the track to end at tick 144, got %d\n", ticks[last])
+		t.FailNow()
+	}
+	e := track.AddNote(0, 0, 67, 100, 0xffffffff-50)
+	if e == nil {
+		t.Logf("Didn't get an error adding a note ending after the last " +
+			"possible tick\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	if len(track.Messages) != (len(expected) + 1) {
+		t.Logf("The track was modified despite the error\n")
+		t.FailNow()
+	}
+}
+
+func TestResolveChannelPrefixes(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			// 0: No prefix is in effect yet.
+			&TextMetaEvent{TextEventType: 3, Data: []byte("Before")},
+			ChannelPrefixMetaEvent(4),
+			&TextMetaEvent{TextEventType: 4, Data: []byte("Piano")},
+			// 3: A channel message ends the prefix's scope.
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&TextMetaEvent{TextEventType: 1, Data: []byte("After")},
+			ChannelPrefixMetaEvent(7),
+			// 6: The newer prefix replaces the old one.
+			ChannelPrefixMetaEvent(9),
+			&TextMetaEvent{TextEventType: 4, Data: []byte("Drums")},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: make([]uint32, 9),
+	}
+	expected := map[int]uint8{1: 4, 2: 4, 5: 7, 6: 9, 7: 9, 8: 9}
+	results := track.ResolveChannelPrefixes()
+	if len(results) != len(expected) {
+		t.Logf("Expected %d events with a channel prefix, got %d: %v\n",
+			len(expected), len(results), results)
+		t.FailNow()
+	}
+	for i, c := range expected {
+		got, ok := results[i]
+		if !ok {
+			t.Logf("Event %d (%s) didn't have a channel prefix\n", i,
+				track.Messages[i])
+			t.FailNow()
+		}
+		if got != c {
+			t.Logf("Expected channel %d for event %d, got %d\n", c, i, got)
+			t.FailNow()
+		}
+	}
+}
+
+func TestHasNotes(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ProgramChangeEvent{Channel: 0, Value: 1},
+			// A zero-velocity note-on is actually a note-off.
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 0},
+	}
+	if track.HasNotes() {
+		t.Logf("A track without any note-ons reported having notes\n")
+		t.FailNow()
+	}
+	track.Messages[1] = &NoteOnEvent{Channel: 0, Note: 60, Velocity: 1}
+	if !track.HasNotes() {
+		t.Logf("A track with a note-on reported having no notes\n")
+		t.FailNow()
+	}
+	if (&SMFTrack{}).HasNotes() {
+		t.Logf("An empty track reported having notes\n")
+		t.FailNow()
+	}
+}
+
+func TestEnsureConductorOrdering(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&KeySignatureMetaEvent{SharpOrFlatCount: 1},
+			&TextMetaEvent{TextEventType: 3, Data: []byte("Tempo")},
+			SetTempoMetaEvent(500000),
+			&TimeSignatureMetaEvent{Numerator: 4, Denominator: 2,
+				ClocksPerMetronomeTick:         24,
+				Notated32ndNotesPerQuarterNote: 8},
+			// A later group, which should be ordered separately.
+			SetTempoMetaEvent(400000),
+			&TimeSignatureMetaEvent{Numerator: 3, Denominator: 2,
+				ClocksPerMetronomeTick:         24,
+				Notated32ndNotesPerQuarterNote: 8},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 0, 96, 0, 0},
+	}
+	track.EnsureConductorOrdering()
+	expected := []string{"time", "name", "tempo", "key", "time", "tempo",
+		"end"}
+	for i, v := range expected {
+		var got string
+		switch track.Messages[i].(type) {
+		case *TimeSignatureMetaEvent:
+			got = "time"
+		case SetTempoMetaEvent:
+			got = "tempo"
+		case *KeySignatureMetaEvent:
+			got = "key"
+		case *TextMetaEvent:
+			got = "name"
+		case EndOfTrackMetaEvent:
+			got = "end"
+		}
+		if got != v {
+			t.Logf("Expected event %d to be %s, got %s\n", i, v,
+				track.Messages[i])
+			t.FailNow()
+		}
+	}
+	if track.TimeDeltas[4] != 96 {
+		t.Logf("Reordering the events changed the time deltas\n")
+		t.FailNow()
+	}
+	if track.Messages[5].(SetTempoMetaEvent) != 400000 {
+		t.Logf("The later tempo event moved to the wrong group\n")
+		t.FailNow()
+	}
+}
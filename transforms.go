@@ -0,0 +1,1102 @@
+package midi
+
+// This file contains functions that modify the notes or timing of SMF tracks.
+
+import (
+	"fmt"
+	"github.com/yalue/midi/gm"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Returns a random integer in the range [-limit, limit].
+func randomJitter(rng *rand.Rand, limit int64) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	return rng.Int63n(limit*2+1) - limit
+}
+
+// Nudges the time and velocity of each note in the track by a random amount,
+// to make quantized tracks sound less mechanical. Each note's start time is
+// moved by at most timingJitterTicks in either direction, and its matching
+// note-off is moved by the same amount so the note's duration is unchanged.
+// Times are clamped so they don't become negative. Velocities are changed by
+// at most velocityJitter, and clamped to the range 1-127. If rng is nil, a
+// new generator seeded with the current time is used.
+func (t *SMFTrack) Humanize(timingJitterTicks uint32, velocityJitter uint8,
+	rng *rand.Rand) {
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	events := t.timedMessages()
+	for _, p := range t.pairNotes() {
+		offset := randomJitter(rng, int64(timingJitterTicks))
+		on := &(events[p.onIndex])
+		if (int64(on.tick) + offset) < 0 {
+			offset = -int64(on.tick)
+		}
+		on.tick = uint32(int64(on.tick) + offset)
+		if p.offIndex >= 0 {
+			off := &(events[p.offIndex])
+			off.tick = uint32(int64(off.tick) + offset)
+		}
+		noteOn := on.message.(*NoteOnEvent)
+		velocity := int64(noteOn.Velocity) +
+			randomJitter(rng, int64(velocityJitter))
+		if velocity < 1 {
+			velocity = 1
+		}
+		if velocity > 127 {
+			velocity = 127
+		}
+		noteOn.Velocity = uint8(velocity)
+	}
+	t.setTimedMessages(events)
+}
+
+// Reduces the dynamic range of the track's notes, similar to an audio
+// compressor. The amount by which each note-on's velocity exceeds the
+// threshold is divided by the ratio, so a ratio of 2 halves the distance
+// between the threshold and each louder note. Velocities at or below the
+// threshold are unchanged. The results are rounded and clamped to the range
+// 1-127, and zero-velocity note-ons are left alone, since they're actually
+// note-offs. The track isn't modified if ratio isn't positive.
+func (t *SMFTrack) CompressVelocity(ratio float64, threshold uint8) {
+	if !(ratio > 0) {
+		return
+	}
+	for _, m := range t.Messages {
+		if !isNoteOn(m) {
+			continue
+		}
+		noteOn := m.(*NoteOnEvent)
+		if noteOn.Velocity <= threshold {
+			continue
+		}
+		excess := float64(noteOn.Velocity-threshold) / ratio
+		velocity := math.Round(float64(threshold) + excess)
+		if velocity < 1 {
+			velocity = 1
+		}
+		if velocity > 127 {
+			velocity = 127
+		}
+		noteOn.Velocity = uint8(velocity)
+	}
+}
+
+// Returns a new event that ends the given note, of the same kind as the
+// existing note-off message. (If like is a note-off event, the returned event
+// will be a note-off event with the same velocity. Otherwise it will be a
+// zero-velocity note-on event.)
+func newNoteOffLike(like MIDIMessage, channel uint8,
+	note MIDINote) MIDIMessage {
+	original, ok := like.(*NoteOffEvent)
+	if ok {
+		return &NoteOffEvent{
+			Channel:  channel,
+			Note:     note,
+			Velocity: original.Velocity,
+		}
+	}
+	return &NoteOnEvent{
+		Channel:  channel,
+		Note:     note,
+		Velocity: 0,
+	}
+}
+
+// Splits every note that sounds across a bar line into separate tied notes:
+// the note is ended at the bar line and a new note-on with the same velocity
+// is inserted immediately after it. Bar lines are assumed to occur every
+// ticksPerBar ticks, starting at tick 0. Notes that start exactly on a bar
+// line or end exactly on one aren't split. Does nothing if ticksPerBar is 0.
+func (t *SMFTrack) SplitNotesAtBars(ticksPerBar uint32) {
+	if ticksPerBar == 0 {
+		return
+	}
+	events := t.timedMessages()
+	for _, p := range t.pairNotes() {
+		if p.offIndex < 0 {
+			continue
+		}
+		start := events[p.onIndex].tick
+		end := events[p.offIndex].tick
+		noteOn := events[p.onIndex].message.(*NoteOnEvent)
+		offMessage := events[p.offIndex].message
+		// Find the first bar line after the start of the note.
+		bar := (start/ticksPerBar + 1) * ticksPerBar
+		for bar < end {
+			events = append(events, timedMessage{
+				tick: bar,
+				message: newNoteOffLike(offMessage, noteOn.Channel,
+					noteOn.Note),
+			})
+			events = append(events, timedMessage{
+				tick: bar,
+				message: &NoteOnEvent{
+					Channel:  noteOn.Channel,
+					Note:     noteOn.Note,
+					Velocity: noteOn.Velocity,
+				},
+			})
+			bar += ticksPerBar
+		}
+	}
+	t.setTimedMessages(events)
+}
+
+// Ends every note that is retriggered while it's still sounding (as reported
+// by OverlappingNotes) by inserting a note-off immediately before the note-on
+// that retriggers it. If the retriggering note has its own note-off, the
+// earlier note's original note-off is removed, so the retriggered note still
+// ends at the time of its own note-off. Otherwise, the earlier note's original
+// note-off is kept, and ends the retriggered note instead. Returns the number
+// of note-offs that were inserted.
+func (t *SMFTrack) FixOverlappingNotes() int {
+	overlaps := t.OverlappingNotes()
+	if len(overlaps) == 0 {
+		return 0
+	}
+	offIndices := make(map[int]int)
+	for _, p := range t.pairNotes() {
+		offIndices[p.onIndex] = p.offIndex
+	}
+	ticks := t.AbsoluteTicks()
+	inserted := make([]insertedEvent, 0, len(overlaps))
+	removed := make(map[int]bool)
+	for _, v := range overlaps {
+		var like MIDIMessage
+		offIndex := offIndices[v.FirstIndex]
+		if offIndex >= 0 {
+			like = t.Messages[offIndex]
+			if offIndices[v.SecondIndex] >= 0 {
+				removed[offIndex] = true
+			}
+		}
+		noteOn := t.Messages[v.SecondIndex].(*NoteOnEvent)
+		inserted = append(inserted, insertedEvent{
+			index: v.SecondIndex,
+			event: timedMessage{
+				tick:    ticks[v.SecondIndex],
+				message: newNoteOffLike(like, noteOn.Channel, noteOn.Note),
+			},
+		})
+	}
+	t.insertAndRemove(inserted, removed)
+	return len(inserted)
+}
+
+// Removes note-on events that start the same note (with the same channel and
+// pitch) at the same time as another note-on in the track, keeping only the
+// loudest one (or the earliest in the track, if several are equally loud).
+// The removed notes' note-offs are also removed, and the kept note lasts
+// until the latest of the group's note-offs, or never ends if any note in the
+// group never ended. Returns the number of note-on events that were removed.
+func (t *SMFTrack) DedupeSimultaneousNotes() int {
+	type noteStart struct {
+		channelNote
+		tick uint32
+	}
+	ticks := t.AbsoluteTicks()
+	groups := make(map[noteStart][]notePair)
+	var keys []noteStart
+	for _, p := range t.pairNotes() {
+		v := t.Messages[p.onIndex].(*NoteOnEvent)
+		key := noteStart{channelNote{v.Channel, v.Note}, ticks[p.onIndex]}
+		if len(groups[key]) == 0 {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+	removed := make(map[int]bool)
+	count := 0
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		loudest := group[0].onIndex
+		lastOff := group[0].offIndex
+		neverEnds := false
+		for _, p := range group {
+			velocity := t.Messages[p.onIndex].(*NoteOnEvent).Velocity
+			if velocity > t.Messages[loudest].(*NoteOnEvent).Velocity {
+				loudest = p.onIndex
+			}
+			if p.offIndex < 0 {
+				neverEnds = true
+			} else if p.offIndex > lastOff {
+				lastOff = p.offIndex
+			}
+		}
+		for _, p := range group {
+			if p.onIndex != loudest {
+				removed[p.onIndex] = true
+				count++
+			}
+			if (p.offIndex >= 0) && (neverEnds || (p.offIndex != lastOff)) {
+				removed[p.offIndex] = true
+			}
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	t.insertAndRemove(nil, removed)
+	return count
+}
+
+// Returns true if m is a pitch bend, aftertouch, channel pressure, or a
+// modulation (CC 1) or expression (CC 11) control change.
+func isExpressionEvent(m MIDIMessage) bool {
+	switch v := m.(type) {
+	case *PitchBendEvent, *AftertouchEvent, *ChannelPressureEvent:
+		return true
+	case *ControlChangeEvent:
+		return (v.ControllerNumber == 1) || (v.ControllerNumber == 11)
+	}
+	return false
+}
+
+// Removes every pitch bend, aftertouch, and channel pressure event from the
+// track, along with modulation (CC 1) and expression (CC 11) control changes.
+// The time deltas of removed events are added to the following events, so the
+// timing of the remaining events is unchanged. This is intended for players
+// that don't support expressive controls. Returns the number of events that
+// were removed.
+func (t *SMFTrack) StripExpression() int {
+	indices := t.FindEvents(isExpressionEvent)
+	if len(indices) == 0 {
+		return 0
+	}
+	removed := make(map[int]bool, len(indices))
+	for _, i := range indices {
+		removed[i] = true
+	}
+	t.insertAndRemove(nil, removed)
+	return len(indices)
+}
+
+// Returns the absolute time of the first note-on event in the file, in ticks,
+// and true. Returns false if the file contains no note-on events.
+func (f *SMFFile) firstNoteTick() (uint32, bool) {
+	found := false
+	first := uint32(0)
+	for _, t := range f.Tracks {
+		ticks := t.AbsoluteTicks()
+		for i, m := range t.Messages {
+			if !isNoteOn(m) {
+				continue
+			}
+			if !found || (ticks[i] < first) {
+				first = ticks[i]
+				found = true
+			}
+			break
+		}
+	}
+	return first, found
+}
+
+// Removes the given number of ticks from the start of the track. Any events
+// that occurred during the removed time are moved to tick 0, keeping their
+// original order.
+func (t *SMFTrack) trimStart(ticks uint32) {
+	for i, d := range t.TimeDeltas {
+		if d >= ticks {
+			t.TimeDeltas[i] = d - ticks
+			return
+		}
+		ticks -= d
+		t.TimeDeltas[i] = 0
+	}
+}
+
+// Removes any silence before the first note in the file, so that the first
+// note-on event in any track occurs at tick 0. Events before the first note,
+// such as tempo or time-signature changes, are kept, but moved to tick 0.
+// Returns the number of ticks that were removed.
+func (f *SMFFile) TrimLeadingSilence() uint32 {
+	first, found := f.firstNoteTick()
+	if !found || (first == 0) {
+		return 0
+	}
+	for _, t := range f.Tracks {
+		t.trimStart(first)
+	}
+	return first
+}
+
+// Moves every event in the file later by the given number of ticks, or
+// earlier if deltaTicks is negative. When moving events earlier, any events
+// that would occur before tick 0 are moved to tick 0 instead, keeping their
+// original order. The first time delta in each track is limited to the
+// maximum value that can be written to an SMF file.
+func (f *SMFFile) Shift(deltaTicks int32) {
+	if deltaTicks < 0 {
+		for _, t := range f.Tracks {
+			t.trimStart(uint32(-int64(deltaTicks)))
+		}
+		return
+	}
+	for _, t := range f.Tracks {
+		if len(t.TimeDeltas) == 0 {
+			continue
+		}
+		d := uint64(t.TimeDeltas[0]) + uint64(deltaTicks)
+		if d > 0x0fffffff {
+			d = 0x0fffffff
+		}
+		t.TimeDeltas[0] = uint32(d)
+	}
+}
+
+// The same as Shift, except that when moving events earlier, any notes that
+// would end at or before tick 0 are removed, rather than being moved to tick
+// 0. Notes that would start before tick 0 but end after it are shortened to
+// start at tick 0. Other events, such as tempo or program changes, are moved
+// to tick 0 as in Shift, since they affect later notes. Notes that are never
+// ended aren't removed. Returns the number of notes that were removed.
+func (f *SMFFile) ShiftAndDropEarlyNotes(deltaTicks int32) int {
+	if deltaTicks >= 0 {
+		f.Shift(deltaTicks)
+		return 0
+	}
+	amount := uint32(-int64(deltaTicks))
+	toReturn := 0
+	for _, t := range f.Tracks {
+		toReturn += t.trimStartAndDropNotes(amount)
+	}
+	return toReturn
+}
+
+// Like trimStart, but removes any notes that end at or before the new start
+// of the track. Returns the number of notes that were removed.
+func (t *SMFTrack) trimStartAndDropNotes(ticks uint32) int {
+	absoluteTicks := t.AbsoluteTicks()
+	removed := make(map[int]bool)
+	for _, p := range t.pairNotes() {
+		if (p.offIndex >= 0) && (absoluteTicks[p.offIndex] <= ticks) {
+			removed[p.onIndex] = true
+			removed[p.offIndex] = true
+		}
+	}
+	if len(removed) != 0 {
+		t.insertAndRemove(nil, removed)
+	}
+	t.trimStart(ticks)
+	return len(removed) / 2
+}
+
+// Returns a copy of the track containing only the events before the given
+// tick. Any notes still sounding at the tick are ended there. If the track
+// contains an end-of-track event, the copy's end-of-track event occurs at the
+// given tick.
+func (t *SMFTrack) truncatedCopy(tick uint32) *SMFTrack {
+	events := t.timedMessages()
+	var kept []timedMessage
+	for _, v := range events {
+		_, isEnd := v.message.(EndOfTrackMetaEvent)
+		if isEnd {
+			kept = append(kept, timedMessage{
+				tick:    tick,
+				message: v.message,
+			})
+			continue
+		}
+		if v.tick < tick {
+			kept = append(kept, timedMessage{
+				tick:    v.tick,
+				message: copyMessage(v.message),
+			})
+		}
+	}
+	for _, p := range t.pairNotes() {
+		if events[p.onIndex].tick >= tick {
+			continue
+		}
+		if (p.offIndex >= 0) && (events[p.offIndex].tick < tick) {
+			continue
+		}
+		noteOn := events[p.onIndex].message.(*NoteOnEvent)
+		like := events[p.onIndex].message
+		if p.offIndex >= 0 {
+			like = events[p.offIndex].message
+		}
+		kept = append(kept, timedMessage{
+			tick:    tick,
+			message: newNoteOffLike(like, noteOn.Channel, noteOn.Note),
+		})
+	}
+	toReturn := &SMFTrack{}
+	toReturn.setTimedMessages(kept)
+	return toReturn
+}
+
+// Splits the file into two files at the given absolute time, in ticks. The
+// "before" file contains every event before the tick, with note-offs added at
+// the tick for any notes that are still sounding. The "after" file contains
+// every event at or after the tick, moved earlier so that the split occurs at
+// tick 0. Notes that are sounding at the split are started at tick 0 in the
+// "after" file, so it isn't silent until the next note-on. Other events before
+// the split, such as tempo or program changes, are kept at tick 0 in the
+// "after" file, since they affect its notes. Both files have the same number
+// of tracks and the same division as the original, which isn't modified.
+// Returns an error if the tick is after the last event in the file.
+func (f *SMFFile) SplitAt(tick uint32) (before, after *SMFFile, err error) {
+	lastTick := f.lastEventTick()
+	if tick > lastTick {
+		return nil, nil, fmt.Errorf("Split point %d is after the end of the "+
+			"file (tick %d)", tick, lastTick)
+	}
+	before = &SMFFile{
+		Division:         f.Division,
+		Tracks:           make([]*SMFTrack, len(f.Tracks)),
+		Format:           f.Format,
+		ExtraHeaderBytes: append([]byte(nil), f.ExtraHeaderBytes...),
+	}
+	after = &SMFFile{
+		Division:         f.Division,
+		Tracks:           make([]*SMFTrack, len(f.Tracks)),
+		Format:           f.Format,
+		ExtraHeaderBytes: append([]byte(nil), f.ExtraHeaderBytes...),
+	}
+	for i, t := range f.Tracks {
+		before.Tracks[i] = t.truncatedCopy(tick)
+		after.Tracks[i] = t.Copy()
+		after.Tracks[i].trimStartAndDropNotes(tick)
+	}
+	return before, after, nil
+}
+
+// Reverses the track in time (a "retrograde"), so that the last note plays
+// first. Each note keeps its duration, but its note-on and note-off events
+// swap places. Events that don't start or end notes are mirrored in time as
+// well, except for those at tick 0 (such as tempo, time-signature, or
+// program-change events), which stay at the start of the track. The track's
+// total length is unchanged.
+func (t *SMFTrack) Reverse() {
+	events := t.timedMessages()
+	if len(events) == 0 {
+		return
+	}
+	end := events[len(events)-1].tick
+	pairs := t.pairNotes()
+	isNoteEvent := make([]bool, len(events))
+	// We'll keep note-offs before note-ons, so that a note ending at the same
+	// time another starts doesn't cut off the new note.
+	offEvents := make([]timedMessage, 0, len(pairs))
+	onEvents := make([]timedMessage, 0, len(pairs))
+	for _, p := range pairs {
+		isNoteEvent[p.onIndex] = true
+		start := events[p.onIndex].tick
+		noteEnd := end
+		if p.offIndex >= 0 {
+			isNoteEvent[p.offIndex] = true
+			noteEnd = events[p.offIndex].tick
+			offEvents = append(offEvents, timedMessage{
+				tick:    end - start,
+				message: events[p.offIndex].message,
+			})
+		} else {
+			noteOn := events[p.onIndex].message.(*NoteOnEvent)
+			offEvents = append(offEvents, timedMessage{
+				tick: end - start,
+				message: &NoteOnEvent{
+					Channel:  noteOn.Channel,
+					Note:     noteOn.Note,
+					Velocity: 0,
+				},
+			})
+		}
+		onEvents = append(onEvents, timedMessage{
+			tick:    end - noteEnd,
+			message: events[p.onIndex].message,
+		})
+	}
+	// Events that were originally simultaneous keep their relative order,
+	// since setTimedMessages uses a stable sort.
+	newEvents := make([]timedMessage, 0, len(events))
+	for i, v := range events {
+		if isNoteEvent[i] {
+			continue
+		}
+		if v.tick == 0 {
+			newEvents = append(newEvents, v)
+			continue
+		}
+		_, isEnd := v.message.(EndOfTrackMetaEvent)
+		if isEnd {
+			newEvents = append(newEvents, v)
+			continue
+		}
+		newEvents = append(newEvents, timedMessage{
+			tick:    end - v.tick,
+			message: v.message,
+		})
+	}
+	newEvents = append(newEvents, offEvents...)
+	newEvents = append(newEvents, onEvents...)
+	t.setTimedMessages(newEvents)
+}
+
+// Reflects the notes in the track around the given pivot note (a melodic
+// inversion), so that each note n becomes 2*pivot - n. This applies to
+// note-on, note-off, and aftertouch events. Notes whose inversion falls
+// outside of the valid range of 0-127 are left unchanged, so that their
+// note-on and note-off events continue to match. Events on the channels for
+// which percussion is true are also left unchanged; usually this will be the
+// result of SMFFile.PercussionChannels.
+func (t *SMFTrack) InvertPitch(pivot MIDINote, percussion [16]bool) {
+	invert := func(channel uint8, n *MIDINote) {
+		if percussion[channel&0xf] {
+			return
+		}
+		inverted := 2*int(pivot) - int(*n)
+		if (inverted < 0) || (inverted > 127) {
+			return
+		}
+		*n = MIDINote(inverted)
+	}
+	for _, m := range t.Messages {
+		switch v := m.(type) {
+		case *NoteOnEvent:
+			invert(v.Channel, &(v.Note))
+		case *NoteOffEvent:
+			invert(v.Channel, &(v.Note))
+		case *AftertouchEvent:
+			invert(v.Channel, &(v.Note))
+		}
+	}
+}
+
+// Adds a parallel harmony note for every note in the track, transposed by the
+// given number of semitones (which may be negative) and played on the given
+// channel. Each harmony note starts and ends at the same times as the note it
+// harmonizes, with the same velocities, and its events are inserted directly
+// after the original note's events. Harmony notes that would be outside of the
+// valid range are clamped to 0 or 127, and a clamped note is dropped if
+// another harmony note with the same pitch is still sounding when it starts.
+// Notes on the channels for which percussion is true aren't harmonized;
+// usually this will be the result of SMFFile.PercussionChannels. Note that a
+// harmony note may still overlap an existing note with the same channel and
+// pitch, which can be detected using OverlappingNotes.
+func (t *SMFTrack) AddHarmony(interval int, channel uint8,
+	percussion [16]bool) {
+	channel &= 0xf
+	ticks := t.AbsoluteTicks()
+	var inserted []insertedEvent
+	// The original notes of the harmony notes added so far, for each pitch.
+	var harmonized [128][]notePair
+	for _, p := range t.pairNotes() {
+		noteOn := t.Messages[p.onIndex].(*NoteOnEvent)
+		if percussion[noteOn.Channel&0xf] {
+			continue
+		}
+		harmony := int(noteOn.Note) + interval
+		clamped := false
+		if harmony < 0 {
+			harmony = 0
+			clamped = true
+		} else if harmony > 127 {
+			harmony = 127
+			clamped = true
+		}
+		if clamped && harmonyIsSounding(harmonized[harmony], p.onIndex) {
+			continue
+		}
+		harmonized[harmony] = append(harmonized[harmony], p)
+		inserted = append(inserted, insertedEvent{
+			index: p.onIndex + 1,
+			event: timedMessage{
+				tick: ticks[p.onIndex],
+				message: &NoteOnEvent{
+					Channel:  channel,
+					Note:     MIDINote(harmony),
+					Velocity: noteOn.Velocity,
+				},
+			},
+		})
+		if p.offIndex < 0 {
+			continue
+		}
+		inserted = append(inserted, insertedEvent{
+			index: p.offIndex + 1,
+			event: timedMessage{
+				tick: ticks[p.offIndex],
+				message: newNoteOffLike(t.Messages[p.offIndex], channel,
+					MIDINote(harmony)),
+			},
+		})
+	}
+	if len(inserted) == 0 {
+		return
+	}
+	sort.SliceStable(inserted, func(a, b int) bool {
+		return inserted[a].index < inserted[b].index
+	})
+	t.insertAndRemove(inserted, nil)
+}
+
+// Returns true if any of the given notes, which must start before the event
+// at the given index, hasn't been ended before that event. Used by AddHarmony,
+// where the harmony note's events directly follow the events of the original
+// notes.
+func harmonyIsSounding(notes []notePair, index int) bool {
+	for _, p := range notes {
+		if (p.offIndex < 0) || (p.offIndex > index) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a new format 1 file containing the same events as f, rearranged so
+// that the first track is a "conductor" track containing all of the meta and
+// sysex events, followed by one track for each channel used in f, in order of
+// channel number. Meta-events that are associated with a channel via a MIDI
+// channel prefix are placed in that channel's track instead (the channel
+// prefix events themselves are dropped). Each channel's track starts with a
+// track name event containing the General MIDI name for the channel's first
+// program change (or "Percussion" for the channels reported by
+// PercussionChannels). This is mainly intended for converting format 0 files
+// for use in notation software. The events in the new file are copies, so
+// modifying them won't affect f.
+func (f *SMFFile) ToType1ByChannel() *SMFFile {
+	var conductor []timedMessage
+	var channelEvents [16][]timedMessage
+	var channelPrograms [16]int
+	percussion := f.PercussionChannels()
+	for i := range channelPrograms {
+		channelPrograms[i] = -1
+	}
+	endTick := uint32(0)
+	for _, t := range f.Tracks {
+		prefixes := t.ResolveChannelPrefixes()
+		for j, v := range t.timedMessages() {
+			if v.tick > endTick {
+				endTick = v.tick
+			}
+			switch m := v.message.(type) {
+			case EndOfTrackMetaEvent, ChannelPrefixMetaEvent:
+				continue
+			case ChannelMessage:
+				c := m.GetChannel() & 0xf
+				channelEvents[c] = append(channelEvents[c], timedMessage{
+					tick:    v.tick,
+					message: copyMessage(m),
+				})
+				programChange, ok := m.(*ProgramChangeEvent)
+				if ok && (channelPrograms[c] < 0) {
+					channelPrograms[c] = int(programChange.Value & 0x7f)
+				}
+				continue
+			}
+			c, ok := prefixes[j]
+			if ok && (c <= 0xf) {
+				channelEvents[c] = append(channelEvents[c], timedMessage{
+					tick:    v.tick,
+					message: copyMessage(v.message),
+				})
+				continue
+			}
+			conductor = append(conductor, timedMessage{
+				tick:    v.tick,
+				message: copyMessage(v.message),
+			})
+		}
+	}
+	endOfTrack := timedMessage{
+		tick:    endTick,
+		message: EndOfTrackMetaEvent(0),
+	}
+	toReturn := &SMFFile{
+		Division: f.Division,
+		Tracks:   []*SMFTrack{{}},
+	}
+	toReturn.Tracks[0].setTimedMessages(append(conductor, endOfTrack))
+	for c, events := range channelEvents {
+		if len(events) == 0 {
+			continue
+		}
+		name := "Percussion"
+		if !percussion[c] {
+			program := channelPrograms[c]
+			if program < 0 {
+				program = 0
+			}
+			name = gm.InstrumentNames[program]
+		}
+		// The name goes first, so it will be the first event at tick 0.
+		trackEvents := make([]timedMessage, 0, len(events)+2)
+		trackEvents = append(trackEvents, timedMessage{
+			tick: 0,
+			message: &TextMetaEvent{
+				TextEventType: 0x03,
+				Data:          []byte(name),
+			},
+		})
+		trackEvents = append(trackEvents, events...)
+		trackEvents = append(trackEvents, endOfTrack)
+		track := &SMFTrack{}
+		track.setTimedMessages(trackEvents)
+		toReturn.Tracks = append(toReturn.Tracks, track)
+	}
+	return toReturn
+}
+
+// Selects which sounding note is ended when LimitPolyphony needs to free a
+// voice for a new note.
+type VoiceStealingStrategy int
+
+const (
+	// End the note that started earliest.
+	StealOldest VoiceStealingStrategy = iota
+	// End the note with the lowest velocity, or the earliest of those with
+	// the lowest velocity.
+	StealQuietest
+)
+
+// Tracks a sounding note for LimitPolyphony.
+type activeVoice struct {
+	track    int
+	onIndex  int
+	offIndex int
+	tick     uint32
+	velocity uint8
+	channel  uint8
+	note     MIDINote
+}
+
+// Holds an event to be inserted into a track before the event at the given
+// index.
+type insertedEvent struct {
+	index int
+	event timedMessage
+}
+
+// Inserts the given events into the track, and removes the events at the
+// indices in the removed map. The inserted events must be sorted by index,
+// and the time of each one must be between the times of the events before and
+// after its insertion point.
+func (t *SMFTrack) insertAndRemove(inserted []insertedEvent,
+	removed map[int]bool) {
+	events := t.timedMessages()
+	merged := make([]timedMessage, 0, len(events)+len(inserted))
+	for i, v := range events {
+		for (len(inserted) > 0) && (inserted[0].index <= i) {
+			merged = append(merged, inserted[0].event)
+			inserted = inserted[1:]
+		}
+		if !removed[i] {
+			merged = append(merged, v)
+		}
+	}
+	for _, v := range inserted {
+		merged = append(merged, v.event)
+	}
+	t.setTimedMessages(merged)
+}
+
+// Returns the index in the stolen voice's track before which its new note-off
+// should be inserted, given the note-on event that stole it. The note-off goes
+// after the stolen note's note-on, and before any later events in its track,
+// including the new note-on if it's in the same track.
+func stolenOffIndex(ticks []uint32, v activeVoice, event TimedEvent) int {
+	toReturn := sort.Search(len(ticks), func(i int) bool {
+		return ticks[i] >= event.Tick
+	})
+	if toReturn <= v.onIndex {
+		toReturn = v.onIndex + 1
+	}
+	if (event.Track == v.track) && (event.Index < toReturn) {
+		toReturn = event.Index
+	}
+	return toReturn
+}
+
+// Ensures that no more than maxVoices notes sound at the same time, across
+// all tracks in the file. Whenever a note-on event would exceed the limit, a
+// sounding note is chosen according to the given strategy and ended early, by
+// inserting a note-off at the time of the new note. (The stolen note's
+// original note-off is removed.) A stolen note that started at the same time
+// as the new note is removed entirely, rather than being left with a length of
+// zero. Returns the number of notes that were ended early or removed. Does
+// nothing if maxVoices is less than 1.
+func (f *SMFFile) LimitPolyphony(maxVoices int,
+	strategy VoiceStealingStrategy) int {
+	if maxVoices < 1 {
+		return 0
+	}
+	// Maps each track's note-on indices to the indices of their note-offs.
+	offIndices := make([]map[int]int, len(f.Tracks))
+	removed := make([]map[int]bool, len(f.Tracks))
+	inserted := make([][]insertedEvent, len(f.Tracks))
+	trackTicks := make([][]uint32, len(f.Tracks))
+	for i, t := range f.Tracks {
+		trackTicks[i] = t.AbsoluteTicks()
+		offIndices[i] = make(map[int]int)
+		for _, p := range t.pairNotes() {
+			offIndices[i][p.onIndex] = p.offIndex
+		}
+		removed[i] = make(map[int]bool)
+	}
+	var active []activeVoice
+	stolen := 0
+	events := f.TimedEvents()
+	groupStart := 0
+	for groupStart < len(events) {
+		groupEnd := groupStart + 1
+		for (groupEnd < len(events)) &&
+			(events[groupEnd].Tick == events[groupStart].Tick) {
+			groupEnd++
+		}
+		group := events[groupStart:groupEnd]
+		// Free the voices for any notes ending at this time first.
+		for _, event := range group {
+			for i, v := range active {
+				if (v.track == event.Track) && (v.offIndex == event.Index) {
+					active = append(active[:i], active[i+1:]...)
+					break
+				}
+			}
+		}
+		for _, event := range group {
+			if !isNoteOn(event.Message) {
+				continue
+			}
+			if len(active) >= maxVoices {
+				victim := 0
+				for i, v := range active {
+					if (strategy == StealQuietest) &&
+						(v.velocity < active[victim].velocity) {
+						victim = i
+					}
+				}
+				v := active[victim]
+				active = append(active[:victim], active[victim+1:]...)
+				stolen++
+				var like MIDIMessage
+				if v.offIndex >= 0 {
+					removed[v.track][v.offIndex] = true
+					like = f.Tracks[v.track].Messages[v.offIndex]
+				}
+				if v.tick == event.Tick {
+					// Don't leave a zero-length note; drop it entirely.
+					removed[v.track][v.onIndex] = true
+				} else {
+					inserted[v.track] = append(inserted[v.track],
+						insertedEvent{
+							index: stolenOffIndex(trackTicks[v.track], v,
+								event),
+							event: timedMessage{
+								tick: event.Tick,
+								message: newNoteOffLike(like, v.channel,
+									v.note),
+							},
+						})
+				}
+			}
+			noteOn := event.Message.(*NoteOnEvent)
+			// The active voices are kept in order of their start times.
+			active = append(active, activeVoice{
+				track:    event.Track,
+				onIndex:  event.Index,
+				offIndex: offIndices[event.Track][event.Index],
+				tick:     event.Tick,
+				velocity: noteOn.Velocity,
+				channel:  noteOn.Channel,
+				note:     noteOn.Note,
+			})
+		}
+		groupStart = groupEnd
+	}
+	for i, t := range f.Tracks {
+		if (len(inserted[i]) == 0) && (len(removed[i]) == 0) {
+			continue
+		}
+		sort.SliceStable(inserted[i], func(a, b int) bool {
+			return inserted[i][a].index < inserted[i][b].index
+		})
+		t.insertAndRemove(inserted[i], removed[i])
+	}
+	return stolen
+}
+
+// Snaps the duration of every note in the track to the nearest multiple of
+// gridTicks, by moving its note-off event. Notes shorter than minDuration
+// after rounding are lengthened to minDuration, and notes that would round to
+// a length of zero are given a length of gridTicks if minDuration is 0.
+// Regardless of rounding, a note will end no later than the start of the next
+// note with the same channel and pitch. Notes that are never ended are left
+// unchanged. Does nothing if gridTicks is 0.
+func (t *SMFTrack) QuantizeDurations(gridTicks uint32, minDuration uint32) {
+	if gridTicks == 0 {
+		return
+	}
+	events := t.timedMessages()
+	pairs := t.pairNotes()
+	// Find the start of the next note with the same channel and pitch as
+	// each note, by iterating over the notes in reverse order.
+	nextStarts := make([]uint32, len(pairs))
+	laterStarts := make(map[channelNote]uint32)
+	for i := len(pairs) - 1; i >= 0; i-- {
+		noteOn := events[pairs[i].onIndex].message.(*NoteOnEvent)
+		key := channelNote{noteOn.Channel, noteOn.Note}
+		next, ok := laterStarts[key]
+		if !ok {
+			next = 0xffffffff
+		}
+		nextStarts[i] = next
+		laterStarts[key] = events[pairs[i].onIndex].tick
+	}
+	for i, p := range pairs {
+		if p.offIndex < 0 {
+			continue
+		}
+		start := events[p.onIndex].tick
+		duration := uint64(events[p.offIndex].tick - start)
+		grid := uint64(gridTicks)
+		duration = ((duration + grid/2) / grid) * grid
+		if duration < uint64(minDuration) {
+			duration = uint64(minDuration)
+		}
+		if duration == 0 {
+			duration = grid
+		}
+		end := uint64(start) + duration
+		if end > uint64(nextStarts[i]) {
+			end = uint64(nextStarts[i])
+		}
+		events[p.offIndex].tick = uint32(end)
+	}
+	t.setTimedMessages(events)
+}
+
+// Changes the program number of every program change event in the file
+// according to the mapping. Program changes whose current program isn't a key
+// in the mapping are left unchanged. Returns the number of program changes
+// that were modified.
+func (f *SMFFile) RemapPrograms(mapping map[uint8]uint8) int {
+	toReturn := 0
+	for _, t := range f.Tracks {
+		for _, m := range t.Messages {
+			v, ok := m.(*ProgramChangeEvent)
+			if !ok {
+				continue
+			}
+			newProgram, ok := mapping[v.Value]
+			if !ok {
+				continue
+			}
+			v.Value = newProgram
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Changes the channel of every channel message in the file according to the
+// mapping, along with the channel in any MIDI channel prefix meta-events.
+// Events whose current channel isn't a key in the mapping are left unchanged.
+// The mapping is applied to every event in a single pass, so it may swap or
+// permute channels: for example, mapping 0 to 1 and 1 to 0 exchanges the two
+// channels. Returns the number of events that were modified. Returns an
+// error, without modifying the file, if the mapping contains a channel
+// greater than 15.
+func (f *SMFFile) RemapChannels(mapping map[uint8]uint8) (int, error) {
+	for from, to := range mapping {
+		if (from > 15) || (to > 15) {
+			return 0, fmt.Errorf("Invalid channel mapping: %d to %d", from,
+				to)
+		}
+	}
+	toReturn := 0
+	for _, t := range f.Tracks {
+		for i, m := range t.Messages {
+			switch v := m.(type) {
+			case ChannelMessage:
+				newChannel, ok := mapping[v.GetChannel()]
+				if !ok {
+					continue
+				}
+				e := v.SetChannel(newChannel)
+				if e != nil {
+					return toReturn, fmt.Errorf("Failed setting channel on "+
+						"%s: %s", m, e)
+				}
+				toReturn++
+			case ChannelPrefixMetaEvent:
+				newChannel, ok := mapping[uint8(v)]
+				if !ok {
+					continue
+				}
+				t.Messages[i] = ChannelPrefixMetaEvent(newChannel)
+				toReturn++
+			}
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns bank-select MSB (controller 0) and LSB (controller 32) control
+// change events, selecting the given 14-bit bank on the given channel.
+func bankSelectEvents(channel uint8, bank uint16) (MIDIMessage, MIDIMessage) {
+	msb := &ControlChangeEvent{
+		Channel:          channel,
+		ControllerNumber: 0,
+		Value:            uint8(bank>>7) & 0x7f,
+	}
+	lsb := &ControlChangeEvent{
+		Channel:          channel,
+		ControllerNumber: 32,
+		Value:            uint8(bank) & 0x7f,
+	}
+	return msb, lsb
+}
+
+// The same as RemapPrograms, but also inserts bank-select control changes
+// (controllers 0 and 32), selecting the given 14-bit bank, immediately before
+// each program change that is modified.
+func (f *SMFFile) RemapProgramsWithBank(mapping map[uint8]uint8,
+	bank uint16) int {
+	toReturn := 0
+	for _, t := range f.Tracks {
+		var inserted []insertedEvent
+		ticks := t.AbsoluteTicks()
+		for i, m := range t.Messages {
+			v, ok := m.(*ProgramChangeEvent)
+			if !ok {
+				continue
+			}
+			newProgram, ok := mapping[v.Value]
+			if !ok {
+				continue
+			}
+			v.Value = newProgram
+			toReturn++
+			msb, lsb := bankSelectEvents(v.Channel, bank)
+			inserted = append(inserted, insertedEvent{
+				index: i,
+				event: timedMessage{
+					tick:    ticks[i],
+					message: msb,
+				},
+			}, insertedEvent{
+				index: i,
+				event: timedMessage{
+					tick:    ticks[i],
+					message: lsb,
+				},
+			})
+		}
+		if len(inserted) != 0 {
+			t.insertAndRemove(inserted, nil)
+		}
+	}
+	return toReturn
+}
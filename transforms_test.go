@@ -0,0 +1,942 @@
+package midi
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestSplitNotesAtBars(t *testing.T) {
+	// A single note starting at tick 50 and ending at tick 250, with bars
+	// every 100 ticks. It should be split at ticks 100 and 200.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 1, Note: 60, Velocity: 90},
+			&NoteOnEvent{Channel: 1, Note: 60, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{50, 200, 0},
+	}
+	track.SplitNotesAtBars(100)
+	expectedDeltas := []uint32{50, 50, 0, 100, 0, 50, 0}
+	if len(track.TimeDeltas) != len(expectedDeltas) {
+		t.Logf("Expected %d events after splitting, got %d\n",
+			len(expectedDeltas), len(track.TimeDeltas))
+		t.FailNow()
+	}
+	for i, d := range expectedDeltas {
+		if track.TimeDeltas[i] != d {
+			t.Logf("Expected time delta %d for event %d, got %d\n", d, i,
+				track.TimeDeltas[i])
+			t.FailNow()
+		}
+	}
+	// Every other event, starting with the first, should be a note-on with
+	// the original velocity.
+	for i := 0; i < 6; i += 2 {
+		if !isNoteOn(track.Messages[i]) {
+			t.Logf("Expected event %d to be a note-on, got %s\n", i,
+				track.Messages[i])
+			t.FailNow()
+		}
+		if track.Messages[i].(*NoteOnEvent).Velocity != 90 {
+			t.Logf("Event %d didn't keep the original velocity\n", i)
+			t.FailNow()
+		}
+		_, _, isOff := noteOffInfo(track.Messages[i+1])
+		if !isOff {
+			t.Logf("Expected event %d to be a note-off, got %s\n", i+1,
+				track.Messages[i+1])
+			t.FailNow()
+		}
+	}
+	_, isEnd := track.Messages[6].(EndOfTrackMetaEvent)
+	if !isEnd {
+		t.Logf("The track didn't end with an end-of-track event\n")
+		t.FailNow()
+	}
+}
+
+func TestLimitPolyphony(t *testing.T) {
+	// Three overlapping notes, the second of which is the quietest.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 20},
+			&NoteOnEvent{Channel: 0, Note: 67, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			&NoteOffEvent{Channel: 0, Note: 64},
+			&NoteOffEvent{Channel: 0, Note: 67},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 100, 0, 0, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	stolen := smf.LimitPolyphony(2, StealQuietest)
+	if stolen != 1 {
+		t.Logf("Expected 1 stolen note, got %d\n", stolen)
+		t.FailNow()
+	}
+	if smf.MaxPolyphony() != 2 {
+		t.Logf("Expected a polyphony of 2, got %d\n", smf.MaxPolyphony())
+		t.FailNow()
+	}
+	// The quiet note should now end at tick 20, right before the third note.
+	off, ok := track.Messages[2].(*NoteOffEvent)
+	if !ok || (off.Note != 64) || (track.TimeDeltas[2] != 10) {
+		t.Logf("Expected the quiet note to end at tick 20, got %s\n",
+			track.Messages[2])
+		t.FailNow()
+	}
+}
+
+func TestLimitPolyphonySameTick(t *testing.T) {
+	// Two notes in different tracks start at the same time. With only one
+	// voice, the first is stolen as soon as it starts, so it should be
+	// removed from its track, even though nothing is inserted there.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOffEvent{Channel: 0, Note: 60},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0},
+			},
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 1, Note: 64, Velocity: 100},
+					&NoteOffEvent{Channel: 1, Note: 64},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96, 0},
+			},
+		},
+	}
+	stolen := smf.LimitPolyphony(1, StealOldest)
+	if stolen != 1 {
+		t.Logf("Expected 1 stolen note, got %d\n", stolen)
+		t.FailNow()
+	}
+	if smf.Tracks[0].HasNotes() || (len(smf.Tracks[0].Messages) != 1) {
+		t.Logf("The stolen note wasn't removed from the first track\n")
+		t.FailNow()
+	}
+	if smf.Tracks[0].TimeDeltas[0] != 96 {
+		t.Logf("The first track's length changed to %d\n",
+			smf.Tracks[0].TimeDeltas[0])
+		t.FailNow()
+	}
+	if len(smf.Tracks[1].Messages) != 3 {
+		t.Logf("The second track's note was modified\n")
+		t.FailNow()
+	}
+	if smf.MaxPolyphony() != 1 {
+		t.Logf("Expected a polyphony of 1, got %d\n", smf.MaxPolyphony())
+		t.FailNow()
+	}
+}
+
+func TestFixOverlappingNotes(t *testing.T) {
+	// The same note started twice, then ended twice.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOffEvent{Channel: 2, Note: 60},
+			&NoteOffEvent{Channel: 2, Note: 60},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 10, 0},
+	}
+	overlaps := track.OverlappingNotes()
+	if (len(overlaps) != 1) || (overlaps[0].FirstIndex != 0) ||
+		(overlaps[0].SecondIndex != 1) {
+		t.Logf("Got incorrect overlapping notes: %v\n", overlaps)
+		t.FailNow()
+	}
+	if track.FixOverlappingNotes() != 1 {
+		t.Logf("Expected to insert 1 note-off\n")
+		t.FailNow()
+	}
+	if len(track.OverlappingNotes()) != 0 {
+		t.Logf("The track still has overlapping notes after fixing\n")
+		t.FailNow()
+	}
+	expectedDeltas := []uint32{0, 10, 0, 20, 0}
+	for i, d := range expectedDeltas {
+		if track.TimeDeltas[i] != d {
+			t.Logf("Expected time delta %d for event %d, got %d\n", d, i,
+				track.TimeDeltas[i])
+			t.FailNow()
+		}
+	}
+}
+
+func TestFixOverlappingNotesSingleOff(t *testing.T) {
+	// The same note started twice, but only ended once.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 2, Note: 60, Velocity: 100},
+			&NoteOffEvent{Channel: 2, Note: 60},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 0},
+	}
+	if track.FixOverlappingNotes() != 1 {
+		t.Logf("Expected to insert 1 note-off\n")
+		t.FailNow()
+	}
+	pairs := track.pairNotes()
+	if len(pairs) != 2 {
+		t.Logf("Expected 2 notes after fixing, got %d\n", len(pairs))
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	for i, p := range pairs {
+		if p.offIndex < 0 {
+			t.Logf("Note %d is never ended after fixing\n", i)
+			t.FailNow()
+		}
+		if (ticks[p.onIndex] != uint32(i*10)) ||
+			(ticks[p.offIndex] != uint32(i*10+10)) {
+			t.Logf("Note %d has incorrect times after fixing\n", i)
+			t.FailNow()
+		}
+	}
+}
+
+func TestQuantizeDurations(t *testing.T) {
+	// Three notes: one 50 ticks long, one 5 ticks long, and one that would be
+	// lengthened to overlap the following note with the same pitch.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 62},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 64},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 64},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 50, 50, 5, 95, 90, 2, 100, 0},
+	}
+	track.QuantizeDurations(24, 12)
+	expected := []uint32{48, 12, 92}
+	ticks := track.AbsoluteTicks()
+	for i, p := range track.pairNotes()[:3] {
+		duration := ticks[p.offIndex] - ticks[p.onIndex]
+		if duration != expected[i] {
+			t.Logf("Expected note %d to last %d ticks, got %d\n", i,
+				expected[i], duration)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRemapProgramsWithBank(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ProgramChangeEvent{Channel: 1, Value: 0},
+			&ProgramChangeEvent{Channel: 2, Value: 40},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{track},
+	}
+	count := smf.RemapProgramsWithBank(map[uint8]uint8{40: 5}, 0x81)
+	if count != 1 {
+		t.Logf("Expected 1 remapped program, got %d\n", count)
+		t.FailNow()
+	}
+	if len(track.Messages) != 5 {
+		t.Logf("Expected 5 events after remapping, got %d\n",
+			len(track.Messages))
+		t.FailNow()
+	}
+	msb, ok := track.Messages[1].(*ControlChangeEvent)
+	if !ok || (msb.ControllerNumber != 0) || (msb.Value != 1) ||
+		(track.TimeDeltas[1] != 10) {
+		t.Logf("Didn't get the expected bank select MSB\n")
+		t.FailNow()
+	}
+	lsb, ok := track.Messages[2].(*ControlChangeEvent)
+	if !ok || (lsb.ControllerNumber != 32) || (lsb.Value != 1) {
+		t.Logf("Didn't get the expected bank select LSB\n")
+		t.FailNow()
+	}
+	program := track.Messages[3].(*ProgramChangeEvent)
+	if (program.Value != 5) || (track.TimeDeltas[3] != 0) {
+		t.Logf("The program change wasn't remapped correctly\n")
+		t.FailNow()
+	}
+}
+
+func TestShift(t *testing.T) {
+	original := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ProgramChangeEvent{Channel: 0, Value: 3},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+			&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 62, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 0, 20, 0},
+	}
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks:   []*SMFTrack{original.Copy()},
+	}
+	smf.Shift(50)
+	if smf.Tracks[0].TimeDeltas[0] != 50 {
+		t.Logf("Expected the first event to be delayed by 50 ticks\n")
+		t.FailNow()
+	}
+	smf.Tracks[0] = original.Copy()
+	smf.Shift(-15)
+	ticks := smf.Tracks[0].AbsoluteTicks()
+	if (ticks[1] != 0) || (ticks[2] != 5) || (ticks[4] != 25) {
+		t.Logf("Got incorrect times after shifting earlier: %v\n", ticks)
+		t.FailNow()
+	}
+	smf.Tracks[0] = original.Copy()
+	dropped := smf.ShiftAndDropEarlyNotes(-20)
+	track := smf.Tracks[0]
+	if (dropped != 1) || (len(track.Messages) != 4) {
+		t.Logf("Expected to drop 1 note, dropped %d\n", dropped)
+		t.FailNow()
+	}
+	if _, ok := track.Messages[0].(*ProgramChangeEvent); !ok {
+		t.Logf("The program change wasn't kept\n")
+		t.FailNow()
+	}
+	if track.TimeDeltas[2] != 20 {
+		t.Logf("The remaining note has the wrong length\n")
+		t.FailNow()
+	}
+}
+
+func TestCompressVelocity(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 40},
+			&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 127},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+			&NoteOffEvent{Channel: 0, Note: 62, Velocity: 120},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 10, 0, 0},
+	}
+	track.CompressVelocity(4, 80)
+	expected := []uint8{40, 85, 92, 0, 120}
+	for i, velocity := range expected {
+		var got uint8
+		switch v := track.Messages[i].(type) {
+		case *NoteOnEvent:
+			got = v.Velocity
+		case *NoteOffEvent:
+			got = v.Velocity
+		}
+		if got != velocity {
+			t.Logf("Expected velocity %d for event %d, got %d\n", velocity,
+				i, got)
+			t.FailNow()
+		}
+	}
+}
+
+func TestDedupeSimultaneousNotes(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 80},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 1, Note: 60, Velocity: 90},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			&NoteOffEvent{Channel: 1, Note: 60},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 0, 96, 0, 24, 0},
+	}
+	removed := track.DedupeSimultaneousNotes()
+	if removed != 1 {
+		t.Logf("Expected 1 note to be removed, got %d\n", removed)
+		t.FailNow()
+	}
+	if len(track.Messages) != 5 {
+		t.Logf("Expected 5 remaining events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	kept := track.Messages[0].(*NoteOnEvent)
+	if kept.Velocity != 100 {
+		t.Logf("Didn't keep the loudest note: %s\n", kept)
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	if _, ok := track.Messages[3].(*NoteOffEvent); !ok || (ticks[3] != 120) {
+		t.Logf("The kept note doesn't end at tick 120\n")
+		t.FailNow()
+	}
+	if track.DedupeSimultaneousNotes() != 0 {
+		t.Logf("Removed notes from a track without duplicates\n")
+		t.FailNow()
+	}
+}
+
+func TestAddHarmony(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&NoteOnEvent{Channel: 9, Note: 38, Velocity: 90},
+			&NoteOffEvent{Channel: 0, Note: 60, Velocity: 40},
+			&NoteOnEvent{Channel: 9, Note: 38, Velocity: 0},
+			&NoteOnEvent{Channel: 0, Note: 125, Velocity: 80},
+			&NoteOnEvent{Channel: 0, Note: 125, Velocity: 0},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 96, 0, 0, 96, 0},
+	}
+	var percussion [16]bool
+	percussion[9] = true
+	track.AddHarmony(4, 1, percussion)
+	if len(track.Messages) != 11 {
+		t.Logf("Expected 11 events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	harmony := track.Messages[1].(*NoteOnEvent)
+	if (harmony.Channel != 1) || (harmony.Note != 64) ||
+		(harmony.Velocity != 100) {
+		t.Logf("Got incorrect harmony note: %s\n", harmony)
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	var harmonyOff *NoteOffEvent
+	for i, m := range track.Messages {
+		v, ok := m.(*NoteOffEvent)
+		if ok && (v.Channel == 1) {
+			harmonyOff = v
+			if ticks[i] != 96 {
+				t.Logf("The harmony note ends at the wrong time\n")
+				t.FailNow()
+			}
+		}
+		noteOn, ok := m.(*NoteOnEvent)
+		if ok && (noteOn.Channel == 1) && (noteOn.Note == 38+4) {
+			t.Logf("Harmonized a percussion note\n")
+			t.FailNow()
+		}
+	}
+	if (harmonyOff == nil) || (harmonyOff.Velocity != 40) {
+		t.Logf("Didn't get a matching harmony note-off\n")
+		t.FailNow()
+	}
+	clamped := track.Messages[len(track.Messages)-4].(*NoteOnEvent)
+	if (clamped.Channel != 1) || (clamped.Note != 127) {
+		t.Logf("Expected a harmony note clamped to 127, got %s\n", clamped)
+		t.FailNow()
+	}
+}
+
+func TestAddHarmonyClampedCollision(t *testing.T) {
+	// Two overlapping notes whose harmony notes are both clamped to 127,
+	// followed by one starting after the first harmony note has ended.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 125, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 126, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 125},
+			&NoteOffEvent{Channel: 0, Note: 126},
+			&NoteOnEvent{Channel: 0, Note: 124, Velocity: 100},
+			&NoteOffEvent{Channel: 0, Note: 124},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 10, 0, 10, 0},
+	}
+	track.AddHarmony(12, 1, [16]bool{})
+	if len(track.Messages) != 11 {
+		t.Logf("Expected 11 events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	var harmonyOns []int
+	for i, m := range track.Messages {
+		v, ok := m.(*NoteOnEvent)
+		if !ok || (v.Channel != 1) {
+			continue
+		}
+		if v.Note != 127 {
+			t.Logf("Expected the harmony note to be clamped: %s\n", v)
+			t.FailNow()
+		}
+		harmonyOns = append(harmonyOns, i)
+	}
+	if (len(harmonyOns) != 2) || (harmonyOns[0] != 1) ||
+		(harmonyOns[1] != 7) {
+		t.Logf("Expected harmony notes at indices 1 and 7, got %v\n",
+			harmonyOns)
+		t.FailNow()
+	}
+	if len(track.OverlappingNotes()) != 0 {
+		t.Logf("The clamped harmony notes overlap\n")
+		t.FailNow()
+	}
+}
+
+func TestStripExpression(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&PitchBendEvent{Channel: 0, Value: 0x2100},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 64},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 7, Value: 100},
+			&ChannelPressureEvent{Channel: 0, Value: 50},
+			&NoteOffEvent{Channel: 0, Note: 60},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 10, 10, 10, 0},
+	}
+	removed := track.StripExpression()
+	if removed != 3 {
+		t.Logf("Expected 3 events to be removed, got %d\n", removed)
+		t.FailNow()
+	}
+	if len(track.Messages) != 4 {
+		t.Logf("Expected 4 remaining events, got %d\n", len(track.Messages))
+		t.FailNow()
+	}
+	ticks := track.AbsoluteTicks()
+	if (ticks[1] != 30) || (ticks[2] != 50) {
+		t.Logf("Remaining events have incorrect times: %v\n", ticks)
+		t.FailNow()
+	}
+	if _, ok := track.Messages[1].(*ControlChangeEvent); !ok {
+		t.Logf("The volume control change wasn't kept\n")
+		t.FailNow()
+	}
+	if track.StripExpression() != 0 {
+		t.Logf("Removed events from a track without expression events\n")
+		t.FailNow()
+	}
+}
+
+func TestSplitAt(t *testing.T) {
+	// A tempo change, then a note from 0 to 100, a note from 50 to 150, and a
+	// note from 150 to 200. The file will be split at tick 100.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(400000),
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 90},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					&NoteOffEvent{Channel: 0, Note: 62, Velocity: 20},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 80},
+					&NoteOnEvent{Channel: 0, Note: 64, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 50, 50, 50, 0, 50, 0},
+			},
+		},
+	}
+	_, _, e := smf.SplitAt(201)
+	if e == nil {
+		t.Logf("Didn't get an error when splitting after the end\n")
+		t.FailNow()
+	}
+	before, after, e := smf.SplitAt(100)
+	if e != nil {
+		t.Logf("Failed splitting file: %s\n", e)
+		t.FailNow()
+	}
+	if len(smf.Tracks[0].Messages) != 8 {
+		t.Logf("The original file was modified\n")
+		t.FailNow()
+	}
+	b := before.Tracks[0]
+	notes := before.Notes()
+	if (len(notes) != 2) || (notes[0].Duration != 100) ||
+		(notes[1].Duration != 50) {
+		t.Logf("Got incorrect notes before the split: %+v\n", notes)
+		t.FailNow()
+	}
+	offTicks := b.AbsoluteTicks()
+	if offTicks[len(offTicks)-1] != 100 {
+		t.Logf("The first half doesn't end at the split\n")
+		t.FailNow()
+	}
+	a := after.Tracks[0]
+	if _, ok := a.Messages[0].(SetTempoMetaEvent); !ok {
+		t.Logf("The tempo wasn't kept in the second half\n")
+		t.FailNow()
+	}
+	notes = after.Notes()
+	if len(notes) != 2 {
+		t.Logf("Expected 2 notes after the split, got %d\n", len(notes))
+		t.FailNow()
+	}
+	if (notes[0].Pitch != 62) || (notes[0].Tick != 0) ||
+		(notes[0].Duration != 50) || (notes[0].Velocity != 90) {
+		t.Logf("The sustained note wasn't restarted: %+v\n", notes[0])
+		t.FailNow()
+	}
+	if (notes[1].Pitch != 64) || (notes[1].Tick != 50) {
+		t.Logf("Got incorrect final note: %+v\n", notes[1])
+		t.FailNow()
+	}
+}
+
+func TestRemapChannels(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					ChannelPrefixMetaEvent(0),
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 1, Note: 62, Velocity: 100},
+					&NoteOnEvent{Channel: 2, Note: 64, Velocity: 100},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 0, 0, 0},
+			},
+		},
+	}
+	_, e := smf.RemapChannels(map[uint8]uint8{0: 16})
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid channel\n")
+		t.FailNow()
+	}
+	// Mapping 0 to 1 and 1 to 2 should move each event only once.
+	count, e := smf.RemapChannels(map[uint8]uint8{0: 1, 1: 2, 2: 0})
+	if e != nil {
+		t.Logf("Failed remapping channels: %s\n", e)
+		t.FailNow()
+	}
+	if count != 4 {
+		t.Logf("Expected 4 modified events, got %d\n", count)
+		t.FailNow()
+	}
+	messages := smf.Tracks[0].Messages
+	if messages[0].(ChannelPrefixMetaEvent) != 1 {
+		t.Logf("The channel prefix wasn't remapped\n")
+		t.FailNow()
+	}
+	expected := []uint8{1, 2, 0}
+	for i, channel := range expected {
+		v := messages[i+1].(*NoteOnEvent)
+		if v.Channel != channel {
+			t.Logf("Expected %s to be on channel %d\n", v, channel)
+			t.FailNow()
+		}
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	// Four quarter notes, 100 ticks apart and 50 ticks long.
+	track := &SMFTrack{}
+	for i := 0; i < 4; i++ {
+		delta := uint32(50)
+		if i == 0 {
+			delta = 100
+		}
+		track.Append(delta, &NoteOnEvent{Channel: 0, Note: MIDINote(60 + i),
+			Velocity: 100})
+		track.Append(50, &NoteOffEvent{Channel: 0, Note: MIDINote(60 + i),
+			Velocity: 64})
+	}
+	track.Append(0, EndOfTrackMetaEvent(0))
+	track.Humanize(10, 5, rand.New(rand.NewSource(1337)))
+	ticks := track.AbsoluteTicks()
+	pairs := track.pairNotes()
+	if len(pairs) != 4 {
+		t.Logf("Expected 4 notes after humanizing, got %d\n", len(pairs))
+		t.FailNow()
+	}
+	for _, p := range pairs {
+		on := track.Messages[p.onIndex].(*NoteOnEvent)
+		original := int64(100 * (int(on.Note) - 59))
+		start := int64(ticks[p.onIndex])
+		if (start < (original - 10)) || (start > (original + 10)) {
+			t.Logf("Note %d moved too far: from tick %d to %d\n", on.Note,
+				original, start)
+			t.FailNow()
+		}
+		if (ticks[p.offIndex] - ticks[p.onIndex]) != 50 {
+			t.Logf("Note %d's duration changed to %d\n", on.Note,
+				ticks[p.offIndex]-ticks[p.onIndex])
+			t.FailNow()
+		}
+		if (on.Velocity < 95) || (on.Velocity > 105) {
+			t.Logf("Note %d's velocity changed too much: %d\n", on.Note,
+				on.Velocity)
+			t.FailNow()
+		}
+	}
+	if !track.IsTimeOrdered() {
+		t.Logf("The humanized track isn't time ordered\n")
+		t.FailNow()
+	}
+
+	// A note at the start of the track can't be moved before tick 0.
+	track = &SMFTrack{}
+	track.Append(0, &NoteOnEvent{Channel: 0, Note: 60, Velocity: 127})
+	track.Append(10, &NoteOffEvent{Channel: 0, Note: 60})
+	track.Append(0, EndOfTrackMetaEvent(0))
+	for seed := int64(0); seed < 20; seed++ {
+		c := track.Copy()
+		c.Humanize(100, 100, rand.New(rand.NewSource(seed)))
+		v := c.Messages[0].(*NoteOnEvent).Velocity
+		if (v < 1) || (v > 127) {
+			t.Logf("Humanized velocity %d is out of range\n", v)
+			t.FailNow()
+		}
+		ticks := c.AbsoluteTicks()
+		if (ticks[1] - ticks[0]) != 10 {
+			t.Logf("Humanizing changed the note duration to %d\n",
+				ticks[1]-ticks[0])
+			t.FailNow()
+		}
+	}
+}
+
+func TestTrimLeadingSilence(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					SetTempoMetaEvent(1000000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 100, 200},
+			},
+			{
+				Messages: []MIDIMessage{
+					&ProgramChangeEvent{Channel: 0, Value: 4},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{50, 150, 96, 0},
+			},
+		},
+	}
+	removed := smf.TrimLeadingSilence()
+	if removed != 200 {
+		t.Logf("Expected to remove 200 ticks, removed %d\n", removed)
+		t.FailNow()
+	}
+	expected := [][]uint32{
+		{0, 0, 100},
+		{0, 0, 96, 0},
+	}
+	for i, deltas := range expected {
+		for j, d := range deltas {
+			if smf.Tracks[i].TimeDeltas[j] != d {
+				t.Logf("Expected time delta %d for track %d event %d, "+
+					"got %d\n", d, i, j, smf.Tracks[i].TimeDeltas[j])
+				t.FailNow()
+			}
+		}
+	}
+	if smf.TrimLeadingSilence() != 0 {
+		t.Logf("Trimming the silence twice removed more ticks\n")
+		t.FailNow()
+	}
+}
+
+func TestReverse(t *testing.T) {
+	// Note 60 plays from 0 to 96, and note 64 from 96 to 144. A CC at 24 and
+	// the program change at tick 0 are also included, and the track ends at
+	// tick 192.
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&ProgramChangeEvent{Channel: 0, Value: 4},
+			&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+			&ControlChangeEvent{Channel: 0, ControllerNumber: 1, Value: 10},
+			&NoteOffEvent{Channel: 0, Note: 60, Velocity: 64},
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 90},
+			&NoteOffEvent{Channel: 0, Note: 64, Velocity: 64},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 0, 24, 72, 0, 48, 48},
+	}
+	track.Reverse()
+	ticks := track.AbsoluteTicks()
+	if ticks[len(ticks)-1] != 192 {
+		t.Logf("The reversed track's length changed to %d\n",
+			ticks[len(ticks)-1])
+		t.FailNow()
+	}
+	if _, ok := track.Messages[0].(*ProgramChangeEvent); !ok {
+		t.Logf("The program change didn't stay at the start of the track\n")
+		t.FailNow()
+	}
+	found := map[string]uint32{}
+	for i, m := range track.Messages {
+		switch v := m.(type) {
+		case *NoteOnEvent:
+			found[fmt.Sprintf("on %d", v.Note)] = ticks[i]
+		case *NoteOffEvent:
+			found[fmt.Sprintf("off %d", v.Note)] = ticks[i]
+		case *ControlChangeEvent:
+			found["cc"] = ticks[i]
+		}
+	}
+	expected := map[string]uint32{
+		"on 64":  48,
+		"off 64": 96,
+		"on 60":  96,
+		"off 60": 192,
+		"cc":     168,
+	}
+	for k, v := range expected {
+		if found[k] != v {
+			t.Logf("Expected %s at tick %d, got %d\n", k, v, found[k])
+			t.FailNow()
+		}
+	}
+	// Note 64 ends at the same time note 60 starts, so the note-off must come
+	// first.
+	pairs := track.pairNotes()
+	if (len(pairs) != 2) || (pairs[0].offIndex < 0) ||
+		(pairs[1].offIndex < 0) {
+		t.Logf("The reversed notes weren't paired correctly\n")
+		t.FailNow()
+	}
+}
+
+func TestInvertPitch(t *testing.T) {
+	track := &SMFTrack{
+		Messages: []MIDIMessage{
+			&NoteOnEvent{Channel: 0, Note: 64, Velocity: 100},
+			&AftertouchEvent{Channel: 0, Note: 64, Pressure: 10},
+			&NoteOffEvent{Channel: 0, Note: 64, Velocity: 64},
+			// Inverting 10 around 70 would go past 127, so it's unchanged.
+			&NoteOnEvent{Channel: 0, Note: 10, Velocity: 100},
+			&NoteOnEvent{Channel: 0, Note: 10, Velocity: 0},
+			// Channel 3 is marked as a percussion channel below.
+			&NoteOnEvent{Channel: 3, Note: 38, Velocity: 100},
+			&NoteOffEvent{Channel: 3, Note: 38},
+			EndOfTrackMetaEvent(0),
+		},
+		TimeDeltas: []uint32{0, 10, 10, 0, 10, 0, 10, 0},
+	}
+	var percussion [16]bool
+	percussion[3] = true
+	track.InvertPitch(70, percussion)
+	expected := []MIDINote{76, 76, 76, 10, 10, 38, 38}
+	for i, n := range expected {
+		var got MIDINote
+		switch v := track.Messages[i].(type) {
+		case *NoteOnEvent:
+			got = v.Note
+		case *NoteOffEvent:
+			got = v.Note
+		case *AftertouchEvent:
+			got = v.Note
+		}
+		if got != n {
+			t.Logf("Expected note %d for event %d, got %d\n", n, i, got)
+			t.FailNow()
+		}
+	}
+}
+
+func TestToType1ByChannel(t *testing.T) {
+	original := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					ChannelPrefixMetaEvent(2),
+					&TextMetaEvent{TextEventType: 4, Data: []byte("Keys")},
+					&ProgramChangeEvent{Channel: 0, Value: 40},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 9, Note: 36, Velocity: 100},
+					&NoteOnEvent{Channel: 2, Note: 48, Velocity: 100},
+					&NoteOffEvent{Channel: 0, Note: 60},
+					&NoteOffEvent{Channel: 9, Note: 36},
+					&NoteOffEvent{Channel: 2, Note: 48},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0, 0, 0, 0, 0, 0, 96, 0, 0, 0},
+			},
+		},
+	}
+	smf := original.ToType1ByChannel()
+	if len(smf.Tracks) != 4 {
+		t.Logf("Expected 4 tracks, got %d\n", len(smf.Tracks))
+		t.FailNow()
+	}
+	if !smf.Tracks[0].IsConductorTrack() ||
+		(len(smf.Tracks[0].Messages) != 2) {
+		t.Logf("Expected a conductor track with only the tempo event\n")
+		t.FailNow()
+	}
+	expected := []struct {
+		name    string
+		channel uint8
+		events  int
+	}{
+		{"Violin", 0, 5},
+		{"Acoustic Grand Piano", 2, 5},
+		{"Percussion", 9, 4},
+	}
+	for i, v := range expected {
+		track := smf.Tracks[i+1]
+		if track.Name() != v.name {
+			t.Logf("Expected track %d to be named %q, got %q\n", i+1,
+				v.name, track.Name())
+			t.FailNow()
+		}
+		if len(track.Messages) != v.events {
+			t.Logf("Expected %d events in track %d, got %d\n", v.events,
+				i+1, len(track.Messages))
+			t.FailNow()
+		}
+		for _, m := range track.Messages {
+			c, ok := m.(ChannelMessage)
+			if ok && (c.GetChannel() != v.channel) {
+				t.Logf("Track %d contains an event on the wrong channel: "+
+					"%s\n", i+1, m)
+				t.FailNow()
+			}
+		}
+		ticks := track.AbsoluteTicks()
+		if ticks[len(ticks)-1] != 96 {
+			t.Logf("Track %d ends at tick %d, expected 96\n", i+1,
+				ticks[len(ticks)-1])
+			t.FailNow()
+		}
+	}
+	// The instrument name meta-event follows its channel prefix.
+	text, ok := smf.Tracks[2].Messages[1].(*TextMetaEvent)
+	if !ok || (string(text.Data) != "Keys") {
+		t.Logf("The channel-prefixed text wasn't moved to channel 2's "+
+			"track: %s\n", smf.Tracks[2].Messages[1])
+		t.FailNow()
+	}
+	// The new file's events are copies.
+	smf.Tracks[1].Messages[2].(*NoteOnEvent).Note = 61
+	if original.Tracks[0].Messages[4].(*NoteOnEvent).Note != 60 {
+		t.Logf("Modifying the new file changed the original\n")
+		t.FailNow()
+	}
+}
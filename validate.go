@@ -0,0 +1,200 @@
+package midi
+
+// This file contains functions for finding and fixing structural problems in
+// SMF files.
+
+import (
+	"fmt"
+)
+
+// Describes a single structural problem found by SMFFile.Validate.
+type ValidationIssue struct {
+	// The index of the track containing the problem, or -1 if the problem
+	// applies to the entire file.
+	Track int
+	// The index of the event with the problem, or -1 if the problem applies
+	// to the entire track.
+	Index       int
+	Description string
+}
+
+func (v *ValidationIssue) String() string {
+	if v.Track < 0 {
+		return v.Description
+	}
+	if v.Index < 0 {
+		return fmt.Sprintf("Track %d: %s", v.Track, v.Description)
+	}
+	return fmt.Sprintf("Track %d, event %d: %s", v.Track, v.Index,
+		v.Description)
+}
+
+// Checks the file for structural problems, without modifying it, and returns
+// a list of every problem found, sorted by track. Returns nil if no problems
+// were found. Problems include tracks with different numbers of messages and
+// time deltas, events that can't be written (e.g. due to out-of-range data),
+// events following the end-of-track event or tracks without one, time deltas
+// that overflow the track's absolute time, notes that are never ended, and
+// files without any tracks. Most of these problems can be fixed using Repair.
+func (f *SMFFile) Validate() []ValidationIssue {
+	var toReturn []ValidationIssue
+	if len(f.Tracks) == 0 {
+		toReturn = append(toReturn, ValidationIssue{
+			Track:       -1,
+			Index:       -1,
+			Description: "The file doesn't contain any tracks",
+		})
+	}
+	for i, t := range f.Tracks {
+		issue := func(index int, format string, args ...interface{}) {
+			toReturn = append(toReturn, ValidationIssue{
+				Track:       i,
+				Index:       index,
+				Description: fmt.Sprintf(format, args...),
+			})
+		}
+		if len(t.Messages) != len(t.TimeDeltas) {
+			issue(-1, "Contains %d messages but %d time deltas",
+				len(t.Messages), len(t.TimeDeltas))
+			continue
+		}
+		endIndex := -1
+		for j, m := range t.Messages {
+			if v, ok := m.(UndefinedSystemEvent); ok {
+				issue(j, "Undefined status byte 0x%02x", uint8(v))
+				continue
+			}
+			runningStatus := byte(0)
+			_, e := m.SMFData(&runningStatus)
+			if e != nil {
+				issue(j, "Invalid event: %s", e)
+			}
+			if _, isEnd := m.(EndOfTrackMetaEvent); isEnd && (endIndex < 0) {
+				endIndex = j
+			}
+		}
+		if endIndex < 0 {
+			issue(-1, "Missing an end-of-track event")
+		} else if endIndex != (len(t.Messages) - 1) {
+			issue(endIndex+1, "Event follows the end-of-track event")
+		}
+		total := uint64(0)
+		for j, d := range t.TimeDeltas {
+			total += uint64(d)
+			if total > 0xffffffff {
+				issue(j, "Absolute time overflows 32 bits")
+				break
+			}
+		}
+		for _, p := range t.pairNotes() {
+			if p.offIndex < 0 {
+				issue(p.onIndex, "Note is never ended: %s",
+					t.Messages[p.onIndex])
+			}
+		}
+	}
+	return toReturn
+}
+
+// Describes the changes made by SMFFile.Repair.
+type RepairSummary struct {
+	// The number of tracks with a different number of time deltas than
+	// messages, whose time deltas were padded with zeros or truncated.
+	FixedTimeDeltas int
+	// The number of events that were removed because they couldn't be
+	// written.
+	DroppedEvents int
+	// The number of tracks whose events had to be sorted by time.
+	SortedTracks int
+	// The number of notes that were never ended, and were given note-offs at
+	// the end of their track.
+	EndedNotes int
+	// The number of tracks that were given an end-of-track event.
+	AddedEndOfTrack int
+}
+
+// Returns true if the summary doesn't describe any changes.
+func (s *RepairSummary) IsEmpty() bool {
+	return (s.FixedTimeDeltas == 0) && (s.DroppedEvents == 0) &&
+		(s.SortedTracks == 0) && (s.EndedNotes == 0) &&
+		(s.AddedEndOfTrack == 0)
+}
+
+// Returns true if the message can't be written to an SMF file, e.g. because
+// it contains out-of-range data.
+func isUnwritableEvent(m MIDIMessage) bool {
+	runningStatus := byte(0)
+	_, e := m.SMFData(&runningStatus)
+	return e != nil
+}
+
+// Fixes common structural problems in each track of the file, so that it can
+// be written and played correctly. In order, this pads (with zeros) or
+// truncates the time deltas to match the number of messages, sorts the events
+// by time, removes any events that can't be written (such as those containing
+// out-of-range data), adds note-offs at the end of the track for any notes
+// that are never ended, and adds an end-of-track event if the track doesn't
+// have one. UndefinedSystemEvents are kept, since they can be written back
+// unchanged. Returns a summary of the changes that were made.
+func (f *SMFFile) Repair() RepairSummary {
+	var toReturn RepairSummary
+	for _, t := range f.Tracks {
+		if len(t.TimeDeltas) != len(t.Messages) {
+			deltas := make([]uint32, len(t.Messages))
+			copy(deltas, t.TimeDeltas)
+			t.TimeDeltas = deltas
+			toReturn.FixedTimeDeltas++
+		}
+		if !t.IsTimeOrdered() {
+			t.SortByTime()
+			toReturn.SortedTracks++
+		}
+		removed := make(map[int]bool)
+		for i, m := range t.Messages {
+			if isUnwritableEvent(m) {
+				removed[i] = true
+			}
+		}
+		if len(removed) != 0 {
+			t.insertAndRemove(nil, removed)
+			toReturn.DroppedEvents += len(removed)
+		}
+		events := t.timedMessages()
+		endTick := uint32(0)
+		hasEndOfTrack := false
+		for _, v := range events {
+			if v.tick > endTick {
+				endTick = v.tick
+			}
+			if _, isEnd := v.message.(EndOfTrackMetaEvent); isEnd {
+				hasEndOfTrack = true
+			}
+		}
+		modified := false
+		for _, p := range t.pairNotes() {
+			if p.offIndex >= 0 {
+				continue
+			}
+			noteOn := events[p.onIndex].message.(*NoteOnEvent)
+			events = append(events, timedMessage{
+				tick: endTick,
+				message: newNoteOffLike(noteOn, noteOn.Channel,
+					noteOn.Note),
+			})
+			toReturn.EndedNotes++
+			modified = true
+		}
+		if !hasEndOfTrack {
+			events = append(events, timedMessage{
+				tick:    endTick,
+				message: EndOfTrackMetaEvent(0),
+			})
+			toReturn.AddedEndOfTrack++
+			modified = true
+		}
+		if modified {
+			t.setTimedMessages(events)
+		}
+	}
+	return toReturn
+}
@@ -0,0 +1,180 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 200, Velocity: 100},
+					UndefinedSystemEvent(0xf5),
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 0},
+				},
+				TimeDeltas: []uint32{0, 0, 0, 10, 10},
+			},
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+		},
+	}
+	summary := smf.Repair()
+	expected := RepairSummary{
+		DroppedEvents:   1,
+		SortedTracks:    0,
+		EndedNotes:      1,
+		AddedEndOfTrack: 1,
+	}
+	if summary != expected {
+		t.Logf("Expected summary %+v, got %+v\n", expected, summary)
+		t.FailNow()
+	}
+	track := smf.Tracks[0]
+	if len(track.Messages) != 6 {
+		t.Logf("Expected 6 events after repairing, got %d\n",
+			len(track.Messages))
+		t.FailNow()
+	}
+	// The undefined system event can be written, so it should be kept.
+	if _, ok := track.Messages[1].(UndefinedSystemEvent); !ok {
+		t.Logf("Expected the undefined system event to be kept, got %s\n",
+			track.Messages[1])
+		t.FailNow()
+	}
+	notes := smf.Notes()
+	if (len(notes) != 2) || (notes[0].Duration != 20) {
+		t.Logf("The hanging note wasn't ended correctly: %+v\n", notes)
+		t.FailNow()
+	}
+	_, e := smf.Bytes()
+	if e != nil {
+		t.Logf("Failed writing repaired file: %s\n", e)
+		t.FailNow()
+	}
+	summary = smf.Repair()
+	if !summary.IsEmpty() {
+		t.Logf("Repairing a second time made changes: %+v\n", summary)
+		t.FailNow()
+	}
+}
+
+func TestRepairTimeDeltas(t *testing.T) {
+	// The first track is missing time deltas, and the second has too many.
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 0},
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 96},
+			},
+			{
+				Messages:   []MIDIMessage{EndOfTrackMetaEvent(0)},
+				TimeDeltas: []uint32{10, 20, 30},
+			},
+		},
+	}
+	summary := smf.Repair()
+	if summary.FixedTimeDeltas != 2 {
+		t.Logf("Expected time deltas to be fixed in 2 tracks, got %+v\n",
+			summary)
+		t.FailNow()
+	}
+	for i, track := range smf.Tracks {
+		if len(track.TimeDeltas) != len(track.Messages) {
+			t.Logf("Track %d still has %d messages but %d time deltas\n", i,
+				len(track.Messages), len(track.TimeDeltas))
+			t.FailNow()
+		}
+	}
+	if (smf.Tracks[0].TimeDeltas[2] != 0) ||
+		(smf.Tracks[1].TimeDeltas[0] != 10) {
+		t.Logf("The time deltas weren't padded or truncated correctly\n")
+		t.FailNow()
+	}
+	if len(smf.Validate()) != 0 {
+		t.Logf("The repaired file still has problems: %v\n", smf.Validate())
+		t.FailNow()
+	}
+}
+
+func TestValidate(t *testing.T) {
+	smf := &SMFFile{
+		Division: TimeDivision(96),
+		Tracks: []*SMFTrack{
+			{
+				Messages: []MIDIMessage{
+					&NoteOnEvent{Channel: 0, Note: 60, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 200, Velocity: 100},
+					EndOfTrackMetaEvent(0),
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 100},
+					&NoteOnEvent{Channel: 0, Note: 62, Velocity: 0},
+				},
+				TimeDeltas: []uint32{0, 0, 0, 10, 10},
+			},
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0},
+			},
+			{
+				Messages: []MIDIMessage{
+					SetTempoMetaEvent(500000),
+					EndOfTrackMetaEvent(0),
+				},
+				TimeDeltas: []uint32{0, 0},
+			},
+		},
+	}
+	issues := smf.Validate()
+	for _, issue := range issues {
+		t.Logf("Got issue: %s\n", issue.String())
+	}
+	expected := []ValidationIssue{
+		{Track: 0, Index: 1},
+		{Track: 0, Index: 3},
+		{Track: 0, Index: 0},
+		{Track: 0, Index: 1},
+		{Track: 1, Index: -1},
+	}
+	if len(issues) != len(expected) {
+		t.Logf("Expected %d issues, got %d\n", len(expected), len(issues))
+		t.FailNow()
+	}
+	for i, issue := range issues {
+		if (issue.Track != expected[i].Track) ||
+			(issue.Index != expected[i].Index) {
+			t.Logf("Expected issue %d to be at track %d, event %d\n", i,
+				expected[i].Track, expected[i].Index)
+			t.FailNow()
+		}
+	}
+	smf.Tracks = smf.Tracks[2:]
+	issues = smf.Validate()
+	if issues != nil {
+		t.Logf("Got unexpected issues for a valid file: %v\n", issues)
+		t.FailNow()
+	}
+	smf.Tracks = nil
+	issues = smf.Validate()
+	if (len(issues) != 1) || (issues[0].Track != -1) {
+		t.Logf("Expected one issue for a file without tracks, got %v\n",
+			issues)
+		t.FailNow()
+	}
+	t.Logf("Got issue for a file without tracks: %s\n", issues[0].String())
+}